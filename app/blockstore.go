@@ -1,7 +1,6 @@
 package app
 
 import (
-	"crypto/rand"
 	"fmt"
 
 	"github.com/jephir/tradeblocks"
@@ -10,91 +9,118 @@ import (
 
 // BlockStore is a concurrency-safe block store
 type BlockStore struct {
-	db *db.DB
+	db db.Store
 }
 
-// NewBlockStore allocates and returns a new BlockStore
+// NewBlockStore allocates and returns a new in-memory BlockStore
 func NewBlockStore() *BlockStore {
-	x := randString(16)
-	s := fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=true", x)
-	db, err := db.NewDB(s)
-	if err != nil {
-		panic(err)
-	}
-	return &BlockStore{
-		db: db,
-	}
+	return NewBlockStoreWithStore(db.NewMemoryStore())
 }
 
-// https://stackoverflow.com/a/12772666
-func randString(n int) string {
-	const alphanum = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	var bytes = make([]byte, n)
-	rand.Read(bytes)
-	for i, b := range bytes {
-		bytes[i] = alphanum[b%byte(len(alphanum))]
+// NewBlockStoreWithStore allocates and returns a BlockStore backed by the specified store
+func NewBlockStoreWithStore(store db.Store) *BlockStore {
+	return &BlockStore{
+		db: store,
 	}
-	return string(bytes)
 }
 
 // NewPersistBlockStore allocates and returns a persistent block store
 func NewPersistBlockStore(file string) (*BlockStore, error) {
-	s := fmt.Sprintf("file:%s?_foreign_keys=true", file)
-	db, err := db.NewDB(s)
+	db, err := db.NewDB(db.SqliteDataSource(file))
 	if err != nil {
 		return nil, err
 	}
-	return &BlockStore{
-		db: db,
-	}, nil
+	return NewBlockStoreWithStore(db), nil
 }
 
-// AddAccountBlock verifies and adds the specified account block to this store
-func (s *BlockStore) AddAccountBlock(b *tradeblocks.AccountBlock) error {
-	if err := ValidateAccountBlock(s, b); err != nil {
-		return err
+// OpenBlockStore opens a persistent block store in a PostgreSQL database if dataSource is a postgres:// URL,
+// or in a sqlite database file otherwise
+func OpenBlockStore(dataSource string) (*BlockStore, error) {
+	if !db.IsPostgres(dataSource) {
+		return NewPersistBlockStore(dataSource)
 	}
-	tx, err := s.db.NewTransaction()
+	db, err := db.NewDB(dataSource)
 	if err != nil {
-		return err
-	}
-	defer tx.Commit()
-	if err := tx.InsertAccountBlock(b); err != nil {
-		return err
+		return nil, err
 	}
-	return tx.Commit()
+	return NewBlockStoreWithStore(db), nil
+}
+
+// AddAccountBlock verifies and adds the specified account block to this store
+func (s *BlockStore) AddAccountBlock(b *tradeblocks.AccountBlock) error {
+	return s.add(b)
 }
 
 // AddSwapBlock verifies and adds the specified swap block to this store
 func (s *BlockStore) AddSwapBlock(b *tradeblocks.SwapBlock) error {
-	if err := ValidateSwapBlock(s, b); err != nil {
-		return err
-	}
-	tx, err := s.db.NewTransaction()
-	if err != nil {
-		return err
-	}
-	defer tx.Commit()
-	if err := tx.InsertSwapBlock(b); err != nil {
-		return err
-	}
-	return tx.Commit()
+	return s.add(b)
 }
 
 // AddOrderBlock verifies and adds the specified order block to this store
 func (s *BlockStore) AddOrderBlock(b *tradeblocks.OrderBlock) error {
-	if err := ValidateOrderBlock(s, b); err != nil {
-		return err
-	}
+	return s.add(b)
+}
+
+// add verifies and adds an account, swap or order block
+func (s *BlockStore) add(b tradeblocks.Block) error {
+	err := s.write(func(tx db.Tx) error {
+		if err := checkBlock(txReader{tx}, b); err != nil {
+			return err
+		}
+		return insertBlock(tx, b)
+	})
+	countBlock(b, err)
+	return err
+}
+
+// AddBlocks verifies and adds a batch of account, swap and order blocks in order in one transaction. Each block
+// is validated against the store and the blocks before it in the batch; no block is added if any of them fails.
+func (s *BlockStore) AddBlocks(blocks []tradeblocks.Block) error {
+	err := s.write(func(tx db.Tx) error {
+		return addBatch(tx, blocks)
+	})
+	countBatch(blocks, err)
+	return err
+}
+
+// CheckBlocks validates a batch of blocks like AddBlocks without adding them
+func (s *BlockStore) CheckBlocks(blocks []tradeblocks.Block) error {
 	tx, err := s.db.NewTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
-	if err := tx.InsertOrderBlock(b); err != nil {
-		return err
+	defer tx.Rollback()
+	return addBatch(tx, blocks)
+}
+
+func addBatch(tx db.Tx, blocks []tradeblocks.Block) error {
+	for i, b := range blocks {
+		if err := checkBlock(txReader{tx}, b); err != nil {
+			return &BatchError{Index: i, Hash: b.Hash(), Err: err}
+		}
+		if err := insertBlock(tx, b); err != nil {
+			return &BatchError{Index: i, Hash: b.Hash(), Err: err}
+		}
 	}
-	return tx.Commit()
+	return nil
+}
+
+// BatchError is returned when a block of a batch can't be added
+type BatchError struct {
+	Index int    // position of the block in the batch
+	Hash  string // hash of the block
+	Err   error  // why the block can't be added, such as a *ValidationError
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("app: block %d (%s) of batch: %s", e.Index, e.Hash, e.Err.Error())
+}
+
+// CheckBlock validates the specified account, swap or order block against this store without adding it
+func (s *BlockStore) CheckBlock(b tradeblocks.Block) error {
+	return s.View(func(tx db.ReadTx) error {
+		return checkBlock(txReader{tx}, b)
+	})
 }
 
 // AddConfirmBlock verifies and adds the specified confirm block to this store
@@ -102,96 +128,172 @@ func (s *BlockStore) AddConfirmBlock(b *tradeblocks.ConfirmBlock) error {
 	// if err := ValidateConfirmBlock(s, b); err != nil {
 	// 	return err
 	// }
-	tx, err := s.db.NewTransaction()
+	err := s.write(func(tx db.Tx) error {
+		return tx.InsertConfirmBlock(b)
+	})
+	countBlock(b, err)
+	return err
+}
+
+// View runs fn in a read transaction, so fn sees the blocks of this store at one point in time
+func (s *BlockStore) View(fn func(tx db.ReadTx) error) error {
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
 	defer tx.Commit()
-	if err := tx.InsertConfirmBlock(b); err != nil {
+	return fn(tx)
+}
+
+// Restore runs fn in a write transaction and commits it if fn succeeds. fn may insert blocks from a trusted
+// source without validating them; none of them are added if fn returns an error.
+func (s *BlockStore) Restore(fn func(tx db.Tx) error) error {
+	return s.write(fn)
+}
+
+// write runs fn in a write transaction, so fn sees no changes by other writers until it returns,
+// and commits the transaction if fn succeeds or rolls it back otherwise
+func (s *BlockStore) write(fn func(tx db.Tx) error) error {
+	tx, err := s.db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
 		return err
 	}
 	return tx.Commit()
 }
 
-// AccountBlocks calls the specified function with every block in this store. Return false to stop iteration.
+// AccountBlocks calls the specified function with every block in this store in sequence order. Return false to stop iteration.
 func (s *BlockStore) AccountBlocks(f func(sequence int, b *tradeblocks.AccountBlock) bool) error {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
-	blocks, err := tx.GetAccountBlocks()
+	blocks, err := tx.QueryAccountBlocks(db.BlockFilter{})
+	tx.Commit()
 	if err != nil {
 		return err
 	}
-	for i, b := range blocks {
-		if !f(i, b) {
+	for _, b := range blocks {
+		if !f(b.Sequence, b.AccountBlock) {
 			return nil
 		}
 	}
 	return nil
 }
 
-// SwapBlocks calls the specified function with every block in this store. Return false to stop iteration.
+// SwapBlocks calls the specified function with every block in this store in sequence order. Return false to stop iteration.
 func (s *BlockStore) SwapBlocks(f func(sequence int, b *tradeblocks.SwapBlock) bool) error {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
-	blocks, err := tx.GetSwapBlocks()
+	blocks, err := tx.QuerySwapBlocks(db.BlockFilter{})
+	tx.Commit()
 	if err != nil {
 		return err
 	}
-	for i, b := range blocks {
-		if !f(i, b) {
+	for _, b := range blocks {
+		if !f(b.Sequence, b.SwapBlock) {
 			return nil
 		}
 	}
 	return nil
 }
 
-// OrderBlocks calls the specified function with every block in this store. Return false to stop iteration.
+// OrderBlocks calls the specified function with every block in this store in sequence order. Return false to stop iteration.
 func (s *BlockStore) OrderBlocks(f func(sequence int, b *tradeblocks.OrderBlock) bool) error {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
-	blocks, err := tx.GetOrderBlocks()
+	blocks, err := tx.QueryOrderBlocks(db.BlockFilter{})
+	tx.Commit()
 	if err != nil {
 		return err
 	}
-	for i, b := range blocks {
-		if !f(i, b) {
+	for _, b := range blocks {
+		if !f(b.Sequence, b.OrderBlock) {
 			return nil
 		}
 	}
 	return nil
 }
 
-// Blocks calls the specified function with every block in this store. Return false to stop iteration.
+// QueryAccountBlocks returns the account blocks matching the specified filter in sequence order
+func (s *BlockStore) QueryAccountBlocks(f db.BlockFilter) ([]tradeblocks.NetworkAccountBlock, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	return tx.QueryAccountBlocks(f)
+}
+
+// QuerySwapBlocks returns the swap blocks matching the specified filter in sequence order
+func (s *BlockStore) QuerySwapBlocks(f db.BlockFilter) ([]tradeblocks.NetworkSwapBlock, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	return tx.QuerySwapBlocks(f)
+}
+
+// QueryOrderBlocks returns the order blocks matching the specified filter in sequence order
+func (s *BlockStore) QueryOrderBlocks(f db.BlockFilter) ([]tradeblocks.NetworkOrderBlock, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	return tx.QueryOrderBlocks(f)
+}
+
+// Blocks calls the specified function with every block in this store in sequence order. Return false to stop iteration.
 func (s *BlockStore) Blocks(f func(sequence int, b tradeblocks.Block) bool) error {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
-	blocks, err := tx.GetBlocks()
+	blocks, err := tx.QueryBlocks(0, 0)
+	tx.Commit()
 	if err != nil {
 		return err
 	}
-	for i, b := range blocks {
-		if !f(i, b) {
+	for _, b := range blocks {
+		if !f(b.Sequence, b.Block) {
 			return nil
 		}
 	}
 	return nil
 }
 
+// QueryBlocks returns up to limit blocks of every type with a sequence greater than since, in sequence order
+func (s *BlockStore) QueryBlocks(since, limit int) ([]tradeblocks.NetworkBlock, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	return tx.QueryBlocks(since, limit)
+}
+
+// LastSequence returns the sequence of the most recently added block or zero if this store is empty
+func (s *BlockStore) LastSequence() (int, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Commit()
+	return tx.LastSequence()
+}
+
 // Block returns the block with the specified hash or nil if it's not found
 func (s *BlockStore) Block(hash string) (tradeblocks.Block, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -202,7 +304,7 @@ func (s *BlockStore) Block(hash string) (tradeblocks.Block, error) {
 
 // BlockWithTag returns the block with the specified hash or nil if it's not found
 func (s *BlockStore) BlockWithTag(hash string) (int, tradeblocks.Block, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return 0, nil, err
 	}
@@ -212,7 +314,7 @@ func (s *BlockStore) BlockWithTag(hash string) (int, tradeblocks.Block, error) {
 
 // GetAccountBlock returns the account block for the specified hash or nil if it's not found
 func (s *BlockStore) GetAccountBlock(hash string) (*tradeblocks.AccountBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -222,7 +324,7 @@ func (s *BlockStore) GetAccountBlock(hash string) (*tradeblocks.AccountBlock, er
 
 // GetSwapBlock returns the swap block for the specified hash or nil if it's not found
 func (s *BlockStore) GetSwapBlock(hash string) (*tradeblocks.SwapBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +334,7 @@ func (s *BlockStore) GetSwapBlock(hash string) (*tradeblocks.SwapBlock, error) {
 
 // GetOrderBlock returns the order block for the specified hash or nil if it's not found
 func (s *BlockStore) GetOrderBlock(hash string) (*tradeblocks.OrderBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +344,7 @@ func (s *BlockStore) GetOrderBlock(hash string) (*tradeblocks.OrderBlock, error)
 
 // GetAccountHead returns the head block for the specified account-token pair
 func (s *BlockStore) GetAccountHead(account, token string) (*tradeblocks.AccountBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -252,7 +354,7 @@ func (s *BlockStore) GetAccountHead(account, token string) (*tradeblocks.Account
 
 // GetSwapHead returns the head block for the specified account-id pair
 func (s *BlockStore) GetSwapHead(account, id string) (*tradeblocks.SwapBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -262,7 +364,7 @@ func (s *BlockStore) GetSwapHead(account, id string) (*tradeblocks.SwapBlock, er
 
 // GetOrderHead returns the head block for the specified account-id pair
 func (s *BlockStore) GetOrderHead(account, id string) (*tradeblocks.OrderBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -272,7 +374,7 @@ func (s *BlockStore) GetOrderHead(account, id string) (*tradeblocks.OrderBlock,
 
 // GetConfirmHead returns the head block for the specified account-address pair
 func (s *BlockStore) GetConfirmHead(account, address string) (*tradeblocks.ConfirmBlock, error) {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return nil, err
 	}
@@ -282,12 +384,12 @@ func (s *BlockStore) GetConfirmHead(account, address string) (*tradeblocks.Confi
 
 // MatchOrdersForBuy returns orders that meet the specified criteria
 func (s *BlockStore) MatchOrdersForBuy(base string, ppu float64, quote string, f func(b *tradeblocks.OrderBlock)) error {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
 	blocks, err := tx.GetLimitOrders(base, "<=", ppu, quote)
+	tx.Commit()
 	if err != nil {
 		return err
 	}
@@ -299,12 +401,12 @@ func (s *BlockStore) MatchOrdersForBuy(base string, ppu float64, quote string, f
 
 // MatchOrdersForSell returns orders that meet the specified criteria
 func (s *BlockStore) MatchOrdersForSell(base string, ppu float64, quote string, f func(b *tradeblocks.OrderBlock)) error {
-	tx, err := s.db.NewTransaction()
+	tx, err := s.db.NewReadTransaction()
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
 	blocks, err := tx.GetLimitOrders(base, ">=", ppu, quote)
+	tx.Commit()
 	if err != nil {
 		return err
 	}
@@ -314,6 +416,16 @@ func (s *BlockStore) MatchOrdersForSell(base string, ppu float64, quote string,
 	return nil
 }
 
+// GetClaimBlocks returns the open and receive blocks of every account that claim link
+func (s *BlockStore) GetClaimBlocks(link string) ([]*tradeblocks.AccountBlock, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	return tx.GetClaimBlocks(link)
+}
+
 // GetVariableBlock returns a block of any block type. Used currently for receive Links
 // which can link to sendor commit swap
 func (s *BlockStore) GetVariableBlock(hash string) (tradeblocks.Block, error) {
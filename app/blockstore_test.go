@@ -7,9 +7,16 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 
 	tb "github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/db"
 )
 
 func TestBlockStore(t *testing.T) {
@@ -77,6 +84,212 @@ func TestDoubleSpend(t *testing.T) {
 	}
 }
 
+func TestConcurrentClaims(t *testing.T) {
+	p1, a1 := CreateAccount(t)
+	p2, a2 := CreateAccount(t)
+	issue, err := tb.SignedAccountBlock(tb.NewIssueBlock(a1, 100), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tb.SignedAccountBlock(tb.NewSendBlock(issue, a2, 10), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p3, a3 := CreateAccount(t)
+	send3, err := tb.SignedAccountBlock(tb.NewSendBlock(send, a3, 10), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open3, err := tb.SignedAccountBlock(tb.NewOpenBlockFromSend(a3, send3, 10), p3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steal, err := tb.SignedAccountBlock(tb.NewReceiveBlockFromSend(open3, send, 10), p3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every open claims the same send but has a different hash
+	const claims = 32
+	opens := make([]*tb.AccountBlock, claims)
+	for i := range opens {
+		open := tb.NewOpenBlockFromSend(a2, send, 10)
+		open.Representative = fmt.Sprintf("xtb:representative%d", i)
+		if opens[i], err = tb.SignedAccountBlock(open, p2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.NewDB(db.SqliteDataSource(filepath.Join(dir, "tradeblocks.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for name, store := range map[string]db.Store{"memory": db.NewMemoryStore(), "sqlite": d} {
+		t.Run(name, func(t *testing.T) {
+			s := NewBlockStoreWithStore(yieldStore{store})
+			if err := s.AddAccountBlock(issue); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddAccountBlock(send); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var added []*tb.AccountBlock
+			start := make(chan struct{})
+			for _, open := range opens {
+				wg.Add(1)
+				go func(open *tb.AccountBlock) {
+					defer wg.Done()
+					<-start
+					if err := s.AddAccountBlock(open); err == nil {
+						mu.Lock()
+						added = append(added, open)
+						mu.Unlock()
+					}
+				}(open)
+			}
+			close(start)
+			wg.Wait()
+
+			if len(added) != 1 {
+				t.Fatalf("expected exactly one claim of the send to succeed, got %d", len(added))
+			}
+			claims, err := s.GetClaimBlocks(send.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(claims) != 1 || claims[0].Hash() != added[0].Hash() {
+				t.Fatalf("expected claim %s, got %v", added[0].Hash(), claims)
+			}
+
+			// Another account with a chain for the token can't claim the send too
+			if err := s.AddAccountBlock(send3); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddAccountBlock(open3); err != nil {
+				t.Fatal(err)
+			}
+			err = s.AddAccountBlock(steal)
+			if ve, ok := err.(*ValidationError); !ok || ve.Code != CodeWrongRecipient {
+				t.Fatalf("expected %s, got %v", CodeWrongRecipient, err)
+			}
+		})
+	}
+}
+
+func TestAddBlocks(t *testing.T) {
+	p, a := CreateAccount(t)
+	issue, err := tb.SignedAccountBlock(tb.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tb.SignedAccountBlock(tb.NewSendBlock(issue, a, 10), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdraft, err := tb.SignedAccountBlock(tb.NewSendBlock(send, a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.NewDB(db.SqliteDataSource(filepath.Join(dir, "tradeblocks.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for name, store := range map[string]db.Store{"memory": db.NewMemoryStore(), "sqlite": d} {
+		t.Run(name, func(t *testing.T) {
+			s := NewBlockStoreWithStore(store)
+
+			// The send is validated against the issue before it in the batch, and nothing is added if a block fails
+			err := s.AddBlocks([]tb.Block{issue, send, overdraft})
+			be, ok := err.(*BatchError)
+			if !ok || be.Index != 2 || be.Hash != overdraft.Hash() {
+				t.Fatalf("expected a batch error of block 2, got %v", err)
+			}
+			if e, ok := be.Err.(*ValidationError); !ok || e.Code != CodeNegativeBalance {
+				t.Fatalf("expected %s, got %v", CodeNegativeBalance, be.Err)
+			}
+			if _, err := s.GetAccountBlock(issue.Hash()); err != db.ErrNotFound {
+				t.Fatalf("expected %v, got %v", db.ErrNotFound, err)
+			}
+
+			if err := s.CheckBlocks([]tb.Block{issue, send}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.GetAccountBlock(issue.Hash()); err != db.ErrNotFound {
+				t.Fatalf("expected %v, got %v", db.ErrNotFound, err)
+			}
+
+			if err := s.AddBlocks([]tb.Block{issue, send}); err != nil {
+				t.Fatal(err)
+			}
+			head, err := s.GetAccountHead(a, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head.Hash() != send.Hash() {
+				t.Fatalf("expected head %s, got %s", send.Hash(), head.Hash())
+			}
+		})
+	}
+}
+
+// yieldStore lets other goroutines run before every claim lookup, so racing validations interleave
+type yieldStore struct {
+	db.Store
+}
+
+func (s yieldStore) NewTransaction() (db.Tx, error) {
+	tx, err := s.Store.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	return yieldTx{tx}, nil
+}
+
+func (s yieldStore) NewReadTransaction() (db.ReadTx, error) {
+	tx, err := s.Store.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	return yieldReadTx{tx}, nil
+}
+
+type yieldTx struct {
+	db.Tx
+}
+
+func (tx yieldTx) GetClaimBlocks(link string) ([]*tb.AccountBlock, error) {
+	runtime.Gosched()
+	return tx.Tx.GetClaimBlocks(link)
+}
+
+type yieldReadTx struct {
+	db.ReadTx
+}
+
+func (tx yieldReadTx) GetClaimBlocks(link string) ([]*tb.AccountBlock, error) {
+	runtime.Gosched()
+	return tx.ReadTx.GetClaimBlocks(link)
+}
+
 func GetAddress() (*rsa.PrivateKey, string, error) {
 	var key, err = rsa.GenerateKey(rand.Reader, 512)
 	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
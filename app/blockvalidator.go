@@ -2,15 +2,35 @@ package app
 
 import (
 	"crypto/rsa"
-	"errors"
 	"fmt"
 
 	tb "github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/db"
 )
 
+// BlockReader looks up the blocks that validators check against. It's implemented by BlockStore and by the write
+// transaction that inserts the validated block.
+type BlockReader interface {
+	GetAccountBlock(hash string) (*tb.AccountBlock, error)
+	GetSwapBlock(hash string) (*tb.SwapBlock, error)
+	GetOrderBlock(hash string) (*tb.OrderBlock, error)
+	GetSwapHead(account, id string) (*tb.SwapBlock, error)
+	GetVariableBlock(hash string) (tb.Block, error)
+	GetClaimBlocks(link string) ([]*tb.AccountBlock, error)
+}
+
+// txReader reads blocks through a store transaction
+type txReader struct {
+	db.ReadTx
+}
+
+func (r txReader) GetVariableBlock(hash string) (tb.Block, error) {
+	_, b, err := r.GetBlock(hash)
+	return b, err
+}
+
 // ValidateAccountBlock returns an error if validation fails for the specified account block
-func ValidateAccountBlock(c *BlockStore, b *tb.AccountBlock) error {
+func ValidateAccountBlock(c BlockReader, b *tb.AccountBlock) error {
 	var v AccountBlockValidator
 	switch b.Action {
 	case "open":
@@ -22,23 +42,36 @@ func ValidateAccountBlock(c *BlockStore, b *tb.AccountBlock) error {
 	case "receive":
 		v = NewReceiveValidator(c)
 	default:
-		return fmt.Errorf("blockvalidator: unknown action '%s'", b.Action)
+		return invalid(CodeInvalidAction, "Action", fmt.Sprintf("unknown account action '%s'", b.Action))
 	}
 	return v.ValidateAccountBlock(b)
 }
 
 // ValidateSwapBlock returns an error if validation fails for the specified swap block
-func ValidateSwapBlock(c *BlockStore, b *tb.SwapBlock) error {
+func ValidateSwapBlock(c BlockReader, b *tb.SwapBlock) error {
 	v := NewSwapValidator(c)
 	return v.ValidateSwapBlock(b)
 }
 
 // ValidateOrderBlock returns an error if validation fails for the specified Order block
-func ValidateOrderBlock(c *BlockStore, b *tb.OrderBlock) error {
+func ValidateOrderBlock(c BlockReader, b *tb.OrderBlock) error {
 	v := NewOrderValidator(c)
 	return v.ValidateOrderBlock(b)
 }
 
+// validateBlock returns an error if validation fails for the specified account, swap or order block
+func validateBlock(c BlockReader, b tb.Block) error {
+	switch b := b.(type) {
+	case *tb.AccountBlock:
+		return ValidateAccountBlock(c, b)
+	case *tb.SwapBlock:
+		return ValidateSwapBlock(c, b)
+	case *tb.OrderBlock:
+		return ValidateOrderBlock(c, b)
+	}
+	return fmt.Errorf("app: can't validate block type %T", b)
+}
+
 // AccountBlockValidator to do server validation of each AccountBlock sent in
 // see ../blockgraph.go for details on AccountBlock types
 type AccountBlockValidator interface {
@@ -48,11 +81,11 @@ type AccountBlockValidator interface {
 
 // OpenBlockValidator is a validator for OpenBlocks
 type OpenBlockValidator struct {
-	blockStore *BlockStore
+	blockStore BlockReader
 }
 
 // NewOpenValidator returns a new validator with the given chain
-func NewOpenValidator(chain *BlockStore) *OpenBlockValidator {
+func NewOpenValidator(chain BlockReader) *OpenBlockValidator {
 	return &OpenBlockValidator{
 		blockStore: chain,
 	}
@@ -64,25 +97,19 @@ func (validator OpenBlockValidator) ValidateAccountBlock(block *tb.AccountBlock)
 
 	//get the chain
 	blockStore := validator.blockStore
-	publicKey, err := AddressToRSAKey(block.Account)
-	if err != nil {
-		return err
-	}
-
-	if err := block.VerifyBlock(publicKey); err != nil {
+	if err := verifyBlockSignature(block.VerifyBlock, "Account", block.Account); err != nil {
 		return err
 	}
 
 	// check if the previous exists, don't care if it does
-	_, err = getAndVerifyAccount(block.Previous, blockStore)
-	if err == nil {
-		return errors.New("previous field was not null")
+	if _, err := getAndVerifyAccount(block.Previous, blockStore); err == nil {
+		return invalid(CodeInvalidPrevious, "Previous", "previous of an open block must be empty", block.Previous)
 	}
 
 	// check if the block referenced exists, get it if it does
 	link, err := blockStore.GetVariableBlock(block.Link)
 	if err == db.ErrNotFound {
-		return errors.New("link field references invalid block")
+		return invalid(CodeInvalidLink, "Link", "linked block not found", block.Link)
 	}
 	if err != nil {
 		return err
@@ -94,96 +121,101 @@ func (validator OpenBlockValidator) ValidateAccountBlock(block *tb.AccountBlock)
 		// get the previous of the send to get balance
 		sendBlockPrev, err := getAndVerifyAccount(link.Previous, blockStore)
 		if err != nil || sendBlockPrev == nil {
-			return errors.New("send has no previous")
+			return invalid(CodeInvalidLink, "Link", "linked send has no valid previous block", block.Link, link.Previous)
 		}
 
 		// check if the balances match
 		if block.Balance < 0 {
-			return errors.New("Balance must be positive")
+			return invalid(CodeNegativeBalance, "Balance", "balance must not be negative")
 		}
 		sendBalance := sendBlockPrev.Balance - link.Balance
 		if sendBalance != block.Balance {
-			//return errors.New("balance does not match")
-			return fmt.Errorf("balance expected %f; got %f", block.Balance, sendBalance)
+			return invalid(CodeBalanceMismatch, "Balance", fmt.Sprintf("balance expected %f; got %f", block.Balance, sendBalance), block.Link)
 		}
 
 		// check the send block references the right key pair
 		if link.Link != block.Account {
-			return fmt.Errorf("send link '%s' does not reference account '%s'", link.Link, block.Account)
+			return invalid(CodeWrongRecipient, "Link", fmt.Sprintf("send link '%s' does not reference account '%s'", link.Link, block.Account), block.Link)
 		}
 	// swap case
 	case *tb.SwapBlock:
 		// If this errors there is an invalid block on the chain. Panic
 		rightBlock, err := getAndVerifyAccount(link.Right, blockStore)
 		if err != nil || rightBlock == nil {
-			return errors.New("Right of linked swap is invalid")
+			return invalid(CodeInvalidLink, "Link", "right of linked swap is invalid", block.Link, link.Right)
 		}
 		// If this errors there is an invalid block on the chain. Panic
 		leftBlock, err := getAndVerifyAccount(link.Left, blockStore)
 		if err != nil || leftBlock == nil {
-			return errors.New("Left of linked swap is invalid")
+			return invalid(CodeInvalidLink, "Link", "left of linked swap is invalid", block.Link, link.Left)
 		}
 		// if same account, opener is the swap offerer
 		// means we take from the right send
 		if account == link.Account {
 			// make sure correct account is claiming tokens
 			if account != leftBlock.Account {
-				return errors.New("Account mismatch between receiver and sender")
+				return invalid(CodeAccountMismatch, "Account", "account doesn't match the sender of the left of the linked swap", block.Link, leftBlock.Hash())
 			}
 
 			// check if the token types match
 			if block.Token != rightBlock.Token {
-				return errors.New("Can't receive different token types")
+				return invalid(CodeTokenMismatch, "Token", "token doesn't match the token of the right of the linked swap", block.Link, rightBlock.Hash())
 			}
 
 			// If this errors there is an invalid block on the chain. Panic
 			rightPrevBlock, err := getAndVerifyAccount(rightBlock.Previous, blockStore)
 			if err != nil || rightPrevBlock == nil {
-				return errors.New("Previous of right of linked swap is invalid")
+				return invalid(CodeInvalidLink, "Link", "previous of the right of linked swap is invalid", block.Link, rightBlock.Hash())
 			}
 
 			// check if the balances match
 			balSent := rightPrevBlock.Balance - rightBlock.Balance
 			balRec := block.Balance
 			if balRec != balSent {
-				return errors.New("Mismatched balances receiving by offerer")
+				return invalid(CodeBalanceMismatch, "Balance", "balance doesn't match the amount sent by the counterparty", block.Link, rightBlock.Hash())
 			}
 		} else { // opener is the commiter, take from left send
 			// check if receiver is sender of Right
 			if account != rightBlock.Account {
-				return errors.New("Account mismatch between receiver and sender")
+				return invalid(CodeAccountMismatch, "Account", "account doesn't match the sender of the right of the linked swap", block.Link, rightBlock.Hash())
 			}
 
 			// check if the token types match
 			if block.Token != leftBlock.Token {
-				return errors.New("Can't receive different token types")
+				return invalid(CodeTokenMismatch, "Token", "token doesn't match the token of the left of the linked swap", block.Link, leftBlock.Hash())
 			}
 			// get the prev of the left block. Should only error if the store is corrupt
 			leftPrevBlock, err := getAndVerifyAccount(leftBlock.Previous, blockStore)
 			if err != nil || leftPrevBlock == nil {
-				return errors.New("Previous of left of linked swap is invalid")
+				return invalid(CodeInvalidLink, "Link", "previous of the left of linked swap is invalid", block.Link, leftBlock.Hash())
 			}
 
 			// check if the balances match
 			balSent := leftPrevBlock.Balance - leftBlock.Balance
 			balRec := block.Balance
 			if balRec != balSent {
-				return errors.New("Mismatched balances receiving by committer")
+				return invalid(CodeBalanceMismatch, "Balance", "balance doesn't match the amount sent by the offerer", block.Link, leftBlock.Hash())
 			}
 		}
 	default:
-		return errors.New("Invalid link type")
+		return invalid(CodeInvalidLink, "Link", "linked block must be a send or a swap", block.Link)
+	}
+
+	// a send can be claimed once, and a swap once by each party
+	_, swap := link.(*tb.SwapBlock)
+	if err := checkUnclaimed(blockStore, block, swap); err != nil {
+		return err
 	}
 	return nil
 }
 
 // IssueBlockValidator is a validator for IssueBlocks
 type IssueBlockValidator struct {
-	blockStore *BlockStore
+	blockStore BlockReader
 }
 
 // NewIssueValidator returns a new validator with the given chain
-func NewIssueValidator(blockStore *BlockStore) *IssueBlockValidator {
+func NewIssueValidator(blockStore BlockReader) *IssueBlockValidator {
 	return &IssueBlockValidator{
 		blockStore: blockStore,
 	}
@@ -194,25 +226,16 @@ func (validator IssueBlockValidator) ValidateAccountBlock(block *tb.AccountBlock
 	// I don't think we need to validate this after creation, this should be spawned
 	// by an account creation, most fields are generated there
 	// No actionable fields to check on, besides signature
-	publicKey, err := AddressToRSAKey(block.Account)
-	if err != nil {
-		return err
-	}
-
-	if err := block.VerifyBlock(publicKey); err != nil {
-		return err
-	}
-
-	return nil
+	return verifyBlockSignature(block.VerifyBlock, "Account", block.Account)
 }
 
 // SendBlockValidator is a validator for SendBlocks
 type SendBlockValidator struct {
-	blockStore *BlockStore
+	blockStore BlockReader
 }
 
 // NewSendValidator returns a new validator with the given chain
-func NewSendValidator(blockStore *BlockStore) *SendBlockValidator {
+func NewSendValidator(blockStore BlockReader) *SendBlockValidator {
 	return &SendBlockValidator{
 		blockStore: blockStore,
 	}
@@ -223,38 +246,33 @@ func (validator SendBlockValidator) ValidateAccountBlock(block *tb.AccountBlock)
 	//get the chain
 	blockStore := validator.blockStore
 
-	publicKey, err := AddressToRSAKey(block.Account)
-	if err != nil {
-		return err
-	}
-
-	if err := block.VerifyBlock(publicKey); err != nil {
+	if err := verifyBlockSignature(block.VerifyBlock, "Account", block.Account); err != nil {
 		return err
 	}
 
 	// check if the previous exists, get it if it does
 	prevBlock, err := getAndVerifyAccount(block.Previous, blockStore)
 	if err != nil {
-		return errors.New("Previous block invalid")
+		return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 	}
 
 	// check if the balances are proper
 	if block.Balance < 0 {
-		return errors.New("Balance must be positive")
+		return invalid(CodeNegativeBalance, "Balance", "balance must not be negative")
 	}
 	if block.Balance > prevBlock.Balance {
-		return errors.New("invalid balance amount")
+		return invalid(CodeInsufficientBalance, "Balance", fmt.Sprintf("balance %f is greater than the previous balance %f", block.Balance, prevBlock.Balance), block.Previous)
 	}
 	return nil
 }
 
 // ReceiveBlockValidator is a validator for ReceiveBlocks
 type ReceiveBlockValidator struct {
-	blockStore *BlockStore
+	blockStore BlockReader
 }
 
 // NewReceiveValidator returns a new validator with the given chain
-func NewReceiveValidator(blockStore *BlockStore) *ReceiveBlockValidator {
+func NewReceiveValidator(blockStore BlockReader) *ReceiveBlockValidator {
 	return &ReceiveBlockValidator{
 		blockStore: blockStore,
 	}
@@ -266,29 +284,24 @@ func (validator ReceiveBlockValidator) ValidateAccountBlock(block *tb.AccountBlo
 	blockStore := validator.blockStore
 	account := block.Account
 
-	publicKey, err := AddressToRSAKey(block.Account)
-	if err != nil {
-		return err
-	}
-
-	if err := block.VerifyBlock(publicKey); err != nil {
+	if err := verifyBlockSignature(block.VerifyBlock, "Account", block.Account); err != nil {
 		return err
 	}
 
 	// check if the previous block exists, get it if it does
 	prevBlock, err := getAndVerifyAccount(block.Previous, blockStore)
 	if err != nil {
-		return errors.New("previous field was invalid")
+		return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 	}
 
 	// check if the block referenced exists, get it if it does
 	link, err := blockStore.GetVariableBlock(block.Link)
+	if err == db.ErrNotFound || (err == nil && link == nil) {
+		return invalid(CodeInvalidLink, "Link", "linked block not found", block.Link)
+	}
 	if err != nil {
 		return err
 	}
-	if link == nil {
-		return errors.New("link field references invalid block")
-	}
 
 	switch b := link.(type) {
 	// send base case
@@ -296,96 +309,101 @@ func (validator ReceiveBlockValidator) ValidateAccountBlock(block *tb.AccountBlo
 		// now need to get the send previous
 		sendPrevBlock, err := getAndVerifyAccount(b.Previous, blockStore)
 		if err != nil || sendPrevBlock == nil {
-			return errors.New("link field's previous references invalid block")
+			return invalid(CodeInvalidLink, "Link", "linked send has no valid previous block", block.Link, b.Previous)
 		}
 
 		// check if the token types match
 		if block.Token != b.Token {
-			return errors.New("Can't receive different token types")
+			return invalid(CodeTokenMismatch, "Token", "token doesn't match the token of the linked send", block.Link)
 		}
 
 		// check if the balances match
 		balSent := sendPrevBlock.Balance - b.Balance
 		balRec := block.Balance - prevBlock.Balance
 		if balRec != balSent {
-			return errors.New("mismatched balances")
+			return invalid(CodeBalanceMismatch, "Balance", fmt.Sprintf("received %f; sent %f", balRec, balSent), block.Link)
 		}
 
 		// check if this is the intended recipient
-		if block.Link != b.Hash() {
-			return errors.New("linked send does not reference this account")
+		if b.Link != block.Account {
+			return invalid(CodeWrongRecipient, "Link", "linked send does not reference this account", block.Link)
 		}
 	// swap case
 	case *tb.SwapBlock:
 		rightBlock, err := getAndVerifyAccount(b.Right, blockStore)
 		if err != nil || rightBlock == nil {
-			return errors.New("Right of linked swap is invalid")
+			return invalid(CodeInvalidLink, "Link", "right of linked swap is invalid", block.Link, b.Right)
 		}
 		leftBlock, err := getAndVerifyAccount(b.Left, blockStore)
 		if err != nil || leftBlock == nil {
-			return errors.New("left of linked swap is invalid")
+			return invalid(CodeInvalidLink, "Link", "left of linked swap is invalid", block.Link, b.Left)
 		}
 		// if same account, receiver is the swap offerer
 		// means we take from the right send
 		if account == b.Account {
 			// check if receiver is sender of Right
 			if account != leftBlock.Account {
-				return errors.New("Account mismatch between receiver and sender")
+				return invalid(CodeAccountMismatch, "Account", "account doesn't match the sender of the left of the linked swap", block.Link, leftBlock.Hash())
 			}
 
 			// check if the token types match
 			if block.Token != rightBlock.Token {
-				return errors.New("Can't receive different token types")
+				return invalid(CodeTokenMismatch, "Token", "token doesn't match the token of the right of the linked swap", block.Link, rightBlock.Hash())
 			}
 
 			// get the prev of the right block. Should only error if the store is corrupt
 			rightPrevBlock, err := getAndVerifyAccount(rightBlock.Previous, blockStore)
 			if err != nil || rightPrevBlock == nil {
-				return errors.New("Previous of right of linked swap is invalid")
+				return invalid(CodeInvalidLink, "Link", "previous of the right of linked swap is invalid", block.Link, rightBlock.Hash())
 			}
 
 			// check if the balances match
 			balSent := rightPrevBlock.Balance - rightBlock.Balance
 			balRec := block.Balance - prevBlock.Balance
 			if balRec != balSent {
-				return errors.New("Mismatched balances receiving by offerer")
+				return invalid(CodeBalanceMismatch, "Balance", "balance doesn't match the amount sent by the counterparty", block.Link, rightBlock.Hash())
 			}
 		} else { // receiver is the commiter, take from left send
 			// check if the token types match
 			if block.Token != leftBlock.Token {
-				return errors.New("Can't receive different token types")
+				return invalid(CodeTokenMismatch, "Token", "token doesn't match the token of the left of the linked swap", block.Link, leftBlock.Hash())
 			}
 
 			// check if receiver is sender of Right
 			if account != rightBlock.Account {
-				return errors.New("Account mismatch between receiver and sender")
+				return invalid(CodeAccountMismatch, "Account", "account doesn't match the sender of the right of the linked swap", block.Link, rightBlock.Hash())
 			}
 
 			// get the prev of the left block. Should only error if the store is corrupt
 			leftPrevBlock, err := getAndVerifyAccount(leftBlock.Previous, blockStore)
 			if err != nil || leftPrevBlock == nil {
-				return errors.New("Previous of left of linked swap is invalid")
+				return invalid(CodeInvalidLink, "Link", "previous of the left of linked swap is invalid", block.Link, leftBlock.Hash())
 			}
 
 			// check if the balances match
 			balSent := leftPrevBlock.Balance - leftBlock.Balance
 			balRec := block.Balance - prevBlock.Balance
 			if balRec != balSent {
-				return errors.New("Mismatched balances receiving by committer")
+				return invalid(CodeBalanceMismatch, "Balance", "balance doesn't match the amount sent by the offerer", block.Link, leftBlock.Hash())
 			}
 		}
 	}
 
+	// a send can be claimed once, and a swap once by each party
+	_, swap := link.(*tb.SwapBlock)
+	if err := checkUnclaimed(blockStore, block, swap); err != nil {
+		return err
+	}
 	return nil
 }
 
 // SwapBlockValidator is a validator for SwapBlocks
 type SwapBlockValidator struct {
-	blockStore *BlockStore
+	blockStore BlockReader
 }
 
 // NewSwapValidator returns a new validator with the given chain
-func NewSwapValidator(blockStore *BlockStore) *SwapBlockValidator {
+func NewSwapValidator(blockStore BlockReader) *SwapBlockValidator {
 	return &SwapBlockValidator{
 		blockStore: blockStore,
 	}
@@ -401,43 +419,22 @@ func (validator SwapBlockValidator) ValidateSwapBlock(block *tb.SwapBlock) error
 	// Executor can only be counted for commit and refund right
 	// Otherwise the Counterparty is the signer
 	// Standard signing for offer and refund left
+	signerField := "Account"
 	if action == "commit" || action == "refund-right" {
+		signerField = "Counterparty"
 		if block.Executor != "" {
-			executorKey, err := AddressToRSAKey(block.Executor)
-			if err != nil {
-				return err
-			}
-
-			errVerify := block.VerifyBlock(executorKey)
-			if errVerify != nil {
-				return errVerify
-			}
-		} else {
-			publicKey, err := AddressToRSAKey(block.Counterparty)
-			if err != nil {
-				return err
-			}
-
-			if err := block.VerifyBlock(publicKey); err != nil {
-				return err
-			}
-		}
-	} else {
-		publicKey, err := AddressToRSAKey(block.Account)
-		if err != nil {
-			return err
-		}
-
-		if err := block.VerifyBlock(publicKey); err != nil {
-			return err
+			signerField = "Executor"
 		}
 	}
+	if err := verifyBlockSignature(block.VerifyBlock, signerField, swapSigner(block)); err != nil {
+		return err
+	}
 
 	// check if the previous block exists
 	prevBlock, errPrev := getAndVerifySwap(block.Previous, blockStore)
 
 	if action == "offer" && errPrev != db.ErrNotFound {
-		return errors.New("prev and right must be null together")
+		return invalid(CodeInvalidPrevious, "Previous", "previous of an offer must be empty", block.Previous)
 	}
 
 	// originating block of swap
@@ -445,47 +442,47 @@ func (validator SwapBlockValidator) ValidateSwapBlock(block *tb.SwapBlock) error
 		// check if the send block referenced exists, don't get it if it does
 		left, errLeft := getAndVerifyAccount(block.Left, blockStore)
 		if errLeft != nil || left == nil || left.Action != "send" {
-			return errors.New("link field references invalid block")
+			return invalid(CodeInvalidLink, "Left", "left must be a valid send", block.Left)
 		}
 
 		// check to see if the send (left) is pointed at this block
 		if left.Link != block.Account+":swap:"+block.ID {
-			return errors.New("Linked left block does not send to this swap")
+			return invalid(CodeWrongRecipient, "Left", "left does not send to this swap", block.Left)
 		}
 
 	} else if action == "commit" { //counterparty block
 		if errPrev != nil || prevBlock == nil {
-			return errors.New("previous must be not null")
+			return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 		}
 
 		// check if swaps line up
 		if swapCommitAlignment(block, prevBlock) {
-			return errors.New("Counterparty swap has incorrect fields: must match originating swap")
+			return invalid(CodeFieldMismatch, "", "fields of a commit must match the offer", block.Previous)
 		}
 
 		// check if the send for the original swap exists
 		ogSend, errSendOriginal := getAndVerifyAccount(prevBlock.Left, blockStore)
 		if errSendOriginal != nil || ogSend == nil {
-			return errors.New("originating send not found")
+			return invalid(CodeInvalidLink, "Left", "left of the offer not found or invalid", block.Previous, prevBlock.Left)
 		}
 
 		// get the send (right) for the second swap
 		rightBlock, err := blockStore.GetVariableBlock(block.Right)
 		if err == db.ErrNotFound {
-			return errors.New("counter send not found")
+			return invalid(CodeInvalidLink, "Right", "right not found", block.Right)
 		}
 
 		switch rightBlock := rightBlock.(type) {
 		case *tb.AccountBlock:
 			// check to see if the send (right) is pointed at this block
 			if rightBlock.Link != block.Account+":swap:"+block.ID {
-				return errors.New("Linked right block does not send to this swap")
+				return invalid(CodeWrongRecipient, "Right", "right does not send to this swap", block.Right)
 			}
 
 			// get the rightBlock's prev to determine quantity sent
 			rightBlockPrev, err := getAndVerifyAccount(rightBlock.Previous, blockStore)
 			if err != nil || rightBlockPrev == nil {
-				return errors.New("counter send prev not found")
+				return invalid(CodeInvalidLink, "Right", "right has no valid previous block", block.Right, rightBlock.Previous)
 			}
 
 			// check if the tokens sent line up
@@ -493,18 +490,18 @@ func (validator SwapBlockValidator) ValidateSwapBlock(block *tb.SwapBlock) error
 			requestedWant := prevBlock.Want
 			counterQuantity := rightBlockPrev.Balance - rightBlock.Balance
 			if requestedWant != rightBlock.Token || requestedQty != counterQuantity {
-				return errors.New("amount/token requested not sent")
+				return invalid(CodeBalanceMismatch, "Right", "right doesn't send the quantity and token the offer wants", block.Right)
 			}
 		case *tb.OrderBlock:
 			// check to see if the accept-order is pointed at this block
 			if rightBlock.Link != block.Account+":swap:"+block.ID {
-				return errors.New("Linked right block does not send to this swap")
+				return invalid(CodeWrongRecipient, "Right", "right does not send to this swap", block.Right)
 			}
 
 			// get the accept-order's prev to determine quantity sent
 			rightBlockPrev, err := getAndVerifyOrder(rightBlock.Previous, blockStore)
 			if err != nil || rightBlockPrev == nil {
-				return errors.New("accept-order prev not found")
+				return invalid(CodeInvalidLink, "Right", "right has no valid previous block", block.Right, rightBlock.Previous)
 			}
 
 			// check if the tokens sent line up
@@ -512,56 +509,56 @@ func (validator SwapBlockValidator) ValidateSwapBlock(block *tb.SwapBlock) error
 			requestedWant := prevBlock.Want
 			counterQuantity := rightBlockPrev.Balance - rightBlock.Balance
 			if requestedWant != rightBlock.Token || requestedQty != counterQuantity {
-				return errors.New("amount/token requested not sent")
+				return invalid(CodeBalanceMismatch, "Right", "right doesn't send the quantity and token the offer wants", block.Right)
 			}
 		default:
-			return errors.New("Linked right block was invalid")
+			return invalid(CodeInvalidLink, "Right", "right must be a send or an accept-order", block.Right)
 		}
 
 	} else if action == "refund-left" {
 		if errPrev != nil || prevBlock == nil {
-			return errors.New("previous must be not null")
+			return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 		}
 
 		// check if swaps line up
 		if swapRefundLeftAlignment(block, prevBlock) {
-			return errors.New("Counterparty swap has incorrect fields: must match originating swap")
+			return invalid(CodeFieldMismatch, "", "fields of a refund-left must match the offer", block.Previous)
 		}
 
 		sendBlock, errSend := getAndVerifyAccount(prevBlock.Left, blockStore)
 		if errSend != nil || sendBlock == nil {
-			return errors.New("Originating send is invalid or not found")
+			return invalid(CodeInvalidLink, "Left", "left of the offer not found or invalid", block.Previous, prevBlock.Left)
 		}
 
 		// make sure RefundLeft is the initiator's account
 		if block.RefundLeft != sendBlock.Account {
-			return errors.New("Refund must be to initiator's account")
+			return invalid(CodeAccountMismatch, "RefundLeft", "refund must be to the account of the left send", sendBlock.Hash())
 		}
 
 	} else if action == "refund-right" {
 		if errPrev != nil || prevBlock == nil {
-			return errors.New("previous must be not null")
+			return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 		}
 
 		// make sure the previous is actually a refund-left
 		if prevBlock.Action != "refund-left" {
-			return errors.New("Previous must be a refund-left")
+			return invalid(CodeInvalidPrevious, "Previous", "previous must be a refund-left", block.Previous)
 		}
 
 		// check if swaps line up
 		if swapRefundRightAlignment(block, prevBlock) {
-			return errors.New("Refund Right must match Refund Left fields")
+			return invalid(CodeFieldMismatch, "", "fields of a refund-right must match the refund-left", block.Previous)
 		}
 
 		// get the counterparty send
 		send, err := getAndVerifyAccount(block.Right, blockStore)
 		if err != nil || send == nil {
-			return errors.New("counterparty send not found/invalid")
+			return invalid(CodeInvalidLink, "Right", "right not found or invalid", block.Right)
 		}
 
 		// check if the refund is going to right place
 		if send.Account != block.RefundRight {
-			return errors.New("Account for refund must be same as original send account")
+			return invalid(CodeAccountMismatch, "RefundRight", "refund must be to the account of the right send", block.Right)
 		}
 	}
 
@@ -603,11 +600,11 @@ func swapRefundRightAlignment(block *tb.SwapBlock, prevBlock *tb.SwapBlock) bool
 
 // OrderBlockValidator is a validator for SwapBlocks
 type OrderBlockValidator struct {
-	blockStore *BlockStore
+	blockStore BlockReader
 }
 
 // NewOrderValidator returns a new validator with the given chain
-func NewOrderValidator(blockStore *BlockStore) *OrderBlockValidator {
+func NewOrderValidator(blockStore BlockReader) *OrderBlockValidator {
 	return &OrderBlockValidator{
 		blockStore: blockStore,
 	}
@@ -622,111 +619,93 @@ func (validator OrderBlockValidator) ValidateOrderBlock(block *tb.OrderBlock) er
 	switch action {
 	case "create-order":
 		// check the signature
-		publicKey, err := AddressToRSAKey(block.Account)
-		if err != nil {
-			return err
-		}
-
-		if err := block.VerifyBlock(publicKey); err != nil {
+		if err := verifyBlockSignature(block.VerifyBlock, "Account", block.Account); err != nil {
 			return err
 		}
 
 		// previous should be null
 		if block.Previous != "" {
-			return errors.New("Previous should be null")
+			return invalid(CodeInvalidPrevious, "Previous", "previous of a create-order must be empty", block.Previous)
 		}
 
 		// get the originating send
 		ogSend, err := getAndVerifyAccount(block.Link, blockStore)
 		if err != nil || ogSend == nil {
-			return errors.New("Order linked send not found")
+			return invalid(CodeInvalidLink, "Link", "linked send not found or invalid", block.Link)
 		}
 
 		// check to see if the send is pointed at this order
 		if ogSend.Link != block.Account+":order:"+block.ID {
-			return errors.New("Linked send block does not send to this order")
+			return invalid(CodeWrongRecipient, "Link", "linked send does not send to this order", block.Link)
 		}
 
 		// get the previous of the send
 		ogPrevSend, err := getAndVerifyAccount(ogSend.Previous, blockStore)
 		if err != nil || ogPrevSend == nil {
-			return errors.New("Linked send block does not have a valid previous")
+			return invalid(CodeInvalidLink, "Link", "linked send has no valid previous block", block.Link, ogSend.Previous)
 		}
 
 		// check if the balances line up
 		balanceSent := ogPrevSend.Balance - ogSend.Balance
 		if balanceSent != block.Balance {
-			return errors.New("Balance sent and Balance created do not match up")
+			return invalid(CodeBalanceMismatch, "Balance", fmt.Sprintf("balance expected %f; got %f", balanceSent, block.Balance), block.Link)
 		}
 
 	case "accept-order":
 		// check the signature
+		signerField := "Account"
 		if block.Executor != "" {
-			executorKey, err := AddressToRSAKey(block.Executor)
-			if err != nil {
-				return err
-			}
-
-			errVerify := block.VerifyBlock(executorKey)
-			if errVerify != nil {
-				return errVerify
-			}
-		} else {
-			publicKey, err := AddressToRSAKey(block.Account)
-			if err != nil {
-				return err
-			}
-
-			if err := block.VerifyBlock(publicKey); err != nil {
-				return err
-			}
+			signerField = "Executor"
+		}
+		if err := verifyBlockSignature(block.VerifyBlock, signerField, orderSigner(block)); err != nil {
+			return err
 		}
 
 		// check if the previous block exists
 		prevBlock, err := getAndVerifyOrder(block.Previous, blockStore)
 		if err != nil || prevBlock == nil {
-			return errors.New("Previous block invalid")
+			return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 		}
 
 		// check if fields beside balance, link, and previous line up
 		if orderAcceptAlignment(block, prevBlock) {
-			return errors.New("Fields did not line up with order creation")
+			return invalid(CodeFieldMismatch, "", "fields of an accept-order must match the previous order block", block.Previous)
 		}
 
 		// get the linked swap
 		swapBlock, err := getAndVerifySwapByLink(block.Link, blockStore)
 		if err != nil {
-			return fmt.Errorf("Swap link validation failed: %s", err.Error())
+			return invalid(CodeInvalidLink, "Link", "linked swap not found or invalid: "+err.Error())
 		}
 		if swapBlock == nil {
-			return errors.New("Swap link not found")
+			return invalid(CodeInvalidLink, "Link", "linked swap not found")
 		}
 
 		// get the linked swap's send
 		swapSendBlock, err := getAndVerifyAccount(swapBlock.Left, blockStore)
 		if err != nil || swapSendBlock == nil {
-			return errors.New("Swap left not found")
+			return invalid(CodeInvalidLink, "Link", "left of the linked swap not found or invalid", swapBlock.Hash(), swapBlock.Left)
 		}
 
 		// get the linked swap's send previous
 		swapSendPrevBlock, err := getAndVerifyAccount(swapSendBlock.Previous, blockStore)
 		if err != nil || swapSendPrevBlock == nil {
-			return errors.New("Swap previous not found")
+			return invalid(CodeInvalidLink, "Link", "left of the linked swap has no valid previous block", swapBlock.Hash(), swapBlock.Left)
 		}
 
 		// check the swap's counterparty is the order's account
 		if swapBlock.Counterparty != block.Account {
-			return errors.New("The swap must have counterparty point to this order")
+			return invalid(CodeWrongRecipient, "Link", "counterparty of the linked swap must be the account of this order", swapBlock.Hash())
 		}
 
 		// check the ID is the same for swap and order
 		if swapBlock.ID != block.ID {
-			return errors.New("The swap must have same ID as the order")
+			return invalid(CodeFieldMismatch, "ID", "ID of the linked swap must match the order", swapBlock.Hash())
 		}
 
 		// check if the token type lines up
 		if swapBlock.Want != block.Token || swapBlock.Token != block.Quote {
-			return errors.New("Swap and Order token mismatch")
+			return invalid(CodeTokenMismatch, "Token", "tokens of the linked swap must match the order", swapBlock.Hash())
 		}
 
 		// Balances check
@@ -737,53 +716,48 @@ func (validator OrderBlockValidator) ValidateOrderBlock(block *tb.OrderBlock) er
 		swapSendQuantity := swapSendPrevBlock.Balance - swapSendBlock.Balance
 		// valid block balance
 		if block.Balance < 0 {
-			return errors.New("Invalid block balance, must be greater than zero")
+			return invalid(CodeNegativeBalance, "Balance", "balance must not be negative")
 		}
 		// check if allowed to not fill the whole order
 		if !block.Partial {
 			if block.Balance != 0 {
-				return errors.New("Balance must be paid in full for blocks with Partial = false")
+				return invalid(CodePartialFill, "Balance", "balance must be zero for an order that can't be filled partially")
 			}
 		}
 		// check to see if order gets what it wants
 		incomingQuantity := swapSendQuantity
 		if incomingQuantity != orderQuantityWant {
-			return fmt.Errorf("Balance sent to order is invalid: expected %f; got %f", incomingQuantity, orderQuantityWant)
+			return invalid(CodeBalanceMismatch, "Balance", fmt.Sprintf("balance sent to order is invalid: expected %f; got %f", incomingQuantity, orderQuantityWant), swapBlock.Hash(), swapSendBlock.Hash())
 		}
 
 		// check if swap gets what it wants
 		if orderSend != swapQuantityWant {
-			return fmt.Errorf("Balance sent to swap is invalid: expected %f; got %f", orderSend, swapQuantityWant)
+			return invalid(CodeBalanceMismatch, "Balance", fmt.Sprintf("balance sent to swap is invalid: expected %f; got %f", orderSend, swapQuantityWant), swapBlock.Hash())
 		}
 
 	case "refund-order":
-		publicKey, err := AddressToRSAKey(block.Account)
-		if err != nil {
-			return err
-		}
-
-		if err := block.VerifyBlock(publicKey); err != nil {
+		if err := verifyBlockSignature(block.VerifyBlock, "Account", block.Account); err != nil {
 			return err
 		}
 
 		// check if the previous block exists
 		prevBlock, errPrev := getAndVerifyOrder(block.Previous, blockStore)
 		if errPrev != nil || prevBlock == nil {
-			return errors.New("Previous block invalid")
+			return invalid(CodeInvalidPrevious, "Previous", "previous block not found or invalid", block.Previous)
 		}
 
 		// check if fields beside link and previous line up
 		if orderRefundAlignment(block, prevBlock) {
-			return errors.New("Fields did not line up with head order block")
+			return invalid(CodeFieldMismatch, "", "fields of a refund-order must match the previous order block", block.Previous)
 		}
 
 		// make sure the link is to the originating send account
 		if block.Account != block.Link {
-			return errors.New("Must refund to the original sender")
+			return invalid(CodeAccountMismatch, "Link", "refund must be to the account of the order")
 		}
 
 	default:
-		return errors.New("undefined action type: " + action)
+		return invalid(CodeInvalidAction, "Action", fmt.Sprintf("unknown order action '%s'", action))
 	}
 
 	return nil
@@ -810,14 +784,29 @@ func AddressToRSAKey(hash string) (*rsa.PublicKey, error) {
 	return AddressToPublicKey(hash)
 }
 
-func getAndVerifyAccount(hash string, chain *BlockStore) (*tb.AccountBlock, error) {
+// checkUnclaimed returns an error if the link of the open or receive block was claimed in another block. A send is
+// claimed once, and a swap once by each of its two parties.
+func checkUnclaimed(chain BlockReader, block *tb.AccountBlock, swap bool) error {
+	claims, err := chain.GetClaimBlocks(block.Link)
+	if err != nil {
+		return err
+	}
+	for _, claim := range claims {
+		if hash := claim.Hash(); hash != block.Hash() && (!swap || claim.Account == block.Account) {
+			return invalid(CodeAlreadyClaimed, "Link", "link was already claimed by block "+hash, block.Link, hash)
+		}
+	}
+	return nil
+}
+
+func getAndVerifyAccount(hash string, chain BlockReader) (*tb.AccountBlock, error) {
 	// check if the previous block exists
 	block, err := chain.GetAccountBlock(hash)
 	if err != nil {
 		return nil, err
 	}
 	if block == nil {
-		return nil, errors.New("Getting block failed for hash: " + hash)
+		return nil, fmt.Errorf("app: block %s not found", hash)
 	}
 
 	publicKey, err := AddressToRSAKey(block.Account)
@@ -827,45 +816,36 @@ func getAndVerifyAccount(hash string, chain *BlockStore) (*tb.AccountBlock, erro
 
 	err = block.VerifyBlock(publicKey)
 	if err != nil {
-		return nil, errors.New("Verification of block failed")
+		return nil, fmt.Errorf("app: block %s has an invalid signature", hash)
 	}
 
 	return block, nil
 }
 
-func getAndVerifySwap(hash string, chain *BlockStore) (*tb.SwapBlock, error) {
+func getAndVerifySwap(hash string, chain BlockReader) (*tb.SwapBlock, error) {
 	// check if the previous block exists
 	block, err := chain.GetSwapBlock(hash)
 	if err != nil {
 		return nil, err
 	}
 	if block == nil {
-		return nil, errors.New("Getting block failed for hash: " + hash)
-	}
-
-	address := block.Account
-	if block.Action == "commit" || block.Action == "refund-right" {
-		if block.Executor != "" {
-			address = block.Executor
-		} else {
-			address = block.Counterparty
-		}
+		return nil, fmt.Errorf("app: block %s not found", hash)
 	}
 
-	publicKey, err := AddressToRSAKey(address)
+	publicKey, err := AddressToRSAKey(swapSigner(block))
 	if err != nil {
 		return nil, err
 	}
 
 	err = block.VerifyBlock(publicKey)
 	if err != nil {
-		return nil, errors.New("Verification of block failed")
+		return nil, fmt.Errorf("app: block %s has an invalid signature", hash)
 	}
 
 	return block, nil
 }
 
-func getAndVerifySwapByLink(link string, chain *BlockStore) (*tb.SwapBlock, error) {
+func getAndVerifySwapByLink(link string, chain BlockReader) (*tb.SwapBlock, error) {
 	// check if the previous block exists
 	account, id := tb.SwapAddressAccountID(link)
 	block, err := chain.GetSwapHead(account, id)
@@ -873,36 +853,65 @@ func getAndVerifySwapByLink(link string, chain *BlockStore) (*tb.SwapBlock, erro
 		return nil, err
 	}
 	if block == nil {
-		return nil, errors.New("Getting block failed for address: " + link)
+		return nil, fmt.Errorf("app: no swap found for address %s", link)
 	}
 	hash := block.Hash()
 	return getAndVerifySwap(hash, chain)
 }
 
-func getAndVerifyOrder(hash string, chain *BlockStore) (*tb.OrderBlock, error) {
+func getAndVerifyOrder(hash string, chain BlockReader) (*tb.OrderBlock, error) {
 	// check if the previous block exists
 	block, err := chain.GetOrderBlock(hash)
 	if err != nil {
 		return nil, err
 	}
 	if block == nil {
-		return nil, errors.New("Getting block failed for hash: " + hash)
+		return nil, fmt.Errorf("app: block %s not found", hash)
 	}
 
-	address := block.Account
-	if block.Action == "accept-order" && block.Executor != "" {
-		address = block.Executor
-	}
-
-	publicKey, err := AddressToRSAKey(address)
+	publicKey, err := AddressToRSAKey(orderSigner(block))
 	if err != nil {
 		return nil, err
 	}
 
 	err = block.VerifyBlock(publicKey)
 	if err != nil {
-		return nil, errors.New("Verification of block failed")
+		return nil, fmt.Errorf("app: block %s has an invalid signature", hash)
 	}
 
 	return block, nil
 }
+
+// Signer returns the address that signs the specified block
+func Signer(b tb.Block) string {
+	switch b := b.(type) {
+	case *tb.AccountBlock:
+		return b.Account
+	case *tb.SwapBlock:
+		return swapSigner(b)
+	case *tb.OrderBlock:
+		return orderSigner(b)
+	case *tb.ConfirmBlock:
+		return b.Account
+	}
+	return ""
+}
+
+// swapSigner returns the address that signs the specified swap block
+func swapSigner(block *tb.SwapBlock) string {
+	if block.Action == "commit" || block.Action == "refund-right" {
+		if block.Executor != "" {
+			return block.Executor
+		}
+		return block.Counterparty
+	}
+	return block.Account
+}
+
+// orderSigner returns the address that signs the specified order block
+func orderSigner(block *tb.OrderBlock) string {
+	if block.Action == "accept-order" && block.Executor != "" {
+		return block.Executor
+	}
+	return block.Account
+}
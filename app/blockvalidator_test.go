@@ -2,7 +2,6 @@ package app
 
 import (
 	"crypto/rsa"
-	"strings"
 	"testing"
 
 	"github.com/jephir/tradeblocks"
@@ -69,8 +68,8 @@ func TestOpenBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open)
-	expectedError := "previous field was not null"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -86,8 +85,8 @@ func TestOpenBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open)
-	expectedError = "link field references invalid block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -123,8 +122,8 @@ func TestOpenBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open)
-	expectedError = "balance expected 100.000000; got 49.000000"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -159,8 +158,8 @@ func TestOpenBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open)
-	expectedError = "send link 'WRONG_ACCOUNT' does not reference account"
-	if err == nil || !strings.Contains(err.Error(), expectedError) {
+	expectedError = CodeWrongRecipient
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -272,13 +271,13 @@ func TestOpenFromSwapValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open2)
-	expectedError := "previous field was not null"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 	err = validator.ValidateAccountBlock(open3)
-	expectedError = "previous field was not null"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -299,12 +298,12 @@ func TestOpenFromSwapValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open2)
-	expectedError = "Account mismatch between receiver and sender"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeAccountMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 	err = validator.ValidateAccountBlock(open3)
-	if err == nil || err.Error() != expectedError {
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -325,12 +324,12 @@ func TestOpenFromSwapValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open2)
-	expectedError = "Can't receive different token types"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeTokenMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 	err = validator.ValidateAccountBlock(open3)
-	if err == nil || err.Error() != expectedError {
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -351,13 +350,13 @@ func TestOpenFromSwapValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(open2)
-	expectedError = "Mismatched balances receiving by offerer"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 	err = validator.ValidateAccountBlock(open3)
-	expectedError = "Mismatched balances receiving by committer"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -423,6 +422,30 @@ func sendSetup(key *rsa.PrivateKey, address string, t *testing.T) (*tradeblocks.
 	return send, validator, nil
 }
 
+func TestValidationError(t *testing.T) {
+	key, address := CreateAccount(t)
+	open, send, validator, err := openSetup(key, address, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open.Balance = 50
+	if err := open.SignBlock(key); err != nil {
+		t.Fatal(err)
+	}
+
+	err = validator.ValidateAccountBlock(open)
+	e, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if e.Code != CodeBalanceMismatch || e.Field != "Balance" {
+		t.Fatalf("expected %s of Balance, got %s of %s", CodeBalanceMismatch, e.Code, e.Field)
+	}
+	if len(e.Hashes) != 1 || e.Hashes[0] != send.Hash() {
+		t.Fatalf("expected hashes [%s], got %v", send.Hash(), e.Hashes)
+	}
+}
+
 func TestSendBlockValidator(t *testing.T) {
 	key, address := CreateAccount(t)
 	// test for success
@@ -449,8 +472,8 @@ func TestSendBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(send)
-	expectedError := "Previous block invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -532,8 +555,8 @@ func TestReceiveBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive)
-	expectedError := "previous field was invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -549,8 +572,8 @@ func TestReceiveBlockValidator(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive)
-	expectedError = "db: not found"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -679,8 +702,8 @@ func TestReceiveSwap(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive2)
-	expectedError := "previous field was invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -697,8 +720,8 @@ func TestReceiveSwap(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive3)
-	expectedError = "previous field was invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -715,8 +738,8 @@ func TestReceiveSwap(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive3)
-	expectedError = "Account mismatch between receiver and sender"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeAccountMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -733,8 +756,8 @@ func TestReceiveSwap(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive3)
-	expectedError = "Can't receive different token types"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeTokenMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -751,8 +774,8 @@ func TestReceiveSwap(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive3)
-	expectedError = "Mismatched balances receiving by committer"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -769,16 +792,16 @@ func TestReceiveSwap(t *testing.T) {
 	}
 
 	err = validator.ValidateAccountBlock(receive2)
-	expectedError = "Mismatched balances receiving by offerer"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
 
 func TestUnkownAction(t *testing.T) {
 	err := ValidateAccountBlock(nil, &tradeblocks.AccountBlock{})
-	if err.Error() != "blockvalidator: unknown action ''" {
-		t.Fatalf("expected an error but got %s", err.Error())
+	if errorCode(err) != CodeInvalidAction {
+		t.Fatalf("expected %s but got %v", CodeInvalidAction, err)
 	}
 }
 
@@ -876,8 +899,8 @@ func TestSwapOfferValidation(t *testing.T) {
 	// random signature
 	swap.Signature = "garbage"
 	err = validator.ValidateSwapBlock(swap)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -892,8 +915,8 @@ func TestSwapOfferValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(swap)
-	expectedError = "crypto/rsa: verification error"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -909,8 +932,8 @@ func TestSwapOfferValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(swap)
-	expectedError = "prev and right must be null together"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -927,8 +950,8 @@ func TestSwapOfferValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(swap)
-	expectedError = "link field references invalid block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -940,8 +963,8 @@ func TestSwapOfferValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(swap)
-	expectedError = "link field references invalid block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -977,8 +1000,8 @@ func TestSwapCommitValidation(t *testing.T) {
 	// random signature
 	swap2.Signature = "garbage"
 	err = validator.ValidateSwapBlock(swap2)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -988,8 +1011,8 @@ func TestSwapCommitValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(swap2)
-	expectedError = "previous must be not null"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1006,8 +1029,8 @@ func TestSwapCommitValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(swap2)
-	if err == nil || err != ErrInvalidAddress {
-		t.Fatalf("error \"%v\" did not match \"%s\" ", err, ErrInvalidAddress)
+	if errorCode(err) != CodeInvalidAddress {
+		t.Fatalf("error \"%v\" did not match \"%s\" ", err, CodeInvalidAddress)
 	}
 
 	// bad executor
@@ -1018,8 +1041,8 @@ func TestSwapCommitValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(swap2)
-	if err == nil || err != ErrInvalidAddress {
-		t.Fatalf("error \"%v\" did not match \"%s\" ", err, ErrInvalidAddress)
+	if errorCode(err) != CodeInvalidAddress {
+		t.Fatalf("error \"%v\" did not match \"%s\" ", err, CodeInvalidAddress)
 	}
 
 	// good executor
@@ -1041,8 +1064,8 @@ func TestSwapCommitValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	validator.blockStore.AddSwapBlock(swap)
-	validator.blockStore.AddSwapBlock(swap2)
+	validator.blockStore.(*BlockStore).AddSwapBlock(swap)
+	validator.blockStore.(*BlockStore).AddSwapBlock(swap2)
 
 	err = validator.ValidateSwapBlock(swap2)
 	if err != nil {
@@ -1066,8 +1089,8 @@ func TestSwapCommitValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(swap2)
-	expectedError = "Counterparty swap has incorrect fields: must match originating swap"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1084,8 +1107,8 @@ func TestSwapCommitValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(swap2)
-	expectedError = "counter send not found"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1143,8 +1166,8 @@ func TestSwapCommitValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(swap2)
-	expectedError = "amount/token requested not sent"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -1236,8 +1259,8 @@ func TestSwapRefundLeftValidation(t *testing.T) {
 	// random signature
 	refundLeft.Signature = "garbage"
 	err = validator.ValidateSwapBlock(refundLeft)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1248,8 +1271,8 @@ func TestSwapRefundLeftValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(refundLeft)
-	expectedError = "previous must be not null"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1270,8 +1293,8 @@ func TestSwapRefundLeftValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundLeft)
-	expectedError = "Counterparty swap has incorrect fields: must match originating swap"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1288,8 +1311,8 @@ func TestSwapRefundLeftValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundLeft)
-	expectedError = "Refund must be to initiator's account"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeAccountMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -1399,8 +1422,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 	// random signature
 	refundRight.Signature = "garbage"
 	err = validator.ValidateSwapBlock(refundRight)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1423,8 +1446,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundRight)
-	if err == nil || err != ErrInvalidAddress {
-		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
+	if errorCode(err) != CodeInvalidAddress {
+		t.Fatalf("error \"%v\" did not match \"%s\" ", err, CodeInvalidAddress)
 	}
 
 	// good executor
@@ -1453,13 +1476,13 @@ func TestSwapRefundRightValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := validator.blockStore.AddSwapBlock(swap); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddSwapBlock(swap); err != nil {
 		t.Fatal(err)
 	}
-	if err := validator.blockStore.AddSwapBlock(refundLeft); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddSwapBlock(refundLeft); err != nil {
 		t.Fatal(err)
 	}
-	if err := validator.blockStore.AddSwapBlock(refundRight); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddSwapBlock(refundRight); err != nil {
 		t.Fatal(err)
 	}
 
@@ -1480,8 +1503,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = validator.ValidateSwapBlock(refundRight)
-	expectedError = "previous must be not null"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1498,8 +1521,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundRight)
-	expectedError = "Previous must be a refund-left"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1521,8 +1544,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundRight)
-	expectedError = "Refund Right must match Refund Left fields"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1539,8 +1562,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundRight)
-	expectedError = "counterparty send not found/invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1558,8 +1581,8 @@ func TestSwapRefundRightValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateSwapBlock(refundRight)
-	expectedError = "Account for refund must be same as original send account"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeAccountMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -1625,8 +1648,8 @@ func TestCreateOrderValidation(t *testing.T) {
 
 	order.Signature = "garbage"
 	err = validator.ValidateOrderBlock(order)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1638,8 +1661,8 @@ func TestCreateOrderValidation(t *testing.T) {
 
 	order.Signature = badSignature
 	err = validator.ValidateOrderBlock(order)
-	expectedError = "crypto/rsa: verification error"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1656,8 +1679,8 @@ func TestCreateOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(order)
-	expectedError = "Order linked send not found"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1694,8 +1717,8 @@ func TestCreateOrderValidation(t *testing.T) {
 	validator1 := NewOrderValidator(blockStore)
 
 	err = validator1.ValidateOrderBlock(order)
-	expectedError = "Balance sent and Balance created do not match up"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1712,8 +1735,8 @@ func TestCreateOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(order)
-	expectedError = "Balance sent and Balance created do not match up"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1730,8 +1753,8 @@ func TestCreateOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(order)
-	expectedError = "crypto/rsa: verification error"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -1837,8 +1860,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 
 	acceptOrder.Signature = "garbage"
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1850,8 +1873,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 
 	acceptOrder.Signature = badSignature
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "crypto/rsa: verification error"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1873,7 +1896,7 @@ func TestAcceptOrderValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := validator.blockStore.AddOrderBlock(createOrder); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddOrderBlock(createOrder); err != nil {
 		t.Fatal(err)
 	}
 
@@ -1900,13 +1923,13 @@ func TestAcceptOrderValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := validator.blockStore.AddOrderBlock(createOrder); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddOrderBlock(createOrder); err != nil {
 		t.Fatal(err)
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "crypto/rsa: verification error"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1923,8 +1946,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Previous block invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1941,8 +1964,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Fields did not line up with order creation"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1959,8 +1982,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Fields did not line up with order creation"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1977,8 +2000,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Fields did not line up with order creation"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -1995,8 +2018,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Swap link validation failed"
-	if err == nil || !strings.Contains(err.Error(), expectedError) {
+	expectedError = CodeInvalidLink
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2017,13 +2040,13 @@ func TestAcceptOrderValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := validator.blockStore.AddOrderBlock(createOrder); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddOrderBlock(createOrder); err != nil {
 		t.Fatal(err)
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Swap and Order token mismatch"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeTokenMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2040,8 +2063,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Invalid block balance, must be greater than zero"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeNegativeBalance
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2058,8 +2081,8 @@ func TestAcceptOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Balance must be paid in full for blocks with Partial = false"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodePartialFill
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2083,13 +2106,13 @@ func TestAcceptOrderValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := validator.blockStore.AddOrderBlock(createOrder); err != nil {
+	if err := validator.blockStore.(*BlockStore).AddOrderBlock(createOrder); err != nil {
 		t.Fatal(err)
 	}
 
 	err = validator.ValidateOrderBlock(acceptOrder)
-	expectedError = "Balance sent to order is invalid"
-	if err == nil || !strings.Contains(err.Error(), expectedError) {
+	expectedError = CodeBalanceMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
@@ -2171,8 +2194,8 @@ func TestRefundOrderValidation(t *testing.T) {
 
 	refund.Signature = "garbage"
 	err = validator.ValidateOrderBlock(refund)
-	expectedError := "illegal base64 data at input byte 4"
-	if err == nil || err.Error() != expectedError {
+	expectedError := CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2184,8 +2207,8 @@ func TestRefundOrderValidation(t *testing.T) {
 
 	refund.Signature = badSignature
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "crypto/rsa: verification error"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidSignature
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2202,8 +2225,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Previous block invalid"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeInvalidPrevious
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2220,8 +2243,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	if err == nil || err != ErrInvalidAddress {
-		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
+	if errorCode(err) != CodeInvalidAddress {
+		t.Fatalf("error \"%v\" did not match \"%s\" ", err, CodeInvalidAddress)
 	}
 
 	// fields mismatch v2
@@ -2237,8 +2260,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2255,8 +2278,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2273,8 +2296,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2291,8 +2314,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2309,8 +2332,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2327,8 +2350,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2345,8 +2368,8 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Fields did not line up with head order block"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeFieldMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 
@@ -2363,8 +2386,19 @@ func TestRefundOrderValidation(t *testing.T) {
 	}
 
 	err = validator.ValidateOrderBlock(refund)
-	expectedError = "Must refund to the original sender"
-	if err == nil || err.Error() != expectedError {
+	expectedError = CodeAccountMismatch
+	if err == nil || errorCode(err) != expectedError {
 		t.Fatalf("error \"%v\" did not match \"%s\" ", err, expectedError)
 	}
 }
+
+// errorCode returns the code of a validation error or the message of another error
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(*ValidationError); ok {
+		return e.Code
+	}
+	return err.Error()
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/db"
+)
+
+// ErrInvalidCursor is returned when a history cursor is not a block in the requested chain
+var ErrInvalidCursor = errors.New("app: invalid cursor")
+
+// AccountHistory represents a page of an account-token chain, newest block first
+type AccountHistory struct {
+	Entries []HistoryEntry
+
+	// Next is the cursor for the following page or empty if this is the last page
+	Next string
+}
+
+// HistoryEntry represents an account block and the block it is linked with
+type HistoryEntry struct {
+	Hash   string
+	Block  *tradeblocks.AccountBlock
+	Amount float64
+
+	// CounterpartType is "account", "swap" or "order" if a counterpart was found
+	CounterpartType    string
+	CounterpartAccount *tradeblocks.AccountBlock
+	CounterpartSwap    *tradeblocks.SwapBlock
+	CounterpartOrder   *tradeblocks.OrderBlock
+}
+
+// Counterpart returns the linked block of this entry or nil if there is none
+func (e *HistoryEntry) Counterpart() tradeblocks.Block {
+	switch e.CounterpartType {
+	case "account":
+		return e.CounterpartAccount
+	case "swap":
+		return e.CounterpartSwap
+	case "order":
+		return e.CounterpartOrder
+	}
+	return nil
+}
+
+// AccountHistory walks the account-token chain from the block at cursor, or from the head if cursor is empty,
+// and returns up to limit entries
+func (s *BlockStore) AccountHistory(account, token, cursor string, limit int) (*AccountHistory, error) {
+	tx, err := s.db.NewReadTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	var b *tradeblocks.AccountBlock
+	if cursor == "" {
+		b, err = tx.GetAccountHead(account, token)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b, err = tx.GetAccountBlock(cursor)
+		if err == db.ErrNotFound {
+			return nil, ErrInvalidCursor
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b.Account != account || b.Token != token {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	result := &AccountHistory{}
+	for b != nil && len(result.Entries) < limit {
+		var previous *tradeblocks.AccountBlock
+		if b.Previous != "" {
+			previous, err = tx.GetAccountBlock(b.Previous)
+			if err != nil {
+				return nil, err
+			}
+		}
+		e, err := historyEntry(tx, b, previous)
+		if err != nil {
+			return nil, err
+		}
+		result.Entries = append(result.Entries, e)
+		b = previous
+	}
+	if b != nil {
+		result.Next = b.Hash()
+	}
+	return result, nil
+}
+
+func historyEntry(tx db.ReadTx, b *tradeblocks.AccountBlock, previous *tradeblocks.AccountBlock) (HistoryEntry, error) {
+	e := HistoryEntry{
+		Hash:   b.Hash(),
+		Block:  b,
+		Amount: b.Balance,
+	}
+	if previous != nil {
+		e.Amount = b.Balance - previous.Balance
+	}
+	counterpart, err := linkedBlock(tx, b)
+	if err == db.ErrNotFound {
+		return e, nil
+	}
+	if err != nil {
+		return e, err
+	}
+	switch c := counterpart.(type) {
+	case *tradeblocks.AccountBlock:
+		e.CounterpartType = "account"
+		e.CounterpartAccount = c
+	case *tradeblocks.SwapBlock:
+		e.CounterpartType = "swap"
+		e.CounterpartSwap = c
+	case *tradeblocks.OrderBlock:
+		e.CounterpartType = "order"
+		e.CounterpartOrder = c
+	}
+	return e, nil
+}
+
+// linkedBlock returns the send or swap claimed by an open or receive, or the block that
+// received a send: the claiming account block, or the head of the destination swap or order chain
+func linkedBlock(tx db.ReadTx, b *tradeblocks.AccountBlock) (tradeblocks.Block, error) {
+	switch b.Action {
+	case "open", "receive":
+		_, block, err := tx.GetBlock(b.Link)
+		return block, err
+	case "send":
+		switch {
+		case strings.Contains(b.Link, ":swap:"):
+			account, id := tradeblocks.SwapAddressAccountID(b.Link)
+			return tx.GetSwapHead(account, id)
+		case strings.Contains(b.Link, ":order:"):
+			account, id := tradeblocks.OrderAddressAccountID(b.Link)
+			return tx.GetOrderHead(account, id)
+		default:
+			return tx.GetReceiveBlock(b.Hash())
+		}
+	}
+	return nil, db.ErrNotFound
+}
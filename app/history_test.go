@@ -0,0 +1,68 @@
+package app
+
+import (
+	"testing"
+
+	tb "github.com/jephir/tradeblocks"
+)
+
+func TestAccountHistory(t *testing.T) {
+	s := NewBlockStore()
+	tt := NewBlockTestTable(t)
+	p1, a1 := CreateAccount(t)
+	p2, a2 := CreateAccount(t)
+	issue := tt.AddAccountBlock(p1, tb.NewIssueBlock(a1, 100))
+	send1 := tt.AddAccountBlock(p1, tb.NewSendBlock(issue, a2, 30))
+	open := tt.AddAccountBlock(p2, tb.NewOpenBlockFromSend(a2, send1, 30))
+	send2 := tt.AddAccountBlock(p2, tb.NewSendBlock(open, a1, 10))
+	receive := tt.AddAccountBlock(p1, tb.NewReceiveBlockFromSend(send1, send2, 10))
+	for _, b := range tt.AccountBlocks {
+		if err := s.AddAccountBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page1, err := s.AccountHistory(a1, a1, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page1.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(page1.Entries))
+	}
+	if page1.Entries[0].Hash != receive.Hash() || page1.Entries[1].Hash != send1.Hash() {
+		t.Fatalf("expected receive and send, got %s and %s", page1.Entries[0].Block.Action, page1.Entries[1].Block.Action)
+	}
+	if page1.Entries[0].Amount != 10 {
+		t.Fatalf("expected receive amount 10, got %f", page1.Entries[0].Amount)
+	}
+	if c := page1.Entries[0].Counterpart(); c == nil || c.Hash() != send2.Hash() {
+		t.Fatalf("expected receive counterpart %s, got %v", send2.Hash(), c)
+	}
+	if page1.Entries[1].Amount != -30 {
+		t.Fatalf("expected send amount -30, got %f", page1.Entries[1].Amount)
+	}
+	if c := page1.Entries[1].Counterpart(); c == nil || c.Hash() != open.Hash() {
+		t.Fatalf("expected send counterpart %s, got %v", open.Hash(), c)
+	}
+	if page1.Next != issue.Hash() {
+		t.Fatalf("expected next cursor %s, got %s", issue.Hash(), page1.Next)
+	}
+
+	page2, err := s.AccountHistory(a1, a1, page1.Next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page2.Entries) != 1 || page2.Entries[0].Hash != issue.Hash() {
+		t.Fatalf("expected only the issue on the last page, got %d entries", len(page2.Entries))
+	}
+	if page2.Entries[0].Counterpart() != nil {
+		t.Fatal("expected issue to have no counterpart")
+	}
+	if page2.Next != "" {
+		t.Fatalf("expected no next cursor, got %s", page2.Next)
+	}
+
+	if _, err := s.AccountHistory(a1, a1, open.Hash(), 2); err != ErrInvalidCursor {
+		t.Fatalf("expected %v for a cursor on another chain, got %v", ErrInvalidCursor, err)
+	}
+}
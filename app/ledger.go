@@ -0,0 +1,403 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	tb "github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/db"
+)
+
+// supplyTolerance is the relative difference allowed between token amounts that must be equal
+const supplyTolerance = 1e-9
+
+// LedgerReport is the result of VerifyLedger
+type LedgerReport struct {
+	Blocks   int
+	Chains   int
+	Supply   []TokenSupply
+	Problems []LedgerProblem
+}
+
+// OK returns true if VerifyLedger found no problems
+func (r *LedgerReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// LedgerProblem is an integrity problem found by VerifyLedger
+type LedgerProblem struct {
+	Code    string // "signature", "chain", "head" or "supply"
+	Hash    string // block with the problem, if there is one
+	Message string
+}
+
+// TokenSupply shows where the issued balance of a token is held
+type TokenSupply struct {
+	Token    string
+	Issued   float64
+	Accounts float64 // balances of account chain heads
+	Sends    float64 // sends to accounts that aren't claimed yet
+	Swaps    float64
+	Orders   float64
+}
+
+// VerifyLedger checks every block in the store. It verifies the signature of every block, walks every chain
+// from its head to its root, checks that the stored heads are the chain tips and checks that the issued supply
+// of every token is held by accounts, unclaimed sends, swaps and orders. An error is only returned if the store
+// can't be read; problems with the blocks are listed in the report.
+func VerifyLedger(s *BlockStore) (*LedgerReport, error) {
+	var r *LedgerReport
+	err := s.View(func(tx db.ReadTx) error {
+		blocks, err := tx.QueryBlocks(0, 0)
+		if err != nil {
+			return err
+		}
+		l := newLedgerCheck(blocks)
+		l.checkSignatures()
+		if err := l.checkChains(tx); err != nil {
+			return err
+		}
+		l.checkSupply()
+		r = l.report
+		return nil
+	})
+	return r, err
+}
+
+// chain identifies a chain of blocks
+type chain struct {
+	Type    string // "account", "swap", "order" or "confirm"
+	Account string
+	Key     string // token of an account chain, ID of a swap or order chain, address of a confirm chain
+}
+
+func (c chain) String() string {
+	return fmt.Sprintf("%s chain %s %s", c.Type, c.Account, c.Key)
+}
+
+// head returns the stored head block of this chain
+func (c chain) head(tx db.ReadTx) (tb.Block, error) {
+	var b tb.Block
+	var err error
+	switch c.Type {
+	case "account":
+		b, err = tx.GetAccountHead(c.Account, c.Key)
+	case "swap":
+		b, err = tx.GetSwapHead(c.Account, c.Key)
+	case "order":
+		b, err = tx.GetOrderHead(c.Account, c.Key)
+	case "confirm":
+		b, err = tx.GetConfirmHead(c.Account, c.Key)
+	default:
+		return nil, fmt.Errorf("app: unknown chain type '%s'", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// chainOf returns the chain of the specified block
+func chainOf(b tb.Block) chain {
+	switch b := b.(type) {
+	case *tb.AccountBlock:
+		return chain{"account", b.Account, b.Token}
+	case *tb.SwapBlock:
+		return chain{"swap", b.Account, b.ID}
+	case *tb.OrderBlock:
+		return chain{"order", b.Account, b.ID}
+	case *tb.ConfirmBlock:
+		return chain{"confirm", b.Account, b.Addr}
+	}
+	panic(fmt.Sprintf("app: unknown block type %T", b))
+}
+
+// previousOf returns the hash of the previous block of the specified block
+func previousOf(b tb.Block) string {
+	switch b := b.(type) {
+	case *tb.AccountBlock:
+		return b.Previous
+	case *tb.SwapBlock:
+		return b.Previous
+	case *tb.OrderBlock:
+		return b.Previous
+	case *tb.ConfirmBlock:
+		return b.Previous
+	}
+	panic(fmt.Sprintf("app: unknown block type %T", b))
+}
+
+// isRoot returns true if the specified block may start a chain
+func isRoot(b tb.Block) bool {
+	switch b := b.(type) {
+	case *tb.AccountBlock:
+		return b.Action == "issue" || b.Action == "open"
+	case *tb.SwapBlock:
+		return b.Action == "offer"
+	case *tb.OrderBlock:
+		return b.Action == "create-order"
+	}
+	return true
+}
+
+// VerifySignature verifies the signature of the specified block with the key of its signer
+func VerifySignature(b tb.Block) error {
+	pub, err := AddressToRSAKey(Signer(b))
+	if err != nil {
+		return err
+	}
+	switch b := b.(type) {
+	case *tb.AccountBlock:
+		return b.VerifyBlock(pub)
+	case *tb.SwapBlock:
+		return b.VerifyBlock(pub)
+	case *tb.OrderBlock:
+		return b.VerifyBlock(pub)
+	case *tb.ConfirmBlock:
+		return b.VerifyBlock(pub)
+	}
+	return fmt.Errorf("app: unknown block type %T", b)
+}
+
+type ledgerCheck struct {
+	report *LedgerReport
+	blocks []tb.NetworkBlock
+	hashes map[string]tb.Block
+	chains map[chain][]tb.Block
+
+	// accounts is the sum of the account chain head balances of every token
+	accounts map[string]float64
+}
+
+func newLedgerCheck(blocks []tb.NetworkBlock) *ledgerCheck {
+	l := &ledgerCheck{
+		report: &LedgerReport{
+			Blocks:   len(blocks),
+			Supply:   []TokenSupply{},
+			Problems: []LedgerProblem{},
+		},
+		blocks:   blocks,
+		hashes:   make(map[string]tb.Block),
+		chains:   make(map[chain][]tb.Block),
+		accounts: make(map[string]float64),
+	}
+	for _, b := range blocks {
+		l.hashes[b.Block.Hash()] = b.Block
+		c := chainOf(b.Block)
+		l.chains[c] = append(l.chains[c], b.Block)
+	}
+	l.report.Chains = len(l.chains)
+	return l
+}
+
+func (l *ledgerCheck) problem(code, hash, format string, a ...interface{}) {
+	l.report.Problems = append(l.report.Problems, LedgerProblem{
+		Code:    code,
+		Hash:    hash,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (l *ledgerCheck) checkSignatures() {
+	for _, b := range l.blocks {
+		if err := VerifySignature(b.Block); err != nil {
+			l.problem("signature", b.Block.Hash(), "%s block has an invalid signature: %s", b.Type, err.Error())
+		}
+	}
+}
+
+// checkChains walks every chain from its stored head to its root and reports blocks that aren't on the walk
+func (l *ledgerCheck) checkChains(tx db.ReadTx) error {
+	chains := make([]chain, 0, len(l.chains))
+	for c := range l.chains {
+		chains = append(chains, c)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].String() < chains[j].String()
+	})
+
+	reached := make(map[string]bool)
+	for _, c := range chains {
+		blocks := l.chains[c]
+
+		// The tip is the only block that no other block of the chain references as previous
+		referenced := make(map[string]bool)
+		for _, b := range blocks {
+			referenced[previousOf(b)] = true
+		}
+		var tips []string
+		for _, b := range blocks {
+			if hash := b.Hash(); !referenced[hash] {
+				tips = append(tips, hash)
+			}
+		}
+		if len(tips) != 1 {
+			l.problem("chain", "", "%s has %d tips: %s", c, len(tips), strings.Join(tips, ", "))
+		}
+
+		var start string
+		head, err := c.head(tx)
+		if err == db.ErrNotFound {
+			l.problem("head", "", "%s has no head", c)
+			if len(tips) > 0 {
+				start = tips[0]
+			}
+		} else if err != nil {
+			return err
+		} else {
+			start = head.Hash()
+			if len(tips) == 1 && start != tips[0] {
+				l.problem("head", start, "head of %s is %s instead of the tip %s", c, start, tips[0])
+			}
+			if b, ok := head.(*tb.AccountBlock); ok {
+				l.accounts[b.Token] += b.Balance
+			}
+		}
+
+		for hash, from := start, ""; hash != ""; {
+			b, ok := l.hashes[hash]
+			if !ok {
+				l.problem("chain", from, "%s references missing block %s", c, hash)
+				break
+			}
+			if chainOf(b) != c {
+				l.problem("chain", from, "%s references block %s of %s", c, hash, chainOf(b))
+				break
+			}
+			if reached[hash] {
+				l.problem("chain", hash, "%s has a cycle", c)
+				break
+			}
+			reached[hash] = true
+			from, hash = hash, previousOf(b)
+			if hash == "" && !isRoot(b) {
+				l.problem("chain", from, "%s starts with a block that can't start a chain", c)
+			}
+		}
+	}
+
+	for _, b := range l.blocks {
+		if hash := b.Block.Hash(); !reached[hash] {
+			l.problem("chain", hash, "%s block isn't on the chain from the head of %s", b.Type, chainOf(b.Block))
+		}
+	}
+	return nil
+}
+
+// checkSupply follows every token amount from issues to the accounts, sends, swaps and orders that hold it
+func (l *ledgerCheck) checkSupply() {
+	issued := make(map[string]float64)
+	held := make(map[string]map[string]float64) // holder -> token -> amount
+	deposit := func(holder, token string, amount float64) {
+		if held[holder] == nil {
+			held[holder] = make(map[string]float64)
+		}
+		held[holder][token] += amount
+	}
+	withdraw := func(hash, holder, token string, amount float64) {
+		deposit(holder, token, -amount)
+		if held[holder][token] < -tolerance(amount) {
+			l.problem("supply", hash, "block takes %f %s from %s, which only holds %f", amount, token, holder, held[holder][token]+amount)
+		}
+	}
+
+	for _, nb := range l.blocks {
+		switch b := nb.Block.(type) {
+		case *tb.AccountBlock:
+			var previous float64
+			if b.Previous != "" {
+				prev, ok := l.hashes[b.Previous].(*tb.AccountBlock)
+				if !ok {
+					continue
+				}
+				previous = prev.Balance
+			}
+			switch b.Action {
+			case "issue":
+				issued[b.Token] += b.Balance
+			case "send":
+				deposit(sendHolder(b), b.Token, previous-b.Balance)
+			case "open", "receive":
+				link, ok := l.hashes[b.Link]
+				if !ok {
+					l.problem("supply", b.Hash(), "%s block claims missing block %s", b.Action, b.Link)
+					continue
+				}
+				withdraw(b.Hash(), claimHolder(link), b.Token, b.Balance-previous)
+			}
+		case *tb.OrderBlock:
+			if b.Action != "accept-order" {
+				continue
+			}
+			prev, ok := l.hashes[b.Previous].(*tb.OrderBlock)
+			if !ok {
+				continue
+			}
+			withdraw(b.Hash(), b.Address(), b.Token, prev.Balance-b.Balance)
+			deposit(b.Link, b.Token, prev.Balance-b.Balance)
+		}
+	}
+
+	supply := make(map[string]*TokenSupply)
+	get := func(token string) *TokenSupply {
+		if supply[token] == nil {
+			supply[token] = &TokenSupply{Token: token}
+		}
+		return supply[token]
+	}
+	for token, amount := range issued {
+		get(token).Issued = amount
+	}
+	for token, amount := range l.accounts {
+		get(token).Accounts = amount
+	}
+	for holder, tokens := range held {
+		for token, amount := range tokens {
+			s := get(token)
+			switch {
+			case strings.Contains(holder, ":swap:"):
+				s.Swaps += amount
+			case strings.Contains(holder, ":order:"):
+				s.Orders += amount
+			default:
+				s.Sends += amount
+			}
+		}
+	}
+
+	for _, s := range supply {
+		l.report.Supply = append(l.report.Supply, *s)
+	}
+	sort.Slice(l.report.Supply, func(i, j int) bool {
+		return l.report.Supply[i].Token < l.report.Supply[j].Token
+	})
+	for _, s := range l.report.Supply {
+		total := s.Accounts + s.Sends + s.Swaps + s.Orders
+		if math.Abs(s.Issued-total) > tolerance(s.Issued) {
+			l.problem("supply", "", "%f %s issued, but accounts, sends, swaps and orders hold %f", s.Issued, s.Token, total)
+		}
+	}
+}
+
+// sendHolder returns what holds the tokens of a send until they're claimed: the swap or order it sends to,
+// or the send itself
+func sendHolder(b *tb.AccountBlock) string {
+	if strings.Contains(b.Link, ":swap:") || strings.Contains(b.Link, ":order:") {
+		return b.Link
+	}
+	return b.Hash()
+}
+
+// claimHolder returns what holds the tokens claimed by an open or receive of the specified block
+func claimHolder(link tb.Block) string {
+	if b, ok := link.(*tb.AccountBlock); ok {
+		return b.Hash()
+	}
+	return link.Address()
+}
+
+func tolerance(amount float64) float64 {
+	return supplyTolerance * math.Max(1, math.Abs(amount))
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"testing"
+
+	tb "github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/db"
+)
+
+func TestVerifyLedger(t *testing.T) {
+	s := NewBlockStore()
+	tt := NewBlockTestTable(t)
+	p1, a1 := CreateAccount(t)
+	p2, a2 := CreateAccount(t)
+	node, address := CreateAccount(t)
+	issue1 := tt.AddAccountBlock(p1, tb.NewIssueBlock(a1, 100))
+	issue2 := tt.AddAccountBlock(p2, tb.NewIssueBlock(a2, 50))
+	send := tt.AddAccountBlock(p1, tb.NewSendBlock(issue1, a2, 30))
+	open := tt.AddAccountBlock(p2, tb.NewOpenBlockFromSend(a2, send, 30))
+	left := tt.AddAccountBlock(p1, tb.NewSendBlock(send, tb.SwapAddress(a1, "swap"), 20))
+	right := tt.AddAccountBlock(p2, tb.NewSendBlock(issue2, tb.SwapAddress(a1, "swap"), 10))
+	back := tt.AddAccountBlock(p2, tb.NewSendBlock(open, a1, 5))
+	offer := tt.AddSwapBlock(p1, tb.NewOfferBlock(a1, left, "swap", a2, a2, 10, "", 0))
+	commit := tt.AddSwapBlock(p2, tb.NewCommitBlock(offer, right))
+	for _, b := range tt.AccountBlocks {
+		if err := s.AddAccountBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range tt.SwapBlocks {
+		if err := s.AddSwapBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	claim := tt.AddAccountBlock(p1, tb.NewOpenBlockFromSwap(a1, a2, commit, 10))
+	if err := s.AddAccountBlock(claim); err != nil {
+		t.Fatal(err)
+	}
+	confirm := tb.NewConfirmBlock(nil, address, claim.Address(), claim.Hash())
+	if err := confirm.SignBlock(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddConfirmBlock(confirm); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := VerifyLedger(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() {
+		t.Fatalf("expected no problems, got %+v", r.Problems)
+	}
+	if r.Blocks != 11 || r.Chains != 6 {
+		t.Fatalf("expected 11 blocks in 6 chains, got %d blocks in %d chains", r.Blocks, r.Chains)
+	}
+	expect := map[string]TokenSupply{
+		a1: {Token: a1, Issued: 100, Accounts: 75, Sends: 5, Swaps: 20},
+		a2: {Token: a2, Issued: 50, Accounts: 50},
+	}
+	if len(r.Supply) != len(expect) {
+		t.Fatalf("expected %d tokens, got %+v", len(expect), r.Supply)
+	}
+	for _, supply := range r.Supply {
+		if supply != expect[supply.Token] {
+			t.Fatalf("expected %+v, got %+v", expect[supply.Token], supply)
+		}
+	}
+
+	// Add an unsigned block and a receive of more than was sent without validating them
+	unsigned := tb.NewSendBlock(claim, a1, 10)
+	overclaim := tb.NewReceiveBlockFromSend(back, send, 60)
+	if err := overclaim.SignBlock(p2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(func(tx db.Tx) error {
+		if err := tx.InsertAccountBlock(unsigned); err != nil {
+			return err
+		}
+		return tx.InsertAccountBlock(overclaim)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = VerifyLedger(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := make(map[string]bool)
+	for _, p := range r.Problems {
+		problems[p.Code+" "+p.Hash] = true
+	}
+	if !problems["signature "+unsigned.Hash()] {
+		t.Fatalf("expected signature problem with %s, got %+v", unsigned.Hash(), r.Problems)
+	}
+	if !problems["supply "+overclaim.Hash()] {
+		t.Fatalf("expected supply problem with %s, got %+v", overclaim.Hash(), r.Problems)
+	}
+}
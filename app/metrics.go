@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/metrics"
+)
+
+var (
+	blocksAccepted     = metrics.Default.NewCounter("tradeblocks_blocks_accepted_total", "Blocks added to the store by type.", "type")
+	blocksRejected     = metrics.Default.NewCounter("tradeblocks_blocks_rejected_total", "Blocks that failed to be added to the store by type and validation error code.", "type", "code")
+	validationDuration = metrics.Default.NewHistogram("tradeblocks_validation_duration_seconds", "Time to validate a block by type.", metrics.DefaultBuckets, "type")
+)
+
+// checkBlock validates a block and records how long it took
+func checkBlock(r BlockReader, b tradeblocks.Block) error {
+	defer validationDuration.ObserveSince(time.Now(), typeName(b))
+	return validateBlock(r, b)
+}
+
+// countBlock records whether a block was added or why it was rejected
+func countBlock(b tradeblocks.Block, err error) {
+	if err == nil {
+		blocksAccepted.Inc(typeName(b))
+		return
+	}
+	blocksRejected.Inc(typeName(b), rejectionCode(err))
+}
+
+// countBatch records whether a batch was added, or which block of the batch was rejected and why
+func countBatch(blocks []tradeblocks.Block, err error) {
+	if err == nil {
+		for _, b := range blocks {
+			blocksAccepted.Inc(typeName(b))
+		}
+		return
+	}
+	if be, ok := err.(*BatchError); ok && be.Index < len(blocks) {
+		blocksRejected.Inc(typeName(blocks[be.Index]), rejectionCode(err))
+	}
+}
+
+// rejectionCode returns the code of a validation error, or of the validation error of a batch, or "error" for any
+// other error
+func rejectionCode(err error) string {
+	if be, ok := err.(*BatchError); ok {
+		err = be.Err
+	}
+	if ve, ok := err.(*ValidationError); ok {
+		return ve.Code
+	}
+	return "error"
+}
+
+func typeName(b tradeblocks.Block) string {
+	switch b.(type) {
+	case *tradeblocks.AccountBlock:
+		return "account"
+	case *tradeblocks.SwapBlock:
+		return "swap"
+	case *tradeblocks.OrderBlock:
+		return "order"
+	case *tradeblocks.ConfirmBlock:
+		return "confirm"
+	}
+	return fmt.Sprintf("%T", b)
+}
@@ -0,0 +1,320 @@
+package app
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/db"
+)
+
+// SnapshotVersion is the snapshot format version written by ExportSnapshot
+const SnapshotVersion = 1
+
+const snapshotPageSize = 1000
+
+// A snapshot is a gzip-compressed stream of JSON lines: a SnapshotHeader, every block up to the snapshot sequence
+// as a tradeblocks.NetworkBlock, the heads of every chain and a seal. The seal has the SHA-256 checksum of all
+// uncompressed lines before it and a signature of the checksum by the node in the header.
+
+// SnapshotHeader describes a snapshot
+type SnapshotHeader struct {
+	Type     string // always "header"
+	Version  int
+	Node     string // address of the node that signed the snapshot
+	Sequence int    // sequence of the last block in the snapshot
+	Created  time.Time
+}
+
+// SnapshotHead is the head block of a chain in a snapshot
+type SnapshotHead struct {
+	Chain   string // "account", "swap", "order" or "confirm"
+	Account string
+	Key     string // token of an account chain, ID of a swap or order chain, address of a confirm chain
+	Hash    string
+}
+
+type snapshotHeads struct {
+	Type  string // always "heads"
+	Heads []SnapshotHead
+}
+
+type snapshotSeal struct {
+	Type      string // always "seal"
+	Checksum  string
+	Signature string
+}
+
+// ExportSnapshot writes a snapshot of every block in the store up to the specified sequence, or up to the last
+// block if sequence is zero, signed by priv
+func ExportSnapshot(w io.Writer, s *BlockStore, sequence int, priv *rsa.PrivateKey) (*SnapshotHeader, error) {
+	last, err := s.LastSequence()
+	if err != nil {
+		return nil, err
+	}
+	if sequence == 0 {
+		sequence = last
+	} else if sequence < 0 || sequence > last {
+		return nil, fmt.Errorf("app: snapshot sequence %d is not between 1 and the last sequence %d", sequence, last)
+	}
+	address, err := PrivateKeyToAddress(priv)
+	if err != nil {
+		return nil, err
+	}
+	h := &SnapshotHeader{
+		Type:     "header",
+		Version:  SnapshotVersion,
+		Node:     address,
+		Sequence: sequence,
+		Created:  time.Now().UTC(),
+	}
+
+	zw := gzip.NewWriter(w)
+	sum := sha256.New()
+	enc := json.NewEncoder(io.MultiWriter(zw, sum))
+	if err := enc.Encode(h); err != nil {
+		return nil, err
+	}
+	heads := make(map[chain]string)
+	for since := 0; since < sequence; {
+		blocks, err := s.QueryBlocks(since, snapshotPageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+		for _, b := range blocks {
+			if b.Sequence > sequence {
+				break
+			}
+			if err := enc.Encode(b); err != nil {
+				return nil, err
+			}
+			heads[chainOf(b.Block)] = b.Block.Hash()
+		}
+		since = blocks[len(blocks)-1].Sequence
+	}
+	hs := snapshotHeads{
+		Type:  "heads",
+		Heads: make([]SnapshotHead, 0, len(heads)),
+	}
+	for c, hash := range heads {
+		hs.Heads = append(hs.Heads, SnapshotHead{
+			Chain:   c.Type,
+			Account: c.Account,
+			Key:     c.Key,
+			Hash:    hash,
+		})
+	}
+	sort.Slice(hs.Heads, func(i, j int) bool {
+		a, b := hs.Heads[i], hs.Heads[j]
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Key < b.Key
+	})
+	if err := enc.Encode(hs); err != nil {
+		return nil, err
+	}
+
+	checksum := sum.Sum(nil)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, checksum)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(zw).Encode(snapshotSeal{
+		Type:      "seal",
+		Checksum:  hex.EncodeToString(checksum),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// VerifySnapshot reads a snapshot and returns its header if its checksum and signature are valid
+func VerifySnapshot(r io.Reader) (*SnapshotHeader, error) {
+	h, _, err := readSnapshot(r, func(b tradeblocks.NetworkBlock) error {
+		return nil
+	})
+	return h, err
+}
+
+// ImportSnapshot adds every block of a snapshot to an empty store without validating the blocks, so the store
+// has the same chains as the node that signed the snapshot. The snapshot must be signed by the node with the
+// address signer. No blocks are added if the snapshot is invalid.
+func ImportSnapshot(r io.Reader, s *BlockStore, signer string) (*SnapshotHeader, error) {
+	if signer == "" {
+		return nil, errors.New("app: the address of the node that signed the snapshot is required")
+	}
+	var h *SnapshotHeader
+	err := s.Restore(func(tx db.Tx) error {
+		last, err := tx.LastSequence()
+		if err != nil {
+			return err
+		}
+		if last != 0 {
+			return errors.New("app: can't import a snapshot into a store that has blocks")
+		}
+		var heads []SnapshotHead
+		h, heads, err = readSnapshot(r, func(b tradeblocks.NetworkBlock) error {
+			return insertBlock(tx, b.Block)
+		})
+		if err != nil {
+			return err
+		}
+		if h.Node != signer {
+			return fmt.Errorf("app: snapshot is signed by '%s' instead of '%s'", h.Node, signer)
+		}
+		for _, head := range heads {
+			if err := checkHead(tx, head); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readSnapshot calls fn with every block of a snapshot in sequence order, then verifies the snapshot's
+// checksum and signature
+func readSnapshot(r io.Reader, fn func(b tradeblocks.NetworkBlock) error) (*SnapshotHeader, []SnapshotHead, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+	sum := sha256.New()
+
+	var h SnapshotHeader
+	if err := readSnapshotLine(br, sum, "header", &h); err != nil {
+		return nil, nil, err
+	}
+	if h.Version != SnapshotVersion {
+		return nil, nil, fmt.Errorf("app: unsupported snapshot version %d", h.Version)
+	}
+	var hs snapshotHeads
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("app: snapshot is truncated: %s", err.Error())
+		}
+		var t struct {
+			Type string
+		}
+		if err := json.Unmarshal(line, &t); err != nil {
+			return nil, nil, err
+		}
+		sum.Write(line)
+		if t.Type == "heads" {
+			if err := json.Unmarshal(line, &hs); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+		var b tradeblocks.NetworkBlock
+		if err := json.Unmarshal(line, &b); err != nil {
+			return nil, nil, err
+		}
+		if b.Sequence > h.Sequence {
+			return nil, nil, fmt.Errorf("app: snapshot block %s has sequence %d after the snapshot sequence %d", b.Block.Hash(), b.Sequence, h.Sequence)
+		}
+		if err := fn(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var seal snapshotSeal
+	if err := readSnapshotLine(br, nil, "seal", &seal); err != nil {
+		return nil, nil, err
+	}
+	checksum := sum.Sum(nil)
+	if seal.Checksum != hex.EncodeToString(checksum) {
+		return nil, nil, errors.New("app: snapshot checksum doesn't match")
+	}
+	pub, err := AddressToPublicKey(h.Node)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(seal.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, checksum, sig); err != nil {
+		return nil, nil, fmt.Errorf("app: snapshot signature is invalid: %s", err.Error())
+	}
+	return &h, hs.Heads, nil
+}
+
+// readSnapshotLine decodes the next line of a snapshot into v, which must be a record of the specified type,
+// and adds the line to sum unless sum is nil
+func readSnapshotLine(br *bufio.Reader, sum hash.Hash, typ string, v interface{}) error {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("app: snapshot is truncated: %s", err.Error())
+	}
+	if sum != nil {
+		sum.Write(line)
+	}
+	var t struct {
+		Type string
+	}
+	if err := json.Unmarshal(line, &t); err != nil {
+		return err
+	}
+	if t.Type != typ {
+		return fmt.Errorf("app: expected snapshot %s, got '%s'", typ, t.Type)
+	}
+	return json.Unmarshal(line, v)
+}
+
+// insertBlock inserts the specified block without validating it
+func insertBlock(tx db.Tx, b tradeblocks.Block) error {
+	switch b := b.(type) {
+	case *tradeblocks.AccountBlock:
+		return tx.InsertAccountBlock(b)
+	case *tradeblocks.SwapBlock:
+		return tx.InsertSwapBlock(b)
+	case *tradeblocks.OrderBlock:
+		return tx.InsertOrderBlock(b)
+	case *tradeblocks.ConfirmBlock:
+		return tx.InsertConfirmBlock(b)
+	}
+	return fmt.Errorf("app: unknown block type %T", b)
+}
+
+// checkHead returns an error if the head of the specified chain isn't head.Hash
+func checkHead(tx db.ReadTx, head SnapshotHead) error {
+	b, err := chain{head.Chain, head.Account, head.Key}.head(tx)
+	if err != nil {
+		return fmt.Errorf("app: snapshot head of %s chain %s %s: %s", head.Chain, head.Account, head.Key, err.Error())
+	}
+	if b.Hash() != head.Hash {
+		return fmt.Errorf("app: snapshot head of %s chain %s %s is %s, got %s", head.Chain, head.Account, head.Key, head.Hash, b.Hash())
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	tb "github.com/jephir/tradeblocks"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := NewBlockStore()
+	tt := NewBlockTestTable(t)
+	p1, a1 := CreateAccount(t)
+	p2, a2 := CreateAccount(t)
+	node, address := CreateAccount(t)
+	issue := tt.AddAccountBlock(p1, tb.NewIssueBlock(a1, 100))
+	send := tt.AddAccountBlock(p1, tb.NewSendBlock(issue, a2, 30))
+	open := tt.AddAccountBlock(p2, tb.NewOpenBlockFromSend(a2, send, 30))
+	for _, b := range tt.AccountBlocks {
+		if err := s.AddAccountBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	h, err := ExportSnapshot(&buf, s, 2, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Sequence != 2 || h.Node != address {
+		t.Fatalf("expected snapshot at sequence 2 by %s, got %d by %s", address, h.Sequence, h.Node)
+	}
+	snapshot := buf.Bytes()
+
+	if _, err := VerifySnapshot(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), s, address); err == nil {
+		t.Fatal("expected import into a store with blocks to fail")
+	}
+	_, other := CreateAccount(t)
+	imported := NewBlockStore()
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), imported, ""); err == nil {
+		t.Fatal("expected import without a signer to fail")
+	}
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), imported, other); err == nil {
+		t.Fatal("expected import of a snapshot by another node to fail")
+	}
+	if last, err := imported.LastSequence(); err != nil || last != 0 {
+		t.Fatalf("expected failed import to add no blocks, got last sequence %d (%v)", last, err)
+	}
+
+	if _, err := ImportSnapshot(bytes.NewReader(snapshot), imported, address); err != nil {
+		t.Fatal(err)
+	}
+	head, err := imported.GetAccountHead(a1, a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != send.Hash() {
+		t.Fatalf("expected head %s, got %s", send.Hash(), head.Hash())
+	}
+	if _, err := imported.GetAccountBlock(open.Hash()); err == nil {
+		t.Fatal("expected block after the snapshot sequence to be excluded")
+	}
+
+	// The tail is validated as usual
+	if err := imported.AddAccountBlock(open); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotTampered(t *testing.T) {
+	s := NewBlockStore()
+	p1, a1 := CreateAccount(t)
+	node, address := CreateAccount(t)
+	issue, err := tb.SignedAccountBlock(tb.NewIssueBlock(a1, 100), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAccountBlock(issue); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := ExportSnapshot(&buf, s, 0, node); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tampered bytes.Buffer
+	zw := gzip.NewWriter(&tampered)
+	zw.Write([]byte(strings.Replace(string(b), `"Balance":100`, `"Balance":1000`, 1)))
+	zw.Close()
+
+	imported := NewBlockStore()
+	_, err = ImportSnapshot(&tampered, imported, address)
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if _, err := imported.GetAccountHead(a1, a1); err == nil {
+		t.Fatal("expected tampered snapshot to add no blocks")
+	}
+}
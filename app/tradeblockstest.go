@@ -84,7 +84,7 @@ func signBlock(t *testing.T, priv *rsa.PrivateKey, b tradeblocks.Block) {
 }
 
 // CreateAccount returns a private key and address for a new account
-func CreateAccount(t *testing.T) (priv *rsa.PrivateKey, address string) {
+func CreateAccount(t testing.TB) (priv *rsa.PrivateKey, address string) {
 	var err error
 	priv, err = rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
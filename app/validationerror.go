@@ -0,0 +1,63 @@
+package app
+
+import (
+	"crypto/rsa"
+	"fmt"
+)
+
+// Codes of validation errors. Codes are stable, so clients can handle errors without matching their messages.
+const (
+	CodeInvalidAction       = "invalid_action"       // the action isn't known for the block type
+	CodeInvalidAddress      = "invalid_address"      // an address field isn't a valid address
+	CodeInvalidSignature    = "invalid_signature"    // the signature doesn't verify with the key of the signer
+	CodeInvalidPrevious     = "invalid_previous"     // the previous block is missing, invalid or must be empty
+	CodeInvalidLink         = "invalid_link"         // a linked block is missing or invalid
+	CodeWrongRecipient      = "wrong_recipient"      // a linked block doesn't send to this account, swap or order
+	CodeAccountMismatch     = "account_mismatch"     // an account doesn't match the account of a linked block
+	CodeTokenMismatch       = "token_mismatch"       // the token doesn't match the token of a linked block
+	CodeFieldMismatch       = "field_mismatch"       // fields that must not change differ from the previous block
+	CodeNegativeBalance     = "negative_balance"     // the balance is less than zero
+	CodeInsufficientBalance = "insufficient_balance" // the balance is greater than the previous balance of a send
+	CodeBalanceMismatch     = "balance_mismatch"     // the amount doesn't match the amount of a linked block
+	CodePartialFill         = "partial_fill"         // an order that doesn't allow partial fills isn't filled in full
+	CodeAlreadyClaimed      = "already_claimed"      // the linked send, or this side of the linked swap, was already claimed
+)
+
+// ValidationError describes why a block is invalid
+type ValidationError struct {
+	Code    string   // one of the Code constants
+	Field   string   `json:",omitempty"` // field of the block that failed validation, such as "Balance"
+	Message string   // description for people
+	Hashes  []string `json:",omitempty"` // hashes of related blocks, such as the previous or linked block
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// invalid returns a validation error with the specified related hashes, ignoring empty ones
+func invalid(code, field, message string, hashes ...string) *ValidationError {
+	e := &ValidationError{
+		Code:    code,
+		Field:   field,
+		Message: message,
+	}
+	for _, h := range hashes {
+		if h != "" {
+			e.Hashes = append(e.Hashes, h)
+		}
+	}
+	return e
+}
+
+// verifyBlockSignature verifies a signature with verify and the key of the signer address in the specified field
+func verifyBlockSignature(verify func(*rsa.PublicKey) error, field, signer string) error {
+	pub, err := AddressToRSAKey(signer)
+	if err != nil {
+		return invalid(CodeInvalidAddress, field, fmt.Sprintf("%s '%s' is not a valid address", field, signer))
+	}
+	if err := verify(pub); err != nil {
+		return invalid(CodeInvalidSignature, "Signature", fmt.Sprintf("signature doesn't verify with the key of %s: %s", field, err.Error()))
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 )
 
 // Block represents any block type
@@ -648,6 +649,48 @@ type NetworkOrderBlock struct {
 	Sequence int
 }
 
+// NetworkBlock represents a block of any type with sequence information
+type NetworkBlock struct {
+	Type     string
+	Sequence int
+	Time     time.Time // when the block was added to the store, zero if unknown
+	Block    Block
+}
+
+// UnmarshalJSON decodes the block into the concrete block type named by Type
+func (nb *NetworkBlock) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type     string
+		Sequence int
+		Time     time.Time
+		Block    json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var b Block
+	switch raw.Type {
+	case "account":
+		b = &AccountBlock{}
+	case "swap":
+		b = &SwapBlock{}
+	case "order":
+		b = &OrderBlock{}
+	case "confirm":
+		b = &ConfirmBlock{}
+	default:
+		return fmt.Errorf("blockgraph: unknown block type '%s'", raw.Type)
+	}
+	if err := json.Unmarshal(raw.Block, b); err != nil {
+		return err
+	}
+	nb.Type = raw.Type
+	nb.Sequence = raw.Sequence
+	nb.Time = raw.Time
+	nb.Block = b
+	return nil
+}
+
 // ConfirmBlock represents a block in the confirmation blockchain
 type ConfirmBlock struct {
 	Previous  string
@@ -6,6 +6,7 @@ import (
 	"crypto/rsa"
 	"encoding/base32"
 	"encoding/base64"
+	"encoding/json"
 	"testing"
 )
 
@@ -74,3 +75,27 @@ func TestVerifyBlock(t *testing.T) {
 		t.Fatal(errVerify)
 	}
 }
+
+func TestNetworkBlockJSON(t *testing.T) {
+	issue := NewIssueBlock("xtb:test", 100)
+	offer := NewOfferBlock("xtb:test", NewSendBlock(issue, "xtb:test:swap:id", 10), "id", "xtb:counterparty", "xtb:want", 5, "", 0)
+	for _, expect := range []NetworkBlock{
+		{Type: "account", Sequence: 1, Block: issue},
+		{Type: "swap", Sequence: 2, Block: offer},
+	} {
+		b, err := json.Marshal(expect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got NetworkBlock
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != expect.Type || got.Sequence != expect.Sequence {
+			t.Fatalf("expected %s block %d, got %s block %d", expect.Type, expect.Sequence, got.Type, got.Sequence)
+		}
+		if got.Block.Hash() != expect.Block.Hash() {
+			t.Fatalf("expected hash %s, got %s", expect.Block.Hash(), got.Block.Hash())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+	"github.com/jephir/tradeblocks/fs"
+)
+
+const archiveUsage = "Run this command with $ tradeblocks archive save [flags] <dir> or $ tradeblocks archive load [flags] <dir>"
+
+func (cli *cli) handleArchive(args []string) error {
+	if len(args) < 3 || (args[2] != "save" && args[2] != "load") {
+		return errors.New(archiveUsage)
+	}
+	c := defaultConfig()
+	flags := newConfigFlags("archive", c)
+	if err := parseConfig(flags, c, args[3:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return errors.New(archiveUsage)
+	}
+	dir := flags.Arg(0)
+	d, err := db.NewDB(c.dataSource())
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	store := app.NewBlockStoreWithStore(d)
+	archive := fs.NewBlockStorage(store, dir)
+
+	if args[2] == "save" {
+		if err := archive.Save(); err != nil {
+			return err
+		}
+	} else if err := archive.Load(); err != nil {
+		return err
+	}
+	sequence, err := store.LastSequence()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s %s at sequence %d\n", args[2], dir, sequence)
+	return nil
+}
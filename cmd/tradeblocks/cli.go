@@ -3,31 +3,95 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"os"
 	"strconv"
+	"text/tabwriter"
 
 	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
 )
 
 type cli struct {
-	keySize   int
-	serverURL string
-	dataDir   string
-	out       io.Writer
+	keySize    int
+	serverURL  string
+	walletDir  string
+	passphrase func(prompt string) (string, error)
+	out        io.Writer
+
+	offline bool   // build unsigned blocks, see handleBuild
+	account string // address to build blocks for
+}
+
+// walletCommands are the commands that use a wallet, which is chosen with --as <name> before their arguments
+var walletCommands = map[string]bool{
+	"issue":          true,
+	"send":           true,
+	"open":           true,
+	"open-from-swap": true,
+	"receive":        true,
+	"offer":          true,
+	"commit":         true,
+	"refund-left":    true,
+	"refund-right":   true,
+	"create-order":   true,
+	"accept-order":   true,
+	"refund-order":   true,
+	"sell":           true,
+	"buy":            true,
+	"history":        true,
 }
 
 func (cli *cli) dispatch(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Run this command with $ tradeblocks [-node <address>] <command> [arguments]")
+	}
 	var command = args[1]
 	var block *tradeblocks.AccountBlock
 	var swapBlock *tradeblocks.SwapBlock
 	var orderBlock *tradeblocks.OrderBlock
 	var err error
 
-	cmd := newClient(cli.dataDir, cli.serverURL, cli.keySize)
+	cmd := newClient(cli.walletDir, cli.serverURL, cli.keySize)
+	cmd.passphrase = cli.passphrase
+	cmd.offline = cli.offline
+	cmd.account = cli.account
+	if walletCommands[command] {
+		flags := flag.NewFlagSet(command, flag.ContinueOnError)
+		flags.StringVar(&cmd.name, "as", os.Getenv(envPrefix+"WALLET"), "name of the wallet to use (default: the only wallet)")
+		if command != "history" {
+			flags.BoolVar(&cmd.dryRun, "dry-run", false, "validate the signed block with the node and print it instead of posting it")
+		}
+		if err := flags.Parse(args[2:]); err != nil {
+			return err
+		}
+		args = append([]string{args[0], command}, flags.Args()...)
+	}
 	switch command {
 	case "node":
-		if err := cli.handleNode(); err != nil {
+		if err := cli.handleNode(args); err != nil {
+			return err
+		}
+	case "db":
+		if err := cli.handleDB(args); err != nil {
+			return err
+		}
+	case "export":
+		if err := cli.handleExport(args); err != nil {
+			return err
+		}
+	case "archive":
+		if err := cli.handleArchive(args); err != nil {
+			return err
+		}
+	case "fsck":
+		if err := cli.handleFsck(args); err != nil {
+			return err
+		}
+	case "snapshot":
+		if err := cli.handleSnapshot(args); err != nil {
 			return err
 		}
 	case "register":
@@ -41,16 +105,21 @@ func (cli *cli) dispatch(args []string) error {
 		} else {
 			cmd.badInputs("register", addInfo)
 		}
-	case "login":
-		goodInputs, addInfo := loginInputValidation(args)
-		if goodInputs {
-			address, err := cmd.login(args[2])
-			if err != nil {
-				return err
-			}
-			fmt.Fprintln(cli.out, address)
-		} else {
-			cmd.badInputs("login", addInfo)
+	case "build":
+		if err := cli.handleBuild(args); err != nil {
+			return err
+		}
+	case "sign":
+		if err := cli.handleSign(args); err != nil {
+			return err
+		}
+	case "broadcast":
+		if err := cli.handleBroadcast(args); err != nil {
+			return err
+		}
+	case "wallet":
+		if err := cli.handleWallet(args); err != nil {
+			return err
 		}
 	case "issue":
 		goodInputs, addInfo := issueInputValidation(args)
@@ -223,6 +292,23 @@ func (cli *cli) dispatch(args []string) error {
 		for _, b := range blocks {
 			fmt.Fprintln(cli.out, b.Hash())
 		}
+	case "history":
+		goodInputs, addInfo := historyInputValidation(args)
+		if goodInputs {
+			var cursor string
+			if len(args) == 4 {
+				cursor = args[3]
+			}
+			h, err := cmd.history(args[2], cursor)
+			if err != nil {
+				return err
+			}
+			if err := printHistory(cli.out, h); err != nil {
+				return err
+			}
+		} else {
+			cmd.badInputs("history", addInfo)
+		}
 	case "cat":
 		// TODO validation
 		hash := args[2]
@@ -238,16 +324,51 @@ func (cli *cli) dispatch(args []string) error {
 	}
 
 	if block != nil {
-		fmt.Fprintln(cli.out, block.Hash())
+		if err := cli.printBlock("account", block, cli.offline || cmd.dryRun); err != nil {
+			return err
+		}
 	}
 
 	if swapBlock != nil {
-		fmt.Fprintln(cli.out, swapBlock.Hash())
+		if err := cli.printBlock("swap", swapBlock, cli.offline || cmd.dryRun); err != nil {
+			return err
+		}
 	}
 
 	if orderBlock != nil {
-		fmt.Fprintln(cli.out, orderBlock.Hash())
+		if err := cli.printBlock("order", orderBlock, cli.offline || cmd.dryRun); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printBlock prints the hash of a new block, or the block file of a block that wasn't posted, which is unsigned
+// if it was built offline
+func (cli *cli) printBlock(typ string, b tradeblocks.Block, file bool) error {
+	if file {
+		return writeBlockFile(cli.out, typ, b)
 	}
+	_, err := fmt.Fprintln(cli.out, b.Hash())
+	return err
+}
 
+func printHistory(out io.Writer, h *app.AccountHistory) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tACTION\tAMOUNT\tBALANCE\tCOUNTERPART")
+	for _, e := range h.Entries {
+		counterpart := "-"
+		if b := e.Counterpart(); b != nil {
+			counterpart = e.CounterpartType + " " + b.Hash()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%+f\t%f\t%s\n", e.Hash, e.Block.Action, e.Amount, e.Block.Balance, counterpart)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if h.Next != "" {
+		fmt.Fprintf(out, "next: %s\n", h.Next)
+	}
 	return nil
 }
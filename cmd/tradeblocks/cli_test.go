@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
 	"github.com/jephir/tradeblocks/node"
 	"github.com/jephir/tradeblocks/web"
 )
@@ -36,17 +37,15 @@ func TestCLI(t *testing.T) {
 
 	// Run test
 	c := &cli{
-		keySize:   1024,
-		serverURL: ts.URL,
-		dataDir:   dir,
-		out:       ioutil.Discard,
+		keySize:    1024,
+		serverURL:  ts.URL,
+		walletDir:  dir,
+		passphrase: testPassphrase,
+		out:        ioutil.Discard,
 	}
 	if err := c.dispatch([]string{"tradeblocks", "register", "test"}); err != nil {
 		t.Fatal(err)
 	}
-	if err := c.dispatch([]string{"tradeblocks", "login", "test"}); err != nil {
-		t.Fatal(err)
-	}
 	if err := c.dispatch([]string{"tradeblocks", "issue", "100"}); err != nil {
 		t.Fatal(err)
 	}
@@ -69,15 +68,12 @@ func TestDemo(t *testing.T) {
 	xtbT1 := x.exec("tradeblocks", "register", "t1")
 	xtbT2 := x.exec("tradeblocks", "register", "t2")
 
-	x.exec("tradeblocks", "login", "t1")
-	x.exec("tradeblocks", "issue", "1000")
-	x.exec("tradeblocks", "login", "t2")
-	x.exec("tradeblocks", "issue", "1000")
+	x.exec("tradeblocks", "issue", "--as", "t1", "1000")
+	x.exec("tradeblocks", "issue", "--as", "t2", "1000")
 
-	x.exec("tradeblocks", "sell", "100", xtbT2, "2", xtbT1)
+	x.exec("tradeblocks", "sell", "--as", "t2", "100", xtbT2, "2", xtbT1)
 
-	x.exec("tradeblocks", "login", "t1")
-	x.exec("tradeblocks", "buy", "100", xtbT2, "2", xtbT1)
+	x.exec("tradeblocks", "buy", "--as", "t1", "100", xtbT2, "2", xtbT1)
 }
 
 func TestLimitOrders(t *testing.T) {
@@ -96,17 +92,14 @@ func TestLimitOrders(t *testing.T) {
 
 	t1 := x.exec("tradeblocks", "register", "t1")
 	t2 := x.exec("tradeblocks", "register", "t2")
-	x.exec("tradeblocks", "login", "t1")
-	x.exec("tradeblocks", "issue", "1000")
-	x.exec("tradeblocks", "login", "t2")
-	x.exec("tradeblocks", "issue", "1000")
+	x.exec("tradeblocks", "issue", "--as", "t1", "1000")
+	x.exec("tradeblocks", "issue", "--as", "t2", "1000")
 
 	// Sell 100 units of t2 coin for t1 coin at 2 price per unit (200 t1)
-	x.exec("tradeblocks", "sell", "100", t2, "2", t1)
+	x.exec("tradeblocks", "sell", "--as", "t2", "100", t2, "2", t1)
 
 	// Buy 100 units of t2 coin for t1 coin at 2 price per unit (200 t1)
-	x.exec("tradeblocks", "login", "t1")
-	offerHash := x.exec("tradeblocks", "buy", "100", t2, "2", t1)
+	offerHash := x.exec("tradeblocks", "buy", "--as", "t1", "100", t2, "2", t1)
 
 	// Check resulting swap
 	client := web.NewClient(s.URL)
@@ -198,8 +191,7 @@ func TestNodeNetwork(t *testing.T) {
 		x[i], dirs[i] = newExecutorDir(t, servers[i].URL)
 		defer os.RemoveAll(dirs[i])
 		a[i] = x[i].exec("tradeblocks", "register", "me")
-		x[i].exec("tradeblocks", "login", "me")
-		issues[i] = x[i].exec("tradeblocks", "issue", "100")
+		issues[i] = x[i].exec("tradeblocks", "issue", "--as", "me", "100")
 		t.Logf("created %s", issues[i])
 	}
 
@@ -218,6 +210,142 @@ func TestNodeNetwork(t *testing.T) {
 	}
 }
 
+func TestValidationError(t *testing.T) {
+	dir, _, s := newNode(t, "")
+	defer s.Close()
+	defer os.RemoveAll(dir)
+
+	x, walletDir := newExecutorDir(t, s.URL)
+	defer os.RemoveAll(walletDir)
+
+	alice := x.exec("tradeblocks", "register", "alice")
+	issue := x.exec("tradeblocks", "issue", "100")
+	err := x.c.dispatch([]string{"tradeblocks", "send", alice, alice, "200"})
+	if e, ok := err.(*app.ValidationError); !ok || e.Code != app.CodeNegativeBalance {
+		t.Fatalf("expected %s, got %v", app.CodeNegativeBalance, err)
+	}
+
+	var buf bytes.Buffer
+	printValidationError(&buf, &app.ValidationError{
+		Code:    app.CodeInvalidPrevious,
+		Field:   "Previous",
+		Message: "previous block not found or invalid",
+		Hashes:  []string{issue},
+	})
+	expect := "invalid block: invalid_previous: previous block not found or invalid\n  field: Previous\n  related block: " + issue + "\n"
+	if buf.String() != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, _, s := newNode(t, "")
+	defer s.Close()
+	defer os.RemoveAll(dir)
+
+	x, walletDir := newExecutorDir(t, s.URL)
+	defer os.RemoveAll(walletDir)
+	alice := x.exec("tradeblocks", "register", "alice")
+
+	// A valid block is printed but not added
+	file := filepath.Join(walletDir, "issue.json")
+	if err := ioutil.WriteFile(file, []byte(x.exec("tradeblocks", "issue", "--dry-run", "100")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	nb, err := readBlockFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.c.dispatch([]string{"tradeblocks", "cat", nb.Block.Hash()}); err == nil {
+		t.Fatal("expected a dry run not to add the block")
+	}
+	if issue := x.exec("tradeblocks", "broadcast", file); issue != nb.Block.Hash() {
+		t.Fatalf("expected hash %s, got %s", nb.Block.Hash(), issue)
+	}
+
+	// An invalid block returns its validation error
+	err = x.c.dispatch([]string{"tradeblocks", "send", "--dry-run", alice, alice, "200"})
+	if e, ok := err.(*app.ValidationError); !ok || e.Code != app.CodeNegativeBalance {
+		t.Fatalf("expected %s, got %v", app.CodeNegativeBalance, err)
+	}
+
+	// A batch is validated as a whole
+	order := x.exec("tradeblocks", "sell", "--dry-run", "10", alice, "1", alice)
+	if err := x.c.dispatch([]string{"tradeblocks", "cat", order}); err == nil {
+		t.Fatal("expected a dry run not to add the order")
+	}
+}
+
+func TestWallet(t *testing.T) {
+	dir, _, s := newNode(t, "")
+	defer s.Close()
+	defer os.RemoveAll(dir)
+
+	x, walletDir := newExecutorDir(t, s.URL)
+	defer os.RemoveAll(walletDir)
+
+	alice := x.exec("tradeblocks", "register", "alice")
+	x.exec("tradeblocks", "issue", "100")
+	x.exec("tradeblocks", "register", "bob")
+	if err := x.c.dispatch([]string{"tradeblocks", "issue", "100"}); err == nil {
+		t.Fatal("expected an error without --as when there are two wallets")
+	}
+
+	file := filepath.Join(walletDir, "alice.export")
+	x.exec("tradeblocks", "wallet", "export", "alice", file)
+	other, otherDir := newExecutorDir(t, s.URL)
+	defer os.RemoveAll(otherDir)
+	if got := other.exec("tradeblocks", "wallet", "import", "carol", file); got != alice {
+		t.Fatalf("expected imported address %s, got %s", alice, got)
+	}
+	other.exec("tradeblocks", "send", "--as", "carol", alice, alice, "10")
+
+	list := x.exec("tradeblocks", "wallet", "list")
+	if !strings.Contains(list, "alice") || !strings.Contains(list, "bob") || !strings.Contains(list, alice) {
+		t.Fatalf("expected alice and bob in wallet list, got:\n%s", list)
+	}
+}
+
+func TestOfflineSigning(t *testing.T) {
+	dir, _, s := newNode(t, "")
+	defer s.Close()
+	defer os.RemoveAll(dir)
+
+	// The signer has the wallet and no node, the online machine has neither
+	signer, signerDir := newExecutorDir(t, "http://localhost:0")
+	defer os.RemoveAll(signerDir)
+	online, onlineDir := newExecutorDir(t, s.URL)
+	defer os.RemoveAll(onlineDir)
+	alice := signer.exec("tradeblocks", "register", "alice")
+
+	unsigned := filepath.Join(onlineDir, "issue.json")
+	signed := filepath.Join(onlineDir, "issue.signed.json")
+	write := func(file, data string) {
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(unsigned, online.exec("tradeblocks", "build", "--account", alice, "issue", "100"))
+	if err := online.c.dispatch([]string{"tradeblocks", "broadcast", unsigned}); err == nil {
+		t.Fatal("expected an error broadcasting an unsigned block")
+	}
+	write(signed, signer.exec("tradeblocks", "sign", unsigned))
+	issue := online.exec("tradeblocks", "broadcast", signed)
+
+	var b tradeblocks.AccountBlock
+	if err := json.Unmarshal([]byte(online.exec("tradeblocks", "cat", issue)), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Action != "issue" || b.Account != alice || b.Balance != 100 {
+		t.Fatalf("expected issue of 100 by %s, got %+v", alice, b)
+	}
+
+	// Build a block that follows the issue
+	write(unsigned, online.exec("tradeblocks", "build", "--account", alice, "send", alice, alice, "10"))
+	write(signed, signer.exec("tradeblocks", "sign", unsigned))
+	online.exec("tradeblocks", "broadcast", signed)
+}
+
 func TestNodeCommand(t *testing.T) {
 	t.Skip("TODO Implement `tradeblocks node` sanity test")
 }
@@ -262,9 +390,10 @@ type executor struct {
 func newExecutor(t *testing.T, serverURL, dataDir string) *executor {
 
 	c := &cli{
-		keySize:   1024,
-		serverURL: serverURL,
-		dataDir:   dataDir,
+		keySize:    1024,
+		serverURL:  serverURL,
+		walletDir:  dataDir,
+		passphrase: testPassphrase,
 	}
 	return &executor{
 		t: t,
@@ -280,3 +409,19 @@ func (x *executor) exec(cmd ...string) string {
 	}
 	return strings.TrimSpace(output.String())
 }
+
+func testPassphrase(prompt string) (string, error) {
+	return "secret", nil
+}
+
+func TestIsTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if isTerminal(r) {
+		t.Fatal("expected a pipe not to be a terminal")
+	}
+}
@@ -1,44 +1,44 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"math"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/wallet"
 	"github.com/jephir/tradeblocks/web"
 )
 
 var verifyLocalSigning = flag.Bool("verifylocalsigning", true, "verify signing of local blocks before sending to node")
 
-func init() {
-	flag.Parse()
-}
-
 type client struct {
-	dir     string
-	keySize int
-	api     *web.Client
-	http    *http.Client
+	keystore *wallet.Keystore
+	keySize  int
+	api      *web.Client
+	ctx      context.Context // cancels requests to the node
+
+	name       string                              // wallet to use, or empty for the only wallet in the keystore
+	passphrase func(prompt string) (string, error) // reads the passphrase of a wallet
+	priv       *rsa.PrivateKey                     // decrypted key of the wallet
+
+	offline bool   // build unsigned blocks instead of signing and posting them
+	account string // address of the account to build blocks for, or empty for the address of the wallet
+	dryRun  bool   // validate signed blocks with the node instead of posting them
 }
 
-func newClient(dir, host string, keySize int) *client {
+func newClient(walletDir, host string, keySize int) *client {
 	return &client{
-		dir:     dir,
-		keySize: keySize,
-		api:     web.NewClient(host),
-		http:    &http.Client{},
+		keystore: wallet.NewKeystore(walletDir),
+		keySize:  keySize,
+		api:      web.NewClient(host),
+		ctx:      context.Background(),
 	}
 }
 
@@ -51,42 +51,18 @@ func (c *client) badInputs(funcName string, additionalInfo string) error {
 }
 
 func (c *client) register(name string) (address string, err error) {
-	privateKeyPath := filepath.Join(c.dir, name+".pem")
-	privateKeyFile, err := os.Create(privateKeyPath)
-	if err != nil {
-		return
-	}
-	defer privateKeyFile.Close()
-	publicKeyPath := filepath.Join(c.dir, name+".pub")
-	publicKeyFile, err := os.Create(publicKeyPath)
-	if err != nil {
-		return
-	}
-	defer publicKeyFile.Close()
-	address, err = app.Register(privateKeyFile, publicKeyFile, name, c.keySize)
+	passphrase, err := c.passphrase(fmt.Sprintf("New passphrase for %s: ", name))
 	if err != nil {
 		return
 	}
-	if err := publicKeyFile.Close(); err != nil {
-		return "", err
+	if passphrase == "" {
+		return "", errors.New("client: passphrase can't be empty")
 	}
-	if err := privateKeyFile.Close(); err != nil {
-		return "", err
-	}
-	return
-}
-
-func (c *client) login(name string) (address string, err error) {
-	if err := ioutil.WriteFile(filepath.Join(c.dir, "user"), []byte(name), 0644); err != nil {
-		return "", err
-	}
-	f, err := c.openPublicKey()
+	w, err := c.keystore.Create(name, passphrase, c.keySize)
 	if err != nil {
 		return
 	}
-	defer f.Close()
-	address, err = app.PublicKeyToAddress(f)
-	return
+	return w.Address, nil
 }
 
 func (c *client) issue(balance float64) (*tradeblocks.AccountBlock, error) {
@@ -454,28 +430,33 @@ func (c *client) sell(quantity float64, base string, ppu float64, quote string)
 	id := app.UniqueID()
 	link := tradeblocks.OrderAddress(account, id)
 
-	send, err := c.send(link, base, quantity)
+	previous, err := c.getAccountHeadBlock(account, base)
+	if err != nil {
+		return nil, fmt.Errorf("client: error getting head block for sell: %s", err.Error())
+	}
+	send, err := c.signAccount(tradeblocks.NewSendBlock(previous, link, quantity))
 	if err != nil {
 		return nil, fmt.Errorf("client: error creating send for sell: %s", err.Error())
 	}
 
-	r, err := c.api.NewGetAddressRequest()
+	executor, err := c.api.Address(c.ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := c.http.Do(r)
+	order, err := c.signOrder(tradeblocks.NewCreateOrderBlock(account, send, quantity, id, false, quote, ppu, executor, 0))
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	executor, err := c.api.DecodeGetAddressResponse(res)
-	if err != nil {
+	// the send and the order are added together, so a failed order doesn't leave the tokens in an unused send
+	if err := c.postBatch([]tradeblocks.NetworkBlock{
+		{Type: "account", Block: send},
+		{Type: "order", Block: order},
+	}); err != nil {
 		return nil, err
 	}
-
-	return c.createOrder(send.Hash(), id, false, quote, ppu, executor, 0)
+	return order, nil
 }
 
 func (c *client) buy(quantity float64, base string, ppu float64, quote string) ([]tradeblocks.Block, error) {
@@ -484,22 +465,18 @@ func (c *client) buy(quantity float64, base string, ppu float64, quote string) (
 		return nil, err
 	}
 
-	r, err := c.api.NewGetBuyOrdersRequest(base, ppu, quote)
+	orders, err := c.api.Book(c.ctx, "buy", base, ppu, quote)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := c.http.Do(r)
+	previous, err := c.getAccountHeadBlock(account, quote)
 	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	orders, err := c.api.DecodeGetOrdersArrayResponse(res)
-	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("client: error getting head block for buy: %s", err.Error())
 	}
 
+	// every matched order gets a send and an offer, which are added in one batch
+	var batch []tradeblocks.NetworkBlock
 	var swaps []tradeblocks.Block
 	for quantity != 0 {
 		// Get order
@@ -512,243 +489,143 @@ func (c *client) buy(quantity float64, base string, ppu float64, quote string) (
 		receiveQuantity := math.Min(quantity, order.Balance)
 		sendQuantity := receiveQuantity * ppu
 
-		send, err := c.send(tradeblocks.SwapAddress(account, order.ID), quote, sendQuantity)
+		send, err := c.signAccount(tradeblocks.NewSendBlock(previous, tradeblocks.SwapAddress(account, order.ID), sendQuantity))
 		if err != nil {
 			return nil, err
 		}
 
-		swap, err := c.offer(send.Hash(), order.ID, order.Account, base, receiveQuantity, order.Executor, order.Fee)
+		swap, err := c.signSwap(tradeblocks.NewOfferBlock(account, send, order.ID, order.Account, base, receiveQuantity, order.Executor, order.Fee))
 		if err != nil {
 			return nil, err
 		}
+		batch = append(batch, tradeblocks.NetworkBlock{Type: "account", Block: send}, tradeblocks.NetworkBlock{Type: "swap", Block: swap})
 		swaps = append(swaps, swap)
+		previous = send
 		quantity -= receiveQuantity
 	}
 
-	return swaps, nil
-}
-
-func (c *client) openPublicKey() (*os.File, error) {
-	userPath := filepath.Join(c.dir, "user")
-	user, err := ioutil.ReadFile(userPath)
-	if err != nil {
-		return nil, err
-	}
-	p := filepath.Join(c.dir, string(user)+".pub")
-	return os.Open(p)
-}
-
-func (c *client) openPrivateKey() (*os.File, error) {
-	userPath := filepath.Join(c.dir, "user")
-	user, err := ioutil.ReadFile(userPath)
-	if err != nil {
+	if err := c.postBatch(batch); err != nil {
 		return nil, err
 	}
-	p := filepath.Join(c.dir, string(user)+".pem")
-	return os.Open(p)
-}
-
-func openPublicKey() (*os.File, error) {
-	user, err := ioutil.ReadFile("user")
-	if err != nil {
-		return nil, err
-	}
-	return os.Open(string(user) + ".pub")
+	return swaps, nil
 }
 
-func openPrivateKey() (*os.File, error) {
-	user, err := ioutil.ReadFile("user")
+func (c *client) history(token, cursor string) (*app.AccountHistory, error) {
+	account, err := c.getUserAccount()
 	if err != nil {
 		return nil, err
 	}
-	return os.Open(string(user) + ".pem")
+	return c.api.History(c.ctx, account, token, cursor, 0)
 }
 
 func (c *client) getAccountHeadBlock(address, token string) (*tradeblocks.AccountBlock, error) {
-	r, err := c.api.NewGetAccountHeadRequest(address, token)
-	if err != nil {
-		return nil, err
-	}
-	res, err := c.http.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	var result tradeblocks.AccountBlock
-	if err := c.api.DecodeAccountBlockResponse(res, &result); err != nil {
-		return nil, err
-	}
-	return &result, nil
+	return c.api.GetAccountHead(c.ctx, address, token)
 }
 
 func (c *client) getHeadSwapBlock(address, id string) (*tradeblocks.SwapBlock, error) {
-	r, err := c.api.NewGetSwapHeadRequest(address, id)
-	if err != nil {
-		return nil, err
-	}
-	res, err := c.http.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	var result tradeblocks.SwapBlock
-	if err := c.api.DecodeSwapBlockResponse(res, &result); err != nil {
-		return nil, err
-	}
-	return &result, nil
+	return c.api.GetSwapHead(c.ctx, address, id)
 }
 
 func (c *client) getHeadOrderBlock(address, id string) (*tradeblocks.OrderBlock, error) {
-	r, err := c.api.NewGetOrderHeadRequest(address, id)
-	if err != nil {
-		return nil, err
-	}
-	res, err := c.http.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	var result tradeblocks.OrderBlock
-	if err := c.api.DecodeOrderBlockResponse(res, &result); err != nil {
-		return nil, err
-	}
-	return &result, nil
+	return c.api.GetOrderHead(c.ctx, address, id)
 }
 
 func (c *client) getAccountBlock(hash string) (*tradeblocks.AccountBlock, error) {
-	r, err := c.api.NewGetBlockRequest(hash)
-	if err != nil {
-		return nil, err
-	}
-	res, err := c.http.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	var result tradeblocks.AccountBlock
-	if err := c.api.DecodeAccountBlockResponse(res, &result); err != nil {
-		return nil, err
-	}
-	return &result, nil
+	return c.api.GetAccountBlock(c.ctx, hash)
 }
 
 func (c *client) getSwapBlock(hash string) (*tradeblocks.SwapBlock, error) {
-	r, err := c.api.NewGetBlockRequest(hash)
-	if err != nil {
-		return nil, err
-	}
-	res, err := c.http.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	var result tradeblocks.SwapBlock
-	if err := c.api.DecodeSwapBlockResponse(res, &result); err != nil {
-		return nil, err
-	}
-	return &result, nil
+	return c.api.GetSwapBlock(c.ctx, hash)
 }
 
 func (c *client) getBlock(hash string) (tradeblocks.Block, error) {
-	r, err := c.api.NewGetBlockRequest(hash)
-	if err != nil {
-		return nil, err
-	}
-	res, err := c.http.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	result, err := c.api.DecodeBlockResponse(res)
-	if err != nil {
-		return nil, err
-	}
-	return result, nil
+	return c.api.GetBlock(c.ctx, hash)
 }
 
 func (c *client) postAccountBlock(b *tradeblocks.AccountBlock) error {
-	req, err := c.api.NewPostAccountBlockRequest(b)
-	if err != nil {
-		return err
-	}
-	res, err := c.http.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	var rb tradeblocks.AccountBlock
-	if err := c.api.DecodeAccountBlockResponse(res, &rb); err != nil {
-		return err
-	}
-	return nil
+	return c.postBlock(b)
 }
 
 func (c *client) postSwapBlock(b *tradeblocks.SwapBlock) error {
-	req, err := c.api.NewPostSwapBlockRequest(b)
-	if err != nil {
-		return err
-	}
-	res, err := c.http.Do(req)
-	if err != nil {
-		return err
+	return c.postBlock(b)
+}
+
+func (c *client) postOrderBlock(b *tradeblocks.OrderBlock) error {
+	return c.postBlock(b)
+}
+
+// postBlock adds the specified block, or validates it if this is a dry run
+func (c *client) postBlock(b tradeblocks.Block) error {
+	if c.offline {
+		return nil
 	}
-	defer res.Body.Close()
-	var rb tradeblocks.SwapBlock
-	if err := c.api.DecodeSwapBlockResponse(res, &rb); err != nil {
-		return err
+	if c.dryRun {
+		return c.validate(b)
 	}
-	return nil
+	return c.api.PostBlock(c.ctx, b)
 }
 
-func (c *client) postOrderBlock(b *tradeblocks.OrderBlock) error {
-	req, err := c.api.NewPostOrderBlockRequest(b)
-	if err != nil {
-		return err
+// postBatch adds the specified blocks in one batch, or validates them if this is a dry run
+func (c *client) postBatch(blocks []tradeblocks.NetworkBlock) error {
+	if c.offline {
+		return nil
 	}
-	res, err := c.http.Do(req)
+	_, err := c.api.PostBatch(c.ctx, blocks, c.dryRun)
+	return err
+}
+
+// validate returns the validation error of the specified block, or nil if the node would add it
+func (c *client) validate(b tradeblocks.Block) error {
+	result, err := c.api.Validate(c.ctx, b)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
-	var rb tradeblocks.OrderBlock
-	if err := c.api.DecodeOrderBlockResponse(res, &rb); err != nil {
-		return err
+	if !result.Valid {
+		return result.Error
 	}
 	return nil
 }
 
-func (c *client) getUserAccount() (string, error) {
-	privateKey, err := c.openPrivateKey()
-	if err != nil {
-		return "", err
+// walletName returns the name of the wallet to use
+func (c *client) walletName() (string, error) {
+	if c.name != "" {
+		return c.name, nil
 	}
-	defer privateKey.Close()
-	priv, err := parsePrivateKey(privateKey)
+	wallets, err := c.keystore.List()
 	if err != nil {
 		return "", err
 	}
-	addr, err := app.PrivateKeyToAddress(priv)
-	if err != nil {
-		return "", err
+	switch len(wallets) {
+	case 0:
+		return "", errors.New("client: no wallets found; create one with $ tradeblocks register <name>")
+	case 1:
+		return wallets[0].Name, nil
 	}
-	return addr, nil
+	return "", errors.New("client: choose a wallet with --as <name>")
 }
 
-func parsePrivateKey(r io.Reader) (*rsa.PrivateKey, error) {
-	keyBytes, err := ioutil.ReadAll(r)
+func (c *client) getUserAccount() (string, error) {
+	if c.account != "" {
+		return c.account, nil
+	}
+	name, err := c.walletName()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	p, _ := pem.Decode(keyBytes)
-	if p == nil {
-		return nil, errors.New("client: no PEM data found")
+	w, err := c.keystore.Get(name)
+	if err == wallet.ErrNotFound {
+		return "", fmt.Errorf("client: no wallet named '%s'", name)
+	} else if err != nil {
+		return "", err
 	}
-
-	return x509.ParsePKCS1PrivateKey(p.Bytes)
+	return w.Address, nil
 }
 
 func (c *client) signAccount(b *tradeblocks.AccountBlock) (*tradeblocks.AccountBlock, error) {
+	if c.offline {
+		b.Normalize()
+		return b, nil
+	}
 	priv, err := c.getPrivateKey()
 	if err != nil {
 		return nil, err
@@ -770,6 +647,10 @@ func (c *client) signAccount(b *tradeblocks.AccountBlock) (*tradeblocks.AccountB
 }
 
 func (c *client) signSwap(b *tradeblocks.SwapBlock) (*tradeblocks.SwapBlock, error) {
+	if c.offline {
+		b.Normalize()
+		return b, nil
+	}
 	priv, err := c.getPrivateKey()
 	if err != nil {
 		return nil, err
@@ -791,6 +672,10 @@ func (c *client) signSwap(b *tradeblocks.SwapBlock) (*tradeblocks.SwapBlock, err
 }
 
 func (c *client) signOrder(b *tradeblocks.OrderBlock) (*tradeblocks.OrderBlock, error) {
+	if c.offline {
+		b.Normalize()
+		return b, nil
+	}
 	priv, err := c.getPrivateKey()
 	if err != nil {
 		return nil, err
@@ -811,11 +696,28 @@ func (c *client) signOrder(b *tradeblocks.OrderBlock) (*tradeblocks.OrderBlock,
 	return b, nil
 }
 
+// getPrivateKey decrypts the key of the wallet, asking for its passphrase the first time
 func (c *client) getPrivateKey() (*rsa.PrivateKey, error) {
-	privateKey, err := c.openPrivateKey()
+	if c.priv != nil {
+		return c.priv, nil
+	}
+	name, err := c.walletName()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.keystore.Get(name); err == wallet.ErrNotFound {
+		return nil, fmt.Errorf("client: no wallet named '%s'", name)
+	} else if err != nil {
+		return nil, err
+	}
+	passphrase, err := c.passphrase(fmt.Sprintf("Passphrase for %s: ", name))
+	if err != nil {
+		return nil, err
+	}
+	priv, err := c.keystore.PrivateKey(name, passphrase)
 	if err != nil {
 		return nil, err
 	}
-	defer privateKey.Close()
-	return parsePrivateKey(privateKey)
+	c.priv = priv
+	return priv, nil
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jephir/tradeblocks/db"
+	"github.com/jephir/tradeblocks/logging"
+)
+
+// envPrefix starts the names of environment variables that override config settings, such as TRADEBLOCKS_LISTEN
+const envPrefix = "TRADEBLOCKS_"
+
+// config has the settings of a node. Settings are read from the defaults, then a config file, then
+// TRADEBLOCKS_* environment variables, then command line flags, and each source overrides the ones before it.
+//
+// A config file is a TOML file with these keys:
+//
+//	dir = "/var/lib/tradeblocks"
+//	db = "postgres://localhost/tradeblocks"
+//	key = "/etc/tradeblocks/node.pem"
+//	listen = "localhost:8080"
+//	bootstrap = "http://seed.example.com:8080"
+//	since = 0
+//	archive = "/mnt/archive"
+//	peers = ["http://a.example.com:8080", "http://b.example.com:8080"]
+//
+//	[executor]
+//	enabled = true
+//	min_fee = 0.01
+//
+//	[log]
+//	level = "info,db=debug"
+//	format = "json"
+type config struct {
+	Dir       string
+	Database  string
+	Key       string
+	Listen    string
+	Bootstrap string
+	Since     int
+	Archive   string
+	Peers     []string
+
+	ExecutorEnabled bool
+	ExecutorMinFee  float64
+
+	LogLevel  string // default level and subsystem=level pairs, see logging.ParseLevels
+	LogFormat string // "json" or "text"
+}
+
+func defaultConfig() *config {
+	return &config{
+		Dir:             ".",
+		Listen:          "localhost:8080",
+		ExecutorEnabled: true,
+		LogLevel:        "info",
+		LogFormat:       "json",
+	}
+}
+
+// logger returns the logger of a node that writes to w
+func (c *config) logger(w io.Writer) (*logging.Root, error) {
+	level, levels, err := logging.ParseLevels(c.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(w, c.LogFormat, level, levels)
+}
+
+// dataSource returns the data source name of the node database
+func (c *config) dataSource() string {
+	if c.Database == "" {
+		return db.SqliteDataSource(filepath.Join(c.Dir, "tradeblocks.db"))
+	}
+	if db.IsPostgres(c.Database) {
+		return c.Database
+	}
+	return db.SqliteDataSource(c.Database)
+}
+
+// keyFile returns the file of the node key
+func (c *config) keyFile() string {
+	if c.Key == "" {
+		return filepath.Join(c.Dir, "node.pem")
+	}
+	return c.Key
+}
+
+// set sets the setting with the specified config file key, which is also the lowercase name of its environment
+// variable without the prefix and with dots replaced by underscores
+func (c *config) set(key string, value string) (err error) {
+	switch key {
+	case "dir":
+		c.Dir = value
+	case "db":
+		c.Database = value
+	case "key":
+		c.Key = value
+	case "listen":
+		c.Listen = value
+	case "bootstrap":
+		c.Bootstrap = value
+	case "since":
+		c.Since, err = strconv.Atoi(value)
+	case "archive":
+		c.Archive = value
+	case "peers":
+		c.Peers = splitList(value)
+	case "executor.enabled":
+		c.ExecutorEnabled, err = strconv.ParseBool(value)
+	case "executor.min_fee":
+		c.ExecutorMinFee, err = strconv.ParseFloat(value, 64)
+	case "log.level":
+		c.LogLevel = value
+		_, _, err = logging.ParseLevels(value)
+	case "log.format":
+		c.LogFormat = value
+		if value != "json" && value != "text" {
+			err = fmt.Errorf("unknown log format")
+		}
+	default:
+		return fmt.Errorf("config: unknown setting '%s'", key)
+	}
+	if err != nil {
+		return fmt.Errorf("config: invalid %s '%s'", key, value)
+	}
+	return nil
+}
+
+// configKeys are the keys of every setting
+var configKeys = []string{"dir", "db", "key", "listen", "bootstrap", "since", "archive", "peers", "executor.enabled", "executor.min_fee", "log.level", "log.format"}
+
+// readEnv overrides the settings that have an environment variable
+func (c *config) readEnv() error {
+	for _, key := range configKeys {
+		name := envPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+		if value, ok := os.LookupEnv(name); ok {
+			if err := c.set(key, value); err != nil {
+				return fmt.Errorf("%s (from %s)", err.Error(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// readFile overrides the settings in the specified config file. Only the part of TOML that's needed for the
+// settings is supported: tables, strings, numbers, booleans and single-line arrays of strings.
+func (c *config) readFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var table string
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(s.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			table = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+		i := strings.Index(text, "=")
+		if i < 0 {
+			return fmt.Errorf("config: %s:%d: expected key = value", name, line)
+		}
+		key := strings.TrimSpace(text[:i])
+		if table != "" {
+			key = table + "." + key
+		}
+		value, err := parseConfigValue(strings.TrimSpace(text[i+1:]))
+		if err != nil {
+			return fmt.Errorf("config: %s:%d: %s", name, line, err.Error())
+		}
+		if err := c.set(key, value); err != nil {
+			return fmt.Errorf("%s in %s:%d", err.Error(), name, line)
+		}
+	}
+	return s.Err()
+}
+
+// parseConfigValue returns the value of a config file setting as it's written in an environment variable,
+// with array elements separated by commas
+func parseConfigValue(v string) (string, error) {
+	if strings.HasPrefix(v, "[") {
+		if !strings.HasSuffix(v, "]") {
+			return "", fmt.Errorf("unterminated array %s", v)
+		}
+		var elems []string
+		for _, e := range strings.Split(v[1:len(v)-1], ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			s, err := parseConfigValue(e)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, s)
+		}
+		return strings.Join(elems, ","), nil
+	}
+	if strings.HasPrefix(v, `"`) {
+		return strconv.Unquote(v)
+	}
+	if strings.HasPrefix(v, "'") {
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	}
+	return v, nil
+}
+
+// stripComment removes a # comment that isn't in a string from the specified line
+func stripComment(line string) string {
+	var quote rune
+	var escaped bool
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// listValue is a flag of comma-separated values
+type listValue []string
+
+func (v *listValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	*v = splitList(s)
+	return nil
+}
+
+// newConfigFlags returns the flags of a command that uses the node database
+func newConfigFlags(name string, c *config) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.String("config", os.Getenv(envPrefix+"CONFIG"), "node config file")
+	flags.StringVar(&c.Dir, "dir", c.Dir, "data directory")
+	flags.StringVar(&c.Database, "db", c.Database, "database to store blocks in: a postgres:// URL or a sqlite file (default <dir>/tradeblocks.db)")
+	flags.StringVar(&c.Key, "key", c.Key, "node key file (default <dir>/node.pem)")
+	return flags
+}
+
+// parseConfig parses the command line flags, then reads the config file and environment into c. Flags that are
+// set on the command line are applied again afterwards so they override the config file and environment.
+func parseConfig(flags *flag.FlagSet, c *config, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	set := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	if name := flags.Lookup("config").Value.String(); name != "" {
+		if err := c.readFile(name); err != nil {
+			return err
+		}
+	}
+	if err := c.readEnv(); err != nil {
+		return err
+	}
+	for name, value := range set {
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "node.toml")
+	if err := ioutil.WriteFile(file, []byte(`# node config
+dir = "/var/lib/tradeblocks"
+listen = "0.0.0.0:8080" # all interfaces
+bootstrap = 'http://seed:8080'
+since = 42
+peers = ["http://a:8080", "http://b:8080"]
+
+[executor]
+enabled = false
+min_fee = 0.5
+
+[log]
+level = "warn,db=debug"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TRADEBLOCKS_LISTEN", "0.0.0.0:9090")
+	os.Setenv("TRADEBLOCKS_SINCE", "7")
+	defer os.Unsetenv("TRADEBLOCKS_LISTEN")
+	defer os.Unsetenv("TRADEBLOCKS_SINCE")
+
+	c := defaultConfig()
+	flags := newConfigFlags("node", c)
+	flags.StringVar(&c.Listen, "listen", c.Listen, "")
+	flags.IntVar(&c.Since, "since", c.Since, "")
+	if err := parseConfig(flags, c, []string{"-config", file, "-since", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	expect := &config{
+		Dir:            "/var/lib/tradeblocks",
+		Listen:         "0.0.0.0:9090",
+		Bootstrap:      "http://seed:8080",
+		Since:          3,
+		Peers:          []string{"http://a:8080", "http://b:8080"},
+		ExecutorMinFee: 0.5,
+		LogLevel:       "warn,db=debug",
+		LogFormat:      "json",
+	}
+	if !reflect.DeepEqual(c, expect) {
+		t.Fatalf("expected %+v, got %+v", expect, c)
+	}
+	if got := c.keyFile(); got != filepath.Join("/var/lib/tradeblocks", "node.pem") {
+		t.Fatalf("expected key file in the data directory, got %s", got)
+	}
+
+	for _, text := range []string{"lisen = \"localhost:8080\"\n", "[log]\nlevel = \"loud\"\n"} {
+		if err := ioutil.WriteFile(file, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c = defaultConfig()
+		if err := parseConfig(newConfigFlags("node", c), c, []string{"-config", file}); err == nil {
+			t.Fatalf("expected an error for %q", text)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jephir/tradeblocks/db"
+)
+
+func (cli *cli) handleDB(args []string) error {
+	if len(args) < 3 || args[2] != "migrate" {
+		return errors.New("Run this command with $ tradeblocks db migrate [--dry-run]")
+	}
+	c := defaultConfig()
+	flags := newConfigFlags("migrate", c)
+	dryRun := flags.Bool("dry-run", false, "print pending migrations without applying them")
+	if err := parseConfig(flags, c, args[3:]); err != nil {
+		return err
+	}
+
+	d, err := db.Open(c.dataSource())
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	var migrations []db.Migration
+	if *dryRun {
+		migrations, err = d.PendingMigrations()
+	} else {
+		migrations, err = d.Migrate()
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		fmt.Fprintf(cli.out, "version %d: %s\n", m.Version, m.Description)
+		if *dryRun {
+			for _, stmnt := range m.Statements {
+				fmt.Fprintln(cli.out, stmnt)
+			}
+		}
+	}
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(cli.out, "%d pending migrations, schema version %d\n", len(migrations), version)
+	} else {
+		fmt.Fprintf(cli.out, "applied %d migrations, schema version %d\n", len(migrations), version)
+	}
+	return nil
+}
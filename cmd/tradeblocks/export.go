@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jephir/tradeblocks/web"
+)
+
+func (cli *cli) handleExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	since := flags.Int("since", 0, "only export blocks with a greater sequence")
+	cursorFile := flags.String("cursor", "", "file to resume the export from and to save the sequence of the last exported block to")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *cursorFile != "" {
+		b, err := ioutil.ReadFile(*cursorFile)
+		if err == nil {
+			if *since, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	cmd := newClient(cli.walletDir, cli.serverURL, cli.keySize)
+	enc := json.NewEncoder(cli.out)
+	cursor, err := cmd.api.Export(cmd.api.Do, *since, func(e *web.ExportRecord) error {
+		return enc.Encode(e)
+	})
+	if *cursorFile != "" && cursor > *since {
+		// Save the cursor even if the export failed, so the next export resumes after the last record
+		if err := ioutil.WriteFile(*cursorFile, []byte(strconv.Itoa(cursor)+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+	return err
+}
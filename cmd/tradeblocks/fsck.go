@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+)
+
+func (cli *cli) handleFsck(args []string) error {
+	c := defaultConfig()
+	if err := parseConfig(newConfigFlags("fsck", c), c, args[2:]); err != nil {
+		return err
+	}
+	d, err := db.Open(c.dataSource())
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	r, err := app.VerifyLedger(app.NewBlockStoreWithStore(d))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cli.out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+	if !r.OK() {
+		return fmt.Errorf("fsck: found %d problems", len(r.Problems))
+	}
+	return nil
+}
@@ -1,20 +1,100 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"io"
 	"os"
-)
+	"os/exec"
+	"strings"
 
-var n = flag.String("node", "localhost:8080", "node address to connect to")
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/wallet"
+)
 
 func main() {
+	defaultNode := os.Getenv(envPrefix + "NODE")
+	if defaultNode == "" {
+		defaultNode = "localhost:8080"
+	}
+	defaultWalletDir := os.Getenv(envPrefix + "WALLET_DIR")
+	if defaultWalletDir == "" {
+		dir, err := wallet.DefaultDir()
+		if err != nil {
+			panic(err)
+		}
+		defaultWalletDir = dir
+	}
+	n := flag.String("node", defaultNode, "node address to connect to")
+	walletDir := flag.String("wallet-dir", defaultWalletDir, "directory of the wallet keystore")
+	flag.Parse()
+
 	c := &cli{
-		keySize:   4096,
-		serverURL: "http://" + *n,
-		dataDir:   ".",
-		out:       os.Stdout,
+		keySize:    4096,
+		serverURL:  hostURL(*n),
+		walletDir:  *walletDir,
+		passphrase: readPassphrase,
+		out:        os.Stdout,
 	}
-	if err := c.dispatch(os.Args); err != nil {
+	if err := c.dispatch(append([]string{os.Args[0]}, flag.Args()...)); err != nil {
+		if be, ok := err.(*app.BatchError); ok {
+			if e, ok := be.Err.(*app.ValidationError); ok {
+				fmt.Fprintf(os.Stderr, "block %d of the batch (%s) was rejected\n", be.Index, be.Hash)
+				printValidationError(os.Stderr, e)
+				os.Exit(1)
+			}
+		}
+		if e, ok := err.(*app.ValidationError); ok {
+			printValidationError(os.Stderr, e)
+			os.Exit(1)
+		}
 		panic(err)
 	}
 }
+
+// printValidationError prints why a node rejected a block
+func printValidationError(w io.Writer, e *app.ValidationError) {
+	fmt.Fprintf(w, "invalid block: %s: %s\n", e.Code, e.Message)
+	if e.Field != "" {
+		fmt.Fprintf(w, "  field: %s\n", e.Field)
+	}
+	for _, h := range e.Hashes {
+		fmt.Fprintf(w, "  related block: %s\n", h)
+	}
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase returns $TRADEBLOCKS_PASSPHRASE if it's set, or else reads a line from stdin. The line isn't
+// echoed if stdin is a terminal.
+func readPassphrase(prompt string) (string, error) {
+	if passphrase, ok := os.LookupEnv(envPrefix + "PASSPHRASE"); ok {
+		return passphrase, nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if isTerminal(os.Stdin) && stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// isTerminal returns whether f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// stty changes a setting of the terminal on stdin
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
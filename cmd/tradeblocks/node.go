@@ -1,34 +1,83 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
 
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
 	"github.com/jephir/tradeblocks/node"
 )
 
-var addr = flag.String("listen", "localhost:8080", "listen address")
-var bootstrap = flag.String("bootstrap", "", "bootstrap node URL")
-var dir = flag.String("dir", ".", "database directory")
-
-func init() {
-	flag.Parse()
-}
+func (cli *cli) handleNode(args []string) error {
+	c := defaultConfig()
+	flags := newConfigFlags("node", c)
+	flags.StringVar(&c.Listen, "listen", c.Listen, "listen address")
+	flags.StringVar(&c.Bootstrap, "bootstrap", c.Bootstrap, "bootstrap node URL")
+	flags.IntVar(&c.Since, "since", c.Since, "only bootstrap blocks after this sequence of the bootstrap node, such as the sequence of an imported snapshot")
+	flags.StringVar(&c.Archive, "archive", c.Archive, "directory of a block archive that the node saves every block to")
+	flags.Var((*listValue)(&c.Peers), "peers", "comma-separated URLs of nodes to broadcast every block to")
+	flags.BoolVar(&c.ExecutorEnabled, "executor", c.ExecutorEnabled, "fill orders for offers that name this node as executor")
+	flags.Float64Var(&c.ExecutorMinFee, "executor-min-fee", c.ExecutorMinFee, "minimum fee of an offer that this node fills orders for")
+	flags.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level, optionally followed by subsystem levels such as db=debug,web=warn")
+	flags.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format: json or text")
+	if err := parseConfig(flags, c, args[2:]); err != nil {
+		return err
+	}
 
-func (cli *cli) handleNode() error {
-	n, err := node.NewNode(*dir)
+	n, err := openNode(c)
 	if err != nil {
 		return err
 	}
-	if *addr != "" && *bootstrap != "" {
-		if err := n.Bootstrap(*addr, *bootstrap); err != nil {
+	n.SetExecutor(c.ExecutorEnabled, c.ExecutorMinFee)
+	for _, peer := range c.Peers {
+		n.AddPeer(peer)
+	}
+	if c.Listen != "" && c.Bootstrap != "" {
+		if err := n.BootstrapSince(hostURL(c.Listen), c.Bootstrap, c.Since); err != nil {
 			return err
 		}
 	}
-	if *addr != "" {
-		fmt.Fprintln(cli.out, *addr)
-		return http.ListenAndServe(*addr, n)
+	if c.Archive != "" {
+		if err := n.SetArchive(c.Archive); err != nil {
+			return err
+		}
+	}
+	if c.Listen != "" {
+		fmt.Fprintln(cli.out, c.Listen)
+		return http.ListenAndServe(c.Listen, n)
 	}
 	return nil
 }
+
+func openNode(c *config) (*node.Node, error) {
+	l, err := c.logger(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := node.LoadKeyFile(c.keyFile())
+	if err != nil {
+		return nil, err
+	}
+	d, err := db.NewDB(c.dataSource())
+	if err != nil {
+		return nil, err
+	}
+	d.SetLogger(l.For("db"))
+	n, err := node.NewNodeWithStore(app.NewBlockStoreWithStore(d), priv)
+	if err != nil {
+		return nil, err
+	}
+	n.SetLogger(l)
+	return n, nil
+}
+
+// hostURL returns the URL that other nodes reach a node listening on the specified address at
+func hostURL(listen string) string {
+	if strings.Contains(listen, "://") {
+		return listen
+	}
+	return "http://" + listen
+}
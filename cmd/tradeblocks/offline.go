@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/wallet"
+)
+
+// A block file has one block and its type as JSON, like a tradeblocks.NetworkBlock without sequence information.
+// build writes unsigned block files, sign adds the signature and broadcast posts the signed block to a node, so
+// a wallet key can stay on a machine without network access.
+type blockFile struct {
+	Type  string
+	Block tradeblocks.Block
+}
+
+// buildCommands are the commands that build can create blocks with
+var buildCommands = map[string]bool{
+	"issue":          true,
+	"send":           true,
+	"open":           true,
+	"open-from-swap": true,
+	"receive":        true,
+	"offer":          true,
+	"commit":         true,
+	"refund-left":    true,
+	"refund-right":   true,
+	"create-order":   true,
+	"accept-order":   true,
+	"refund-order":   true,
+}
+
+func writeBlockFile(w io.Writer, typ string, b tradeblocks.Block) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(blockFile{
+		Type:  typ,
+		Block: b,
+	})
+}
+
+func readBlockFile(name string) (*tradeblocks.NetworkBlock, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var b tradeblocks.NetworkBlock
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("client: reading block file %s: %s", name, err.Error())
+	}
+	if b.Type == "confirm" {
+		return nil, errors.New("client: confirm blocks are created by nodes")
+	}
+	return &b, nil
+}
+
+// handleBuild runs a command that creates a block, but prints the unsigned block instead of signing and posting it.
+// The node is still used to look up previous blocks.
+func (cli *cli) handleBuild(args []string) error {
+	const usage = "Run this command with $ tradeblocks build [--account <address>] <command> [arguments]"
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	account := flags.String("account", "", "address of the account to build the block for (default: the address of the --as wallet)")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New(usage)
+	}
+	if !buildCommands[flags.Arg(0)] {
+		return fmt.Errorf("client: can't build '%s' blocks offline", flags.Arg(0))
+	}
+	build := *cli
+	build.offline = true
+	build.account = *account
+	return build.dispatch(append([]string{args[0]}, flags.Args()...))
+}
+
+// handleSign signs a block file with the wallet of its signer and prints the signed block
+func (cli *cli) handleSign(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	name := flags.String("as", os.Getenv(envPrefix+"WALLET"), "name of the wallet to sign with (default: the wallet of the block's signer)")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Run this command with $ tradeblocks sign [--as <name>] <file>")
+	}
+	nb, err := readBlockFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	signer := app.Signer(nb.Block)
+
+	cmd := newClient(cli.walletDir, cli.serverURL, cli.keySize)
+	cmd.passphrase = cli.passphrase
+	cmd.name = *name
+	if cmd.name == "" {
+		wallets, err := cmd.keystore.List()
+		if err != nil {
+			return err
+		}
+		for _, w := range wallets {
+			if w.Address == signer {
+				cmd.name = w.Name
+			}
+		}
+		if cmd.name == "" {
+			return fmt.Errorf("client: no wallet with the signer address %s", signer)
+		}
+	}
+	if w, err := cmd.keystore.Get(cmd.name); err == wallet.ErrNotFound {
+		return fmt.Errorf("client: no wallet named '%s'", cmd.name)
+	} else if err != nil {
+		return err
+	} else if w.Address != signer {
+		return fmt.Errorf("client: block must be signed by %s, wallet '%s' has address %s", signer, w.Name, w.Address)
+	}
+	priv, err := cmd.getPrivateKey()
+	if err != nil {
+		return err
+	}
+	if err := nb.Block.SignBlock(priv); err != nil {
+		return err
+	}
+	if err := app.VerifySignature(nb.Block); err != nil {
+		return fmt.Errorf("client: verification error: %s", err.Error())
+	}
+	return writeBlockFile(cli.out, nb.Type, nb.Block)
+}
+
+// handleBroadcast posts a signed block file to the node and prints the hash of the block
+func (cli *cli) handleBroadcast(args []string) error {
+	if len(args) != 3 {
+		return errors.New("Run this command with $ tradeblocks broadcast <file>")
+	}
+	nb, err := readBlockFile(args[2])
+	if err != nil {
+		return err
+	}
+	if err := app.VerifySignature(nb.Block); err != nil {
+		return fmt.Errorf("client: block %s isn't signed: %s", nb.Block.Hash(), err.Error())
+	}
+	cmd := newClient(cli.walletDir, cli.serverURL, cli.keySize)
+	switch b := nb.Block.(type) {
+	case *tradeblocks.AccountBlock:
+		err = cmd.postAccountBlock(b)
+	case *tradeblocks.SwapBlock:
+		err = cmd.postSwapBlock(b)
+	case *tradeblocks.OrderBlock:
+		err = cmd.postOrderBlock(b)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, nb.Block.Hash())
+	return nil
+}
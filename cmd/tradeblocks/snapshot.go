@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+	"github.com/jephir/tradeblocks/node"
+)
+
+const snapshotUsage = "Run this command with $ tradeblocks snapshot export [flags] <file> [sequence] or $ tradeblocks snapshot import [flags] <file> <node address>"
+
+func (cli *cli) handleSnapshot(args []string) error {
+	if len(args) < 3 || (args[2] != "export" && args[2] != "import") {
+		return errors.New(snapshotUsage)
+	}
+	c := defaultConfig()
+	flags := newConfigFlags("snapshot", c)
+	if err := parseConfig(flags, c, args[3:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || (args[2] == "import" && flags.NArg() < 2) {
+		return errors.New(snapshotUsage)
+	}
+	file := flags.Arg(0)
+	d, err := db.NewDB(c.dataSource())
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	store := app.NewBlockStoreWithStore(d)
+
+	if args[2] == "export" {
+		var sequence int
+		if flags.NArg() > 1 {
+			if sequence, err = strconv.Atoi(flags.Arg(1)); err != nil {
+				return err
+			}
+		}
+		priv, err := node.LoadKeyFile(c.keyFile())
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		h, err := app.ExportSnapshot(f, store, sequence, priv)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cli.out, "exported snapshot at sequence %d signed by %s\n", h.Sequence, h.Node)
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := app.ImportSnapshot(f, store, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "imported snapshot at sequence %d signed by %s\n", h.Sequence, h.Node)
+	return nil
+}
@@ -11,13 +11,6 @@ func registerInputValidation(args []string) (goodInputs bool, addInfo string) {
 	return
 }
 
-func loginInputValidation(args []string) (goodInputs bool, addInfo string) {
-	goodInputs = len(args) == 3
-	addInfo = "CLI args invalid length.\n" +
-		"Run this command with $ tradeblocks login <name: string>"
-	return
-}
-
 func issueInputValidation(args []string) (goodInputs bool, addInfo string) {
 	addInfo = "CLI args invalid length.\n" +
 		"Run this command with $ tradeblocks issue <balance: float64>"
@@ -174,3 +167,10 @@ func refundOrderInputValidation(args []string) (goodInputs bool, addInfo string)
 	goodInputs = len(args) == 3
 	return
 }
+
+func historyInputValidation(args []string) (goodInputs bool, addInfo string) {
+	addInfo = "CLI args invalid length.\n" +
+		"Run this command with $ tradeblocks history <token: string> <cursor: string, OPTIONAL>\n"
+	goodInputs = len(args) == 3 || len(args) == 4
+	return
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"text/tabwriter"
+
+	"github.com/jephir/tradeblocks/wallet"
+)
+
+const walletUsage = "Run this command with $ tradeblocks wallet list, $ tradeblocks wallet export <name> [file] or $ tradeblocks wallet import <name> <file>"
+
+func (cli *cli) handleWallet(args []string) error {
+	if len(args) < 3 {
+		return errors.New(walletUsage)
+	}
+	k := wallet.NewKeystore(cli.walletDir)
+	switch {
+	case args[2] == "list" && len(args) == 3:
+		wallets, err := k.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(cli.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tADDRESS")
+		for _, w := range wallets {
+			fmt.Fprintf(tw, "%s\t%s\n", w.Name, w.Address)
+		}
+		return tw.Flush()
+	case args[2] == "export" && (len(args) == 4 || len(args) == 5):
+		b, err := k.Export(args[3])
+		if err != nil {
+			return err
+		}
+		if len(args) == 5 {
+			return ioutil.WriteFile(args[4], b, 0600)
+		}
+		_, err = cli.out.Write(b)
+		return err
+	case args[2] == "import" && len(args) == 5:
+		b, err := ioutil.ReadFile(args[4])
+		if err != nil {
+			return err
+		}
+		passphrase, err := cli.passphrase(fmt.Sprintf("Passphrase for %s: ", args[3]))
+		if err != nil {
+			return err
+		}
+		if passphrase == "" {
+			return errors.New("wallet: passphrase can't be empty")
+		}
+		w, err := k.Import(args[3], b, passphrase)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cli.out, w.Address)
+		return nil
+	}
+	return errors.New(walletUsage)
+}
@@ -1,11 +1,16 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/logging"
+	_ "github.com/lib/pq"           // postgres driver
 	_ "github.com/mattn/go-sqlite3" // sqlite driver
 )
 
@@ -23,156 +28,250 @@ const (
 	ConfirmTag = 3
 )
 
+// TagType returns the block type name of the specified tag
+func TagType(tag int) string {
+	switch tag {
+	case AccountTag:
+		return "account"
+	case SwapTag:
+		return "swap"
+	case OrderTag:
+		return "order"
+	case ConfirmTag:
+		return "confirm"
+	}
+	return ""
+}
+
 type scanner interface {
 	Scan(dest ...interface{}) error
 }
 
+// sequenceScanner scans the sequence column in front of the block columns
+type sequenceScanner struct {
+	s        scanner
+	sequence *int
+}
+
+func (s sequenceScanner) Scan(dest ...interface{}) error {
+	return s.s.Scan(append([]interface{}{s.sequence}, dest...)...)
+}
+
+// BlockFilter selects blocks by sequence and field values. Empty fields match any value.
+type BlockFilter struct {
+	Account string
+	Token   string
+	Action  string
+
+	// Since only matches blocks with a greater sequence. Sequences are shared by every block type.
+	Since int
+
+	// Limit is the maximum number of blocks to return or zero for no limit
+	Limit int
+}
+
+// clause returns the WHERE, ORDER BY and LIMIT clauses for this filter and their arguments
+func (f BlockFilter) clause() (string, []interface{}) {
+	where := []string{"sequence > $1"}
+	args := []interface{}{f.Since}
+	add := func(column, value string) {
+		if value != "" {
+			args = append(args, value)
+			where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	add("account", f.Account)
+	add("token", f.Token)
+	add("action", f.Action)
+	q := " WHERE " + strings.Join(where, " AND ") + " ORDER BY sequence"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		q += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return q, args
+}
+
 var (
 	// ErrNotFound is returned when data is not found
 	ErrNotFound = errors.New("db: not found")
+
+	// errRollback records a transaction that was rolled back on purpose
+	errRollback = errors.New("db: rolled back")
+)
+
+// dialect holds the column types and catalog queries that differ between database engines
+type dialect struct {
+	driver   string
+	real     string
+	sequence string
+	boolean  string
+
+	// tableExists counts the tables named $1
+	tableExists string
+
+	// readIsolation makes every statement of a read transaction see the same snapshot
+	readIsolation sql.IsolationLevel
+}
+
+var (
+	sqliteDialect = dialect{
+		driver:   "sqlite3",
+		real:     "REAL",
+		sequence: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		boolean:  "INTEGER",
+
+		tableExists: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`,
+
+		// sqlite transactions are serializable
+		readIsolation: sql.LevelDefault,
+	}
+	postgresDialect = dialect{
+		driver:   "postgres",
+		real:     "DOUBLE PRECISION",
+		sequence: "BIGSERIAL PRIMARY KEY",
+		boolean:  "BOOLEAN",
+
+		tableExists: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`,
+
+		// the default of read committed takes a new snapshot for every statement
+		readIsolation: sql.LevelRepeatableRead,
+	}
 )
 
-// DB represents a database
+// IsPostgres reports whether the data source is a PostgreSQL connection URL
+func IsPostgres(dataSourceName string) bool {
+	return strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://")
+}
+
+// SqliteDataSource returns the data source name of the specified sqlite database file with foreign keys enabled
+func SqliteDataSource(file string) string {
+	return fmt.Sprintf("file:%s?_foreign_keys=true", file)
+}
+
+// DB represents a database. Writes go through a single connection, reads use a separate pool.
 type DB struct {
-	db *sql.DB
+	db      *sql.DB
+	reader  *sql.DB
+	dialect dialect
+	log     *logging.Logger
 }
 
-// NewDB connects to the specified data source
+// NewDB connects to the specified data source and applies pending migrations. A postgres:// or postgresql:// URL
+// connects to PostgreSQL, anything else is opened with sqlite.
 func NewDB(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+	d, err := Open(dataSourceName)
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(1)
-	d := &DB{
-		db: db,
-	}
-	if err := d.init(); err != nil {
+	if _, err := d.Migrate(); err != nil {
+		d.Close()
 		return nil, err
 	}
 	return d, nil
 }
 
-func (m *DB) init() (err error) {
-	s := make(map[string]string)
-	s["createAccountsTable"] = `CREATE TABLE IF NOT EXISTS accounts(
-		action TEXT NOT NULL CHECK (action IN ('open', 'issue', 'send', 'receive')),
-		account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
-		token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
-		previous TEXT UNIQUE,
-		representative TEXT NOT NULL CHECK (representative LIKE 'xtb:%'),
-		balance REAL NOT NULL CHECK (balance >= 0),
-		link TEXT,
-		signature TEXT NOT NULL UNIQUE,
-		hash TEXT NOT NULL UNIQUE,
-		FOREIGN KEY (previous) REFERENCES accounts(hash),
-		PRIMARY KEY (hash)
-		);`
-	s["createSwapsTable"] = `CREATE TABLE IF NOT EXISTS swaps(
-		action TEXT NOT NULL CHECK (action IN ('offer', 'commit', 'refund-left', 'refund-right')),
-		account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
-		token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
-		id TEXT NOT NULL,
-		previous TEXT UNIQUE,
-		left TEXT NOT NULL,
-		right TEXT,
-		refund_left TEXT CHECK (refund_left LIKE 'xtb:%'),
-		refund_right TEXT CHECK (refund_right LIKE 'xtb:%'),
-		counterparty TEXT NOT NULL CHECK (counterparty LIKE 'xtb:%'),
-		want TEXT NOT NULL CHECK (want LIKE 'xtb:%'),
-		quantity REAL NOT NULL CHECK (quantity >= 0),
-		executor TEXT CHECK (executor LIKE 'xtb:%'),
-		fee REAL,
-		signature TEXT NOT NULL UNIQUE,
-		hash TEXT NOT NULL UNIQUE,
-		FOREIGN KEY (previous) REFERENCES swaps(hash),
-		PRIMARY KEY (hash)
-		);`
-	s["createOrdersTable"] = `CREATE TABLE IF NOT EXISTS orders(
-		action TEXT NOT NULL CHECK (action IN ('create-order', 'accept-order', 'refund-order')),
-		account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
-		token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
-		id TEXT NOT NULL,
-		previous TEXT,
-		balance REAL NOT NULL CHECK (balance >= 0),
-		quote TEXT NOT NULL,
-		price REAL NOT NULL CHECK (price >= 0),
-		link TEXT NOT NULL,
-		partial INTEGER NOT NULL,
-		executor TEXT CHECK (executor LIKE 'xtb:%'),
-		fee REAL,
-		signature TEXT NOT NULL UNIQUE,
-		hash TEXT NOT NULL UNIQUE,
-		FOREIGN KEY (previous) REFERENCES orders(hash),
-		PRIMARY KEY (hash)
-		);`
-	s["createConfirmsTable"] = `CREATE TABLE IF NOT EXISTS confirms(
-		previous TEXT,
-		addr TEXT NOT NULL CHECK (addr LIKE 'xtb:%'),
-		head TEXT NOT NULL,
-		account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
-		signature TEXT NOT NULL UNIQUE,
-		hash TEXT NOT NULL UNIQUE,
-		FOREIGN KEY (previous) REFERENCES confirms(hash),
-		PRIMARY KEY (hash)
-		);`
-	s["createBlocksTable"] = `CREATE TABLE IF NOT EXISTS blocks(
-		tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
-		hash TEXT NOT NULL,
-		PRIMARY KEY (hash)
-		);`
-	s["createHeadsTable"] = `CREATE TABLE IF NOT EXISTS heads(
-		tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
-		account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
-		key TEXT NOT NULL,
-		head TEXT NOT NULL,
-		PRIMARY KEY (tag, account, key)
-		);`
-	tx, err := m.db.Begin()
+// Open connects to the specified data source without applying migrations. sqlite databases are opened in WAL mode
+// so reads don't wait for the writer.
+func Open(dataSourceName string) (*DB, error) {
+	d := &DB{
+		dialect: sqliteDialect,
+		log:     logging.Default("db"),
+	}
+	if IsPostgres(dataSourceName) {
+		d.dialect = postgresDialect
+	}
+	writerSource, readerSource := dataSourceName, dataSourceName
+	if d.dialect == sqliteDialect {
+		writerSource = withParams(dataSourceName, "_journal_mode=WAL", "_busy_timeout=5000")
+		readerSource = withParams(dataSourceName, "_busy_timeout=5000", "_query_only=true")
+	}
+	writer, err := sql.Open(d.dialect.driver, writerSource)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
+	writer.SetMaxOpenConns(1)
+	d.db = writer
+	d.reader = writer
+	if !isMemory(dataSourceName) {
+		d.reader, err = sql.Open(d.dialect.driver, readerSource)
 		if err != nil {
-			if err2 := tx.Rollback(); err2 != nil {
-				fmt.Println(err2)
-			}
-			return
+			writer.Close()
+			return nil, err
 		}
-		err = tx.Commit()
-	}()
+	}
+	return d, nil
+}
 
-	for n, stmnt := range s {
-		_, err = tx.Exec(stmnt)
-		if err != nil {
-			return fmt.Errorf("db: error executing statement %s: %s", n, err.Error())
-		}
+// isMemory reports whether the data source is an in-memory sqlite database. Every connection to a private
+// in-memory database sees a different database, so it can't have a separate read pool.
+func isMemory(dataSourceName string) bool {
+	return strings.Contains(dataSourceName, ":memory:") || strings.Contains(dataSourceName, "mode=memory")
+}
+
+// withParams appends query parameters to a data source name
+func withParams(dataSourceName string, params ...string) string {
+	sep := "?"
+	if strings.Contains(dataSourceName, "?") {
+		sep = "&"
 	}
+	return dataSourceName + sep + strings.Join(params, "&")
+}
 
-	return nil
+// SetLogger sets the logger of this database and the transactions it starts
+func (m *DB) SetLogger(l *logging.Logger) {
+	m.log = l
 }
 
 // Close releases all resources used by this database
 func (m *DB) Close() error {
-	return m.db.Close()
+	err := m.db.Close()
+	if m.reader != m.db {
+		if err2 := m.reader.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
 }
 
-// NewTransaction initializes a new transaction. It must be finished with a call to Commit().
-func (m *DB) NewTransaction() (*Transaction, error) {
+// NewTransaction initializes a new write transaction. It must be finished with a call to Commit().
+func (m *DB) NewTransaction() (Tx, error) {
 	tx, err := m.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	return &Transaction{
-		tx: tx,
+		tx:    tx,
+		log:   m.log,
+		start: time.Now(),
+	}, nil
+}
+
+// NewReadTransaction initializes a new read-only transaction that sees the blocks committed when its first statement
+// runs. It must be finished with a call to Commit().
+func (m *DB) NewReadTransaction() (ReadTx, error) {
+	tx, err := m.reader.BeginTx(context.Background(), &sql.TxOptions{
+		Isolation: m.dialect.readIsolation,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		tx:       tx,
+		log:      m.log,
+		readOnly: true,
+		start:    time.Now(),
 	}, nil
 }
 
 // Transaction represents a database transaction
 type Transaction struct {
-	tx     *sql.Tx
-	err    error
-	closed bool
+	tx       *sql.Tx
+	err      error
+	closed   bool
+	readOnly bool
+	start    time.Time
+	log      *logging.Logger
 }
 
 // Commit commits the transaction or does a rollback if there's an error
@@ -181,21 +280,43 @@ func (m *Transaction) Commit() error {
 		return nil
 	}
 	if m.err != nil {
-		fmt.Println("db: rolling back")
+		m.log.Debug("rolling back transaction", "error", m.err)
 		if err := m.tx.Rollback(); err != nil {
-			fmt.Printf("db: error doing rollback: %s", err.Error())
+			m.log.Error("can't roll back transaction", "error", err)
 		}
 		m.closed = true
+		observeTx(m.start, m.readOnly, m.err)
 		return m.err
 	}
 	err := m.tx.Commit()
 	m.closed = true
+	observeTx(m.start, m.readOnly, err)
 	if err != nil {
-		fmt.Printf("db: error doing commit: %s", err.Error())
+		m.log.Error("can't commit transaction", "error", err)
 	}
 	return err
 }
 
+// Rollback aborts the transaction
+func (m *Transaction) Rollback() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	observeTx(m.start, m.readOnly, errRollback)
+	return m.tx.Rollback()
+}
+
+// insertBlock assigns the next sequence to the block with the specified tag and hash and records when it was added
+func (m *Transaction) insertBlock(tag int, hash string) error {
+	_, err := m.tx.Exec(`INSERT INTO blocks (
+		tag,
+		hash,
+		added
+		) VALUES ($1, $2, $3)`, tag, hash, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
 // InsertAccountBlock inserts the specified block into the database
 func (m *Transaction) InsertAccountBlock(b *tradeblocks.AccountBlock) error {
 	var previousOrNil interface{}
@@ -228,10 +349,7 @@ func (m *Transaction) InsertAccountBlock(b *tradeblocks.AccountBlock) error {
 	if m.err != nil {
 		return m.err
 	}
-	_, m.err = m.tx.Exec(`INSERT INTO blocks (
-		tag,
-		hash
-		) VALUES ($1, $2)`, AccountTag, hash)
+	m.err = m.insertBlock(AccountTag, hash)
 	if m.err != nil {
 		return m.err
 	}
@@ -241,7 +359,7 @@ func (m *Transaction) InsertAccountBlock(b *tradeblocks.AccountBlock) error {
 			key,
 			head
 			) VALUES ($1, $2, $3, $4)
-				ON CONFLICT (tag, account, key) DO UPDATE SET head = $4`,
+				ON CONFLICT (tag, account, key) DO UPDATE SET head = excluded.head`,
 		AccountTag,
 		b.Account,
 		b.Token,
@@ -249,7 +367,7 @@ func (m *Transaction) InsertAccountBlock(b *tradeblocks.AccountBlock) error {
 	return m.err
 }
 
-// GetAccountBlocks gets all account blocks
+// GetAccountBlocks gets all account blocks in sequence order
 func (m *Transaction) GetAccountBlocks() ([]*tradeblocks.AccountBlock, error) {
 	rows, err := m.tx.Query(`SELECT
 		action,
@@ -260,7 +378,7 @@ func (m *Transaction) GetAccountBlocks() ([]*tradeblocks.AccountBlock, error) {
 		balance,
 		link,
 		signature
-		FROM accounts`)
+		FROM accounts JOIN blocks USING (hash) ORDER BY sequence`)
 	if err != nil {
 		return nil, err
 	}
@@ -276,6 +394,39 @@ func (m *Transaction) GetAccountBlocks() ([]*tradeblocks.AccountBlock, error) {
 	return result, rows.Err()
 }
 
+// QueryAccountBlocks gets the account blocks matching the specified filter in sequence order
+func (m *Transaction) QueryAccountBlocks(f BlockFilter) ([]tradeblocks.NetworkAccountBlock, error) {
+	clause, args := f.clause()
+	rows, err := m.tx.Query(`SELECT
+		sequence,
+		action,
+		account,
+		token,
+		previous,
+		representative,
+		balance,
+		link,
+		signature
+		FROM accounts JOIN blocks USING (hash)`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []tradeblocks.NetworkAccountBlock
+	for rows.Next() {
+		var sequence int
+		b, err := scanAccount(sequenceScanner{rows, &sequence})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tradeblocks.NetworkAccountBlock{
+			AccountBlock: b,
+			Sequence:     sequence,
+		})
+	}
+	return result, rows.Err()
+}
+
 // GetAccountBlock gets a block with the specified parameters
 func (m *Transaction) GetAccountBlock(hash string) (*tradeblocks.AccountBlock, error) {
 	row := m.tx.QueryRow(`SELECT
@@ -316,6 +467,52 @@ func (m *Transaction) GetAccountHead(account, token string) (*tradeblocks.Accoun
 	return b, err
 }
 
+// GetReceiveBlock gets the open or receive block that claims the specified send
+func (m *Transaction) GetReceiveBlock(send string) (*tradeblocks.AccountBlock, error) {
+	row := m.tx.QueryRow(`SELECT
+		action,
+		account,
+		token,
+		previous,
+		representative,
+		balance,
+		link,
+		signature
+		FROM accounts WHERE link = $1 AND action IN ('open', 'receive')`, send)
+	b, err := scanAccount(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// GetClaimBlocks gets the open and receive blocks of every account that claim link
+func (m *Transaction) GetClaimBlocks(link string) ([]*tradeblocks.AccountBlock, error) {
+	rows, err := m.tx.Query(`SELECT
+		action,
+		account,
+		token,
+		previous,
+		representative,
+		balance,
+		link,
+		signature
+		FROM accounts WHERE link = $1 AND action IN ('open', 'receive')`, link)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*tradeblocks.AccountBlock
+	for rows.Next() {
+		b, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b)
+	}
+	return result, rows.Err()
+}
+
 func scanAccount(s scanner) (*tradeblocks.AccountBlock, error) {
 	var b tradeblocks.AccountBlock
 	var previous sql.NullString
@@ -371,8 +568,8 @@ func (m *Transaction) InsertSwapBlock(b *tradeblocks.SwapBlock) error {
 		token,
 		id,
 		previous,
-		left,
-		right,
+		"left",
+		"right",
 		refund_left,
 		refund_right,
 		counterparty,
@@ -402,10 +599,7 @@ func (m *Transaction) InsertSwapBlock(b *tradeblocks.SwapBlock) error {
 	if m.err != nil {
 		return m.err
 	}
-	_, m.err = m.tx.Exec(`INSERT INTO blocks (
-			tag,
-			hash
-			) VALUES ($1, $2)`, SwapTag, hash)
+	m.err = m.insertBlock(SwapTag, hash)
 	if m.err != nil {
 		return m.err
 	}
@@ -415,7 +609,7 @@ func (m *Transaction) InsertSwapBlock(b *tradeblocks.SwapBlock) error {
 			key,
 			head
 			) VALUES ($1, $2, $3, $4)
-				ON CONFLICT (tag, account, key) DO UPDATE SET head = $4`,
+				ON CONFLICT (tag, account, key) DO UPDATE SET head = excluded.head`,
 		SwapTag,
 		b.Account,
 		b.ID,
@@ -431,8 +625,8 @@ func (m *Transaction) GetSwapBlock(hash string) (*tradeblocks.SwapBlock, error)
 		token,
 		id,
 		previous,
-		left,
-		right,
+		"left",
+		"right",
 		refund_left,
 		refund_right,
 		counterparty,
@@ -449,7 +643,7 @@ func (m *Transaction) GetSwapBlock(hash string) (*tradeblocks.SwapBlock, error)
 	return b, err
 }
 
-// GetSwapBlocks gets all swap blocks
+// GetSwapBlocks gets all swap blocks in sequence order
 func (m *Transaction) GetSwapBlocks() ([]*tradeblocks.SwapBlock, error) {
 	rows, err := m.tx.Query(`SELECT
 		action,
@@ -457,8 +651,8 @@ func (m *Transaction) GetSwapBlocks() ([]*tradeblocks.SwapBlock, error) {
 		token,
 		id,
 		previous,
-		left,
-		right,
+		"left",
+		"right",
 		refund_left,
 		refund_right,
 		counterparty,
@@ -467,7 +661,7 @@ func (m *Transaction) GetSwapBlocks() ([]*tradeblocks.SwapBlock, error) {
 		executor,
 		fee,
 		signature
-		FROM swaps`)
+		FROM swaps JOIN blocks USING (hash) ORDER BY sequence`)
 	if err != nil {
 		return nil, err
 	}
@@ -483,6 +677,46 @@ func (m *Transaction) GetSwapBlocks() ([]*tradeblocks.SwapBlock, error) {
 	return result, rows.Err()
 }
 
+// QuerySwapBlocks gets the swap blocks matching the specified filter in sequence order
+func (m *Transaction) QuerySwapBlocks(f BlockFilter) ([]tradeblocks.NetworkSwapBlock, error) {
+	clause, args := f.clause()
+	rows, err := m.tx.Query(`SELECT
+		sequence,
+		action,
+		account,
+		token,
+		id,
+		previous,
+		"left",
+		"right",
+		refund_left,
+		refund_right,
+		counterparty,
+		want,
+		quantity,
+		executor,
+		fee,
+		signature
+		FROM swaps JOIN blocks USING (hash)`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []tradeblocks.NetworkSwapBlock
+	for rows.Next() {
+		var sequence int
+		b, err := scanSwap(sequenceScanner{rows, &sequence})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tradeblocks.NetworkSwapBlock{
+			SwapBlock: b,
+			Sequence:  sequence,
+		})
+	}
+	return result, rows.Err()
+}
+
 // GetSwapHead gets the head block for the specified parameters
 func (m *Transaction) GetSwapHead(account, id string) (*tradeblocks.SwapBlock, error) {
 	row := m.tx.QueryRow(`SELECT
@@ -491,8 +725,8 @@ func (m *Transaction) GetSwapHead(account, id string) (*tradeblocks.SwapBlock, e
 		token,
 		id,
 		previous,
-		left,
-		right,
+		"left",
+		"right",
 		refund_left,
 		refund_right,
 		counterparty,
@@ -611,10 +845,7 @@ func (m *Transaction) InsertOrderBlock(b *tradeblocks.OrderBlock) error {
 	if m.err != nil {
 		return m.err
 	}
-	_, m.err = m.tx.Exec(`INSERT INTO blocks (
-			tag,
-			hash
-			) VALUES ($1, $2)`, OrderTag, hash)
+	m.err = m.insertBlock(OrderTag, hash)
 	if m.err != nil {
 		return m.err
 	}
@@ -624,7 +855,7 @@ func (m *Transaction) InsertOrderBlock(b *tradeblocks.OrderBlock) error {
 			key,
 			head
 			) VALUES ($1, $2, $3, $4)
-				ON CONFLICT (tag, account, key) DO UPDATE SET head = $4`,
+				ON CONFLICT (tag, account, key) DO UPDATE SET head = excluded.head`,
 		OrderTag,
 		b.Account,
 		b.ID,
@@ -656,7 +887,7 @@ func (m *Transaction) GetOrderBlock(hash string) (*tradeblocks.OrderBlock, error
 	return b, err
 }
 
-// GetOrderBlocks gets all order blocks
+// GetOrderBlocks gets all order blocks in sequence order
 func (m *Transaction) GetOrderBlocks() ([]*tradeblocks.OrderBlock, error) {
 	rows, err := m.tx.Query(`SELECT
 		action,
@@ -672,7 +903,7 @@ func (m *Transaction) GetOrderBlocks() ([]*tradeblocks.OrderBlock, error) {
 		executor,
 		fee,
 		signature
-		FROM orders`)
+		FROM orders JOIN blocks USING (hash) ORDER BY sequence`)
 	if err != nil {
 		return nil, err
 	}
@@ -688,6 +919,44 @@ func (m *Transaction) GetOrderBlocks() ([]*tradeblocks.OrderBlock, error) {
 	return result, rows.Err()
 }
 
+// QueryOrderBlocks gets the order blocks matching the specified filter in sequence order
+func (m *Transaction) QueryOrderBlocks(f BlockFilter) ([]tradeblocks.NetworkOrderBlock, error) {
+	clause, args := f.clause()
+	rows, err := m.tx.Query(`SELECT
+		sequence,
+		action,
+		account,
+		token,
+		id,
+		previous,
+		balance,
+		quote,
+		price,
+		link,
+		partial,
+		executor,
+		fee,
+		signature
+		FROM orders JOIN blocks USING (hash)`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []tradeblocks.NetworkOrderBlock
+	for rows.Next() {
+		var sequence int
+		b, err := scanOrder(sequenceScanner{rows, &sequence})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tradeblocks.NetworkOrderBlock{
+			OrderBlock: b,
+			Sequence:   sequence,
+		})
+	}
+	return result, rows.Err()
+}
+
 // GetOrderHead gets the head block for the specified parameters
 func (m *Transaction) GetOrderHead(account, id string) (*tradeblocks.OrderBlock, error) {
 	row := m.tx.QueryRow(`SELECT
@@ -808,10 +1077,7 @@ func (m *Transaction) InsertConfirmBlock(b *tradeblocks.ConfirmBlock) error {
 	if m.err != nil {
 		return m.err
 	}
-	_, m.err = m.tx.Exec(`INSERT INTO blocks (
-			tag,
-			hash
-			) VALUES ($1, $2)`, ConfirmTag, hash)
+	m.err = m.insertBlock(ConfirmTag, hash)
 	if m.err != nil {
 		return m.err
 	}
@@ -821,7 +1087,7 @@ func (m *Transaction) InsertConfirmBlock(b *tradeblocks.ConfirmBlock) error {
 			key,
 			head
 			) VALUES ($1, $2, $3, $4)
-				ON CONFLICT (tag, account, key) DO UPDATE SET head = $4`,
+				ON CONFLICT (tag, account, key) DO UPDATE SET head = excluded.head`,
 		ConfirmTag,
 		b.Account,
 		b.Addr,
@@ -845,7 +1111,7 @@ func (m *Transaction) GetConfirmBlock(hash string) (*tradeblocks.ConfirmBlock, e
 	return b, err
 }
 
-// GetConfirmBlocks gets all confirm blocks
+// GetConfirmBlocks gets all confirm blocks in sequence order
 func (m *Transaction) GetConfirmBlocks() ([]*tradeblocks.ConfirmBlock, error) {
 	rows, err := m.tx.Query(`SELECT
 		previous,
@@ -853,7 +1119,7 @@ func (m *Transaction) GetConfirmBlocks() ([]*tradeblocks.ConfirmBlock, error) {
 		head,
 		account,
 		signature
-		FROM confirms`)
+		FROM confirms JOIN blocks USING (hash) ORDER BY sequence`)
 	if err != nil {
 		return nil, err
 	}
@@ -917,29 +1183,81 @@ func (m *Transaction) GetBlock(hash string) (tag int, block tradeblocks.Block, e
 	return
 }
 
-// GetBlocks returns all blocks
+// GetBlocks returns all blocks in sequence order
 func (m *Transaction) GetBlocks() ([]tradeblocks.Block, error) {
 	// TODO Don't do m*n query
-	fmt.Println("db: GetBlocks is currently an expensive call")
-	rows, err := m.tx.Query(`SELECT tag, hash FROM blocks`)
+	m.log.Debug("GetBlocks is currently an expensive call")
+	blocks, err := m.QueryBlocks(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]tradeblocks.Block, 0, len(blocks))
+	for _, b := range blocks {
+		result = append(result, b.Block)
+	}
+	return result, nil
+}
+
+// QueryBlocks returns up to limit blocks of every type with a sequence greater than since, in sequence order.
+// A limit of zero returns all blocks.
+func (m *Transaction) QueryBlocks(since, limit int) ([]tradeblocks.NetworkBlock, error) {
+	q := `SELECT sequence, tag, hash, added FROM blocks WHERE sequence > $1 ORDER BY sequence`
+	args := []interface{}{since}
+	if limit > 0 {
+		q += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	rows, err := m.tx.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var result []tradeblocks.Block
+	type entry struct {
+		sequence int
+		tag      int
+		hash     string
+		added    time.Time
+	}
+	var entries []entry
 	for rows.Next() {
-		var tag int
-		var hash string
-		if err := rows.Scan(&tag, &hash); err != nil {
+		var e entry
+		var added sql.NullString
+		if err := rows.Scan(&e.sequence, &e.tag, &e.hash, &added); err != nil {
 			return nil, err
 		}
-		b, err := m.getBlock(tag, hash)
+		if added.Valid {
+			if e.added, err = time.Parse(time.RFC3339Nano, added.String); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]tradeblocks.NetworkBlock, 0, len(entries))
+	for _, e := range entries {
+		b, err := m.getBlock(e.tag, e.hash)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, b)
+		result = append(result, tradeblocks.NetworkBlock{
+			Type:     TagType(e.tag),
+			Sequence: e.sequence,
+			Time:     e.added,
+			Block:    b,
+		})
+	}
+	return result, nil
+}
+
+// LastSequence returns the sequence of the most recently inserted block or zero if there are no blocks
+func (m *Transaction) LastSequence() (int, error) {
+	var sequence sql.NullInt64
+	if err := m.tx.QueryRow(`SELECT MAX(sequence) FROM blocks`).Scan(&sequence); err != nil {
+		return 0, err
 	}
-	return result, rows.Err()
+	return int(sequence.Int64), nil
 }
 
 func (m *Transaction) getBlock(tag int, hash string) (tradeblocks.Block, error) {
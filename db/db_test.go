@@ -1,12 +1,21 @@
 package db
 
 import (
+	"database/sql"
+	"fmt"
 	"github.com/jephir/tradeblocks"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
+// postgresTestDataSource is the PostgreSQL URL to run the store tests against, for example
+// postgres://localhost/tradeblocks_test?sslmode=disable. The PostgreSQL tests are skipped if it's empty.
+var postgresTestDataSource = os.Getenv("TRADEBLOCKS_TEST_POSTGRES")
+
 func TestInit(t *testing.T) {
 	f, err := ioutil.TempFile("", "tradeblocks")
 	if err != nil {
@@ -24,190 +33,620 @@ func TestInit(t *testing.T) {
 		t.Fatal(err)
 	}
 }
-func TestInsertAccountBlock(t *testing.T) {
-	f, err := ioutil.TempFile("", "tradeblocks")
+
+// testStores runs fn in a transaction on each store backend
+func testStores(t *testing.T, fn func(t *testing.T, tx Tx)) {
+	newStores := map[string]func(t *testing.T) (Store, func()){
+		"sqlite": func(t *testing.T) (Store, func()) {
+			f, err := ioutil.TempFile("", "tradeblocks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewDB(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return d, func() {
+				d.Close()
+				os.Remove(f.Name())
+			}
+		},
+		"postgres": newPostgresTestStore,
+		"memory": func(t *testing.T) (Store, func()) {
+			return NewMemoryStore(), func() {}
+		},
+	}
+	for name, newStore := range newStores {
+		t.Run(name, func(t *testing.T) {
+			s, cleanup := newStore(t)
+			defer cleanup()
+
+			tx, err := s.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}()
+			fn(t, tx)
+		})
+	}
+}
+
+// newPostgresTestStore connects to the test database with a fresh schema that is dropped on cleanup
+func newPostgresTestStore(t *testing.T) (Store, func()) {
+	if postgresTestDataSource == "" {
+		t.Skip("TRADEBLOCKS_TEST_POSTGRES is not set")
+	}
+	conn, err := sql.Open("postgres", postgresTestDataSource)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(f.Name())
+	schema := fmt.Sprintf("tradeblocks_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec("CREATE SCHEMA " + schema); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		conn.Exec("DROP SCHEMA " + schema + " CASCADE")
+		conn.Close()
+	}
+	u, err := url.Parse(postgresTestDataSource)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	d, err := NewDB(u.String())
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return d, func() {
+		d.Close()
+		cleanup()
+	}
+}
 
-	dataSourceName := f.Name()
-	d, err := NewDB(dataSourceName)
+func TestMemoryStoreRollback(t *testing.T) {
+	s := NewMemoryStore()
+	issue := tradeblocks.NewIssueBlock("xtb:test", 100)
+	issue.Signature = "issue"
+	send := tradeblocks.NewSendBlock(issue, "xtb:other", 50)
+	send.Signature = "send"
+
+	tx, err := s.NewTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer d.Close()
+	if err := tx.InsertAccountBlock(issue); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.InsertAccountBlock(issue); err == nil {
+		t.Fatal("expected duplicate block error")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected commit to return the insert error")
+	}
 
-	db, err := d.NewTransaction()
+	tx, err = s.NewTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := db.Commit(); err != nil {
-			t.Fatal(err)
-		}
-	}()
+	defer tx.Commit()
+	if _, err := tx.GetAccountHead(issue.Account, issue.Token); err != ErrNotFound {
+		t.Fatalf("expected rolled back head, got %v", err)
+	}
+	if err := tx.InsertAccountBlock(send); err == nil {
+		t.Fatal("expected missing previous error")
+	}
+	if last, _ := tx.LastSequence(); last != 0 {
+		t.Fatalf("expected empty store, got sequence %d", last)
+	}
+}
 
-	b := tradeblocks.NewIssueBlock("xtb:test", 500)
-	if err := db.InsertAccountBlock(b); err != nil {
+func TestMemoryStoreSnapshot(t *testing.T) {
+	s := NewMemoryStore()
+	issue := tradeblocks.NewIssueBlock("xtb:test", 100)
+	issue.Signature = "issue"
+	send := tradeblocks.NewSendBlock(issue, "xtb:other", 50)
+	send.Signature = "send"
+
+	tx, err := s.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.InsertAccountBlock(issue); err != nil {
 		t.Fatal(err)
 	}
 
-	check, err := db.GetAccountBlock(b.Hash())
+	// A reader doesn't wait for the open write transaction and doesn't see its changes
+	r, err := s.NewReadTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := b.Equals(check); err != nil {
+	if _, err := r.GetAccountBlock(issue.Hash()); err != ErrNotFound {
+		t.Fatalf("expected uncommitted block to be invisible, got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
 		t.Fatal(err)
 	}
+	if _, err := r.GetAccountHead(issue.Account, issue.Token); err != ErrNotFound {
+		t.Fatalf("expected the reader to keep its snapshot, got %v", err)
+	}
+	r.Commit()
 
-	head, err := db.GetAccountHead(b.Account, b.Token)
+	// A reader sees the head that was committed when it started
+	r, err = s.NewReadTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := b.Equals(head); err != nil {
+	defer r.Commit()
+	tx, err = s.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.InsertAccountBlock(send); err != nil {
 		t.Fatal(err)
 	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.GetAccountHead(issue.Account, issue.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != issue.Hash() {
+		t.Fatalf("expected head %s, got %s", issue.Hash(), head.Hash())
+	}
+	if last, _ := r.LastSequence(); last != 1 {
+		t.Fatalf("expected sequence 1, got %d", last)
+	}
+}
+
+func TestInsertAccountBlock(t *testing.T) {
+	testStores(t, func(t *testing.T, db Tx) {
+		b := tradeblocks.NewIssueBlock("xtb:test", 500)
+		if err := db.InsertAccountBlock(b); err != nil {
+			t.Fatal(err)
+		}
+
+		check, err := db.GetAccountBlock(b.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Equals(check); err != nil {
+			t.Fatal(err)
+		}
+
+		head, err := db.GetAccountHead(b.Account, b.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Equals(head); err != nil {
+			t.Fatal(err)
+		}
+	})
 }
 
 func TestInsertSwapBlock(t *testing.T) {
+	testStores(t, func(t *testing.T, db Tx) {
+		issue := tradeblocks.NewIssueBlock("xtb:issuer", 100)
+		send := tradeblocks.NewSendBlock(issue, "xtb:test", 100)
+		b := tradeblocks.NewOfferBlock("xtb:test", send, "test", "xtb:counterparty", "xtb:want", 100, "", 0)
+		if err := db.InsertSwapBlock(b); err != nil {
+			t.Fatal(err)
+		}
+
+		check, err := db.GetSwapBlock(b.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != check.Hash() {
+			t.Fatalf("block not found")
+		}
+
+		head, err := db.GetSwapHead(b.Account, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != head.Hash() {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestInsertOrderBlock(t *testing.T) {
+	testStores(t, func(t *testing.T, db Tx) {
+		issue := tradeblocks.NewIssueBlock("xtb:issuer", 100)
+		send := tradeblocks.NewSendBlock(issue, "xtb:test", 100)
+		b := tradeblocks.NewCreateOrderBlock("xtb:test", send, 100, "test", false, "xtb:quote", 12.5, "", 0)
+		if err := db.InsertOrderBlock(b); err != nil {
+			t.Fatal(err)
+		}
+
+		check, err := db.GetOrderBlock(b.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != check.Hash() {
+			t.Fatalf("block not found")
+		}
+
+		head, err := db.GetOrderHead(b.Account, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != head.Hash() {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestInsertConfirmBlock(t *testing.T) {
+	testStores(t, func(t *testing.T, db Tx) {
+		b := tradeblocks.NewConfirmBlock(nil, "xtb:test", "xtb:addr", "123abc")
+		if err := db.InsertConfirmBlock(b); err != nil {
+			t.Fatal(err)
+		}
+
+		check, err := db.GetConfirmBlock(b.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != check.Hash() {
+			t.Fatalf("block not found")
+		}
+
+		head, err := db.GetConfirmHead(b.Account, b.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Hash() != head.Hash() {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestQueryAccountBlocks(t *testing.T) {
+	testStores(t, func(t *testing.T, db Tx) {
+		issue1 := tradeblocks.NewIssueBlock("xtb:test1", 100)
+		issue2 := tradeblocks.NewIssueBlock("xtb:test2", 100)
+		send := tradeblocks.NewSendBlock(issue1, "xtb:test2", 50)
+		for i, b := range []*tradeblocks.AccountBlock{issue1, issue2, send} {
+			b.Signature = fmt.Sprintf("signature%d", i)
+			if err := db.InsertAccountBlock(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all, err := db.QueryAccountBlocks(BlockFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 {
+			t.Fatalf("expected 3 blocks, got %d", len(all))
+		}
+		if all[0].Hash() != issue1.Hash() || all[2].Hash() != send.Hash() {
+			t.Fatal("blocks not in sequence order")
+		}
+
+		page, err := db.QueryAccountBlocks(BlockFilter{Since: all[0].Sequence, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].Hash() != issue2.Hash() {
+			t.Fatalf("expected block %s after sequence %d", issue2.Hash(), all[0].Sequence)
+		}
+
+		filtered, err := db.QueryAccountBlocks(BlockFilter{Account: "xtb:test1", Token: "xtb:test1", Action: "send"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(filtered) != 1 || filtered[0].Hash() != send.Hash() {
+			t.Fatalf("expected only send block %s", send.Hash())
+		}
+	})
+}
+
+func TestSequence(t *testing.T) {
+	testStores(t, func(t *testing.T, db Tx) {
+		issue := tradeblocks.NewIssueBlock("xtb:issuer", 100)
+		issue.Signature = "issue"
+		send := tradeblocks.NewSendBlock(issue, "xtb:test:swap:test", 100)
+		send.Signature = "send"
+		offer := tradeblocks.NewOfferBlock("xtb:test", send, "test", "xtb:counterparty", "xtb:want", 100, "", 0)
+		offer.Signature = "offer"
+		confirm := tradeblocks.NewConfirmBlock(nil, "xtb:test", "xtb:addr", offer.Hash())
+		confirm.Signature = "confirm"
+		if err := db.InsertAccountBlock(issue); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertSwapBlock(offer); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertAccountBlock(send); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertConfirmBlock(confirm); err != nil {
+			t.Fatal(err)
+		}
+
+		blocks, err := db.QueryBlocks(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := []tradeblocks.Block{issue, offer, send, confirm}
+		if len(blocks) != len(expect) {
+			t.Fatalf("expected %d blocks, got %d", len(expect), len(blocks))
+		}
+		for i, b := range expect {
+			if blocks[i].Block.Hash() != b.Hash() {
+				t.Fatalf("block %d: expected %s, got %s", i, b.Hash(), blocks[i].Block.Hash())
+			}
+			if i > 0 && blocks[i].Sequence <= blocks[i-1].Sequence {
+				t.Fatalf("block %d: sequence %d is not after %d", i, blocks[i].Sequence, blocks[i-1].Sequence)
+			}
+			if blocks[i].Time.IsZero() {
+				t.Fatalf("block %d: expected the time it was added", i)
+			}
+		}
+
+		accounts, err := db.QueryAccountBlocks(BlockFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 2 || accounts[1].Sequence != blocks[2].Sequence {
+			t.Fatal("account block sequences don't match global sequences")
+		}
+
+		last, err := db.LastSequence()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last != blocks[3].Sequence {
+			t.Fatalf("expected last sequence %d, got %d", blocks[3].Sequence, last)
+		}
+
+		page, err := db.QueryBlocks(blocks[1].Sequence, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].Block.Hash() != send.Hash() {
+			t.Fatalf("expected send %s after sequence %d", send.Hash(), blocks[1].Sequence)
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
 	f, err := ioutil.TempFile("", "tradeblocks")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.Remove(f.Name())
 
-	dataSourceName := f.Name()
-	d, err := NewDB(dataSourceName)
+	d, err := Open(f.Name())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer d.Close()
 
-	db, err := d.NewTransaction()
+	pending, err := d.PendingMigrations()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := db.Commit(); err != nil {
-			t.Fatal(err)
+	if len(pending) != len(migrations) {
+		t.Fatalf("expected %d pending migrations, got %d", len(migrations), len(pending))
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Fatalf("migration %d is not after %d", migrations[i].Version, migrations[i-1].Version)
 		}
-	}()
-
-	issue := tradeblocks.NewIssueBlock("xtb:issuer", 100)
-	send := tradeblocks.NewSendBlock(issue, "xtb:test", 100)
-	b := tradeblocks.NewOfferBlock("xtb:test", send, "test", "xtb:counterparty", "xtb:want", 100, "", 0)
-	if err := db.InsertSwapBlock(b); err != nil {
-		t.Fatal(err)
+	}
+	if version, err := d.SchemaVersion(); err != nil || version != 0 {
+		t.Fatalf("expected version 0 before migrating, got %d (%v)", version, err)
 	}
 
-	check, err := db.GetSwapBlock(b.Hash())
+	applied, err := d.Migrate()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if b.Hash() != check.Hash() {
-		t.Fatalf("block not found")
+	if len(applied) != len(migrations) {
+		t.Fatalf("expected %d applied migrations, got %d", len(migrations), len(applied))
+	}
+	last := migrations[len(migrations)-1].Version
+	if version, err := d.SchemaVersion(); err != nil || version != last {
+		t.Fatalf("expected version %d, got %d (%v)", last, version, err)
 	}
 
-	head, err := db.GetSwapHead(b.Account, b.ID)
+	applied, err = d.Migrate()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if b.Hash() != head.Hash() {
-		t.Fatal(err)
+	if len(applied) != 0 {
+		t.Fatalf("expected no migrations on second run, got %d", len(applied))
 	}
 }
 
-func TestInsertOrderBlock(t *testing.T) {
+func TestMigrateLegacy(t *testing.T) {
 	f, err := ioutil.TempFile("", "tradeblocks")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.Remove(f.Name())
 
-	dataSourceName := f.Name()
-	d, err := NewDB(dataSourceName)
+	fixture, err := ioutil.ReadFile("testdata/baseline.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(string(fixture)); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDB(SqliteDataSource(f.Name()))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer d.Close()
+	tx, err := d.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
 
-	db, err := d.NewTransaction()
+	// Blocks keep the order they were added in, not the order of their hashes
+	blocks, err := tx.QueryBlocks(0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := db.Commit(); err != nil {
-			t.Fatal(err)
+	expect := []string{
+		"I5TTDDX5ZOYGUDJZUMBDA225HMELJUCS6P4RAWHMFMD4UCTEUABA",
+		"RNOVD4DMJFKHFZBQLRR45FGWJVKVW2S4K2B2FMAITBCIXH64U4IQ",
+		"C6XCZZODBZ5VH2DYWKXTUDREHTRBFEES44GGI7BBI22PMYPEZSDA",
+	}
+	if len(blocks) != len(expect) {
+		t.Fatalf("expected %d blocks, got %d", len(expect), len(blocks))
+	}
+	for i, b := range blocks {
+		if b.Sequence != i+1 || b.Block.Hash() != expect[i] {
+			t.Fatalf("expected %s at sequence %d, got %s at %d", expect[i], i+1, b.Block.Hash(), b.Sequence)
 		}
-	}()
+	}
 
-	issue := tradeblocks.NewIssueBlock("xtb:issuer", 100)
-	send := tradeblocks.NewSendBlock(issue, "xtb:test", 100)
-	b := tradeblocks.NewCreateOrderBlock("xtb:test", send, 100, "test", false, "xtb:quote", 12.5, "", 0)
-	if err := db.InsertOrderBlock(b); err != nil {
+	// New blocks continue the sequence and hashes stay unique
+	if err := tx.InsertAccountBlock(tradeblocks.NewIssueBlock("xtb:erin", 50)); err != nil {
 		t.Fatal(err)
 	}
+	if last, err := tx.LastSequence(); err != nil || last != len(expect)+1 {
+		t.Fatalf("expected last sequence %d, got %d (%v)", len(expect)+1, last, err)
+	}
+	if err := tx.(*Transaction).insertBlock(AccountTag, expect[0]); err == nil {
+		t.Fatal("expected duplicate hash to be rejected")
+	}
+}
+
+func TestClaimIndex(t *testing.T) {
+	d, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
 
-	check, err := db.GetOrderBlock(b.Hash())
+	rows, err := d.db.Query(`EXPLAIN QUERY PLAN SELECT hash FROM accounts
+		WHERE link = 'link' AND action IN ('open', 'receive')`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if b.Hash() != check.Hash() {
-		t.Fatalf("block not found")
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
 	}
+	if p := strings.Join(plan, "; "); !strings.Contains(p, "accounts_link") {
+		t.Fatalf("expected claim lookup to use the link index, got plan %q", p)
+	}
+}
 
-	head, err := db.GetOrderHead(b.Account, b.ID)
+func TestPostgresReadSnapshot(t *testing.T) {
+	s, cleanup := newPostgresTestStore(t)
+	defer cleanup()
+
+	// A reader keeps the snapshot of its first statement
+	r, err := s.NewReadTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if b.Hash() != head.Hash() {
+	defer r.Commit()
+	if last, err := r.LastSequence(); err != nil || last != 0 {
+		t.Fatalf("expected empty store, got sequence %d: %v", last, err)
+	}
+	tx, err := s.NewTransaction()
+	if err != nil {
 		t.Fatal(err)
 	}
+	if err := tx.InsertAccountBlock(tradeblocks.NewIssueBlock("xtb:test", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := r.LastSequence(); err != nil || last != 0 {
+		t.Fatalf("expected the reader to keep its snapshot, got sequence %d: %v", last, err)
+	}
 }
 
-func TestInsertConfirmBlock(t *testing.T) {
+func TestReadTransaction(t *testing.T) {
 	f, err := ioutil.TempFile("", "tradeblocks")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + "-wal")
+	defer os.Remove(f.Name() + "-shm")
 
-	dataSourceName := f.Name()
-	d, err := NewDB(dataSourceName)
+	d, err := NewDB(f.Name())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer d.Close()
 
-	db, err := d.NewTransaction()
-	if err != nil {
+	var mode string
+	if err := d.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := db.Commit(); err != nil {
-			t.Fatal(err)
-		}
-	}()
+	if mode != "wal" {
+		t.Fatalf("expected wal journal mode, got %s", mode)
+	}
 
-	b := tradeblocks.NewConfirmBlock(nil, "xtb:test", "xtb:addr", "123abc")
-	if err := db.InsertConfirmBlock(b); err != nil {
+	b := tradeblocks.NewIssueBlock("xtb:test", 100)
+	tx, err := d.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.InsertAccountBlock(b); err != nil {
 		t.Fatal(err)
 	}
 
-	check, err := db.GetConfirmBlock(b.Hash())
+	// A reader doesn't wait for the open write transaction and doesn't see its changes
+	r, err := d.NewReadTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if b.Hash() != check.Hash() {
-		t.Fatalf("block not found")
+	if _, err := r.GetAccountBlock(b.Hash()); err != ErrNotFound {
+		t.Fatalf("expected uncommitted block to be invisible, got %v", err)
+	}
+	if err := r.Commit(); err != nil {
+		t.Fatal(err)
 	}
 
-	head, err := db.GetConfirmHead(b.Account, b.Addr)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = d.NewReadTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if b.Hash() != head.Hash() {
+	defer r.Commit()
+	if _, err := r.GetAccountBlock(b.Hash()); err != nil {
 		t.Fatal(err)
 	}
+	if err := r.(*Transaction).InsertAccountBlock(tradeblocks.NewIssueBlock("xtb:other", 100)); err == nil {
+		t.Fatal("expected insert in read transaction to fail")
+	}
 }
@@ -0,0 +1,559 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+)
+
+// MemoryStore is a pure-Go store that keeps all blocks in memory. It enforces the same constraints as the
+// sqlite schema. Write transactions are serialized. Read transactions see the blocks that were committed when they
+// started and don't wait for each other or for the writer.
+type MemoryStore struct {
+	// writer is held by the open write transaction
+	writer sync.Mutex
+
+	// mu guards the fields below while they're read or changed, but isn't held by transactions
+	mu sync.RWMutex
+
+	// blocks is in sequence order so the sequence of blocks[i] is i+1. Blocks are only appended, and removed by a
+	// rollback, so a read transaction keeps a slice of the committed blocks.
+	blocks    []memoryBlock
+	committed int
+	index     map[string]int
+
+	// heads has the index of every block that was the head of a chain in order, so a read transaction finds the
+	// head among its blocks
+	heads map[memoryHead][]int
+
+	// unique holds the values of unique columns other than hash
+	unique map[string]bool
+}
+
+type memoryBlock struct {
+	tag   int
+	block tradeblocks.Block
+	added time.Time
+}
+
+type memoryHead struct {
+	tag     int
+	account string
+	key     string
+}
+
+// NewMemoryStore allocates and returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		index:  make(map[string]int),
+		heads:  make(map[memoryHead][]int),
+		unique: make(map[string]bool),
+	}
+}
+
+// Close releases all resources used by this store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// NewTransaction initializes a new write transaction. It blocks until the open write transaction is committed and
+// must be finished with a call to Commit().
+func (s *MemoryStore) NewTransaction() (Tx, error) {
+	s.writer.Lock()
+	return &memoryTx{
+		s:     s,
+		start: time.Now(),
+	}, nil
+}
+
+// NewReadTransaction initializes a new read-only transaction of the committed blocks. It must be finished with a
+// call to Commit().
+func (s *MemoryStore) NewReadTransaction() (ReadTx, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &memoryTx{
+		s:        s,
+		readOnly: true,
+		blocks:   s.blocks[:s.committed:s.committed],
+		start:    time.Now(),
+	}, nil
+}
+
+type memoryTx struct {
+	s        *MemoryStore
+	readOnly bool
+	blocks   []memoryBlock // blocks of a read transaction
+	undo     []func()
+	err      error
+	closed   bool
+	start    time.Time
+}
+
+// view returns the blocks that the transaction sees. A write transaction sees its own inserts.
+func (m *memoryTx) view() []memoryBlock {
+	if m.readOnly {
+		return m.blocks
+	}
+	return m.s.blocks
+}
+
+// lookup returns the index of the block with the specified hash if the transaction sees it
+func (m *memoryTx) lookup(hash string) (int, bool) {
+	if m.readOnly {
+		m.s.mu.RLock()
+		defer m.s.mu.RUnlock()
+	}
+	i, ok := m.s.index[hash]
+	return i, ok && i < len(m.view())
+}
+
+// headIndex returns the index of the head of a chain among the blocks that the transaction sees
+func (m *memoryTx) headIndex(h memoryHead) (int, bool) {
+	if m.readOnly {
+		m.s.mu.RLock()
+		defer m.s.mu.RUnlock()
+	}
+	heads := m.s.heads[h]
+	for i := len(heads) - 1; i >= 0; i-- {
+		if heads[i] < len(m.view()) {
+			return heads[i], true
+		}
+	}
+	return 0, false
+}
+
+// Commit commits the transaction or undoes its inserts if one of them failed
+func (m *memoryTx) Commit() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	defer observeTx(m.start, m.readOnly, m.err)
+	if m.readOnly {
+		return nil
+	}
+	defer m.s.writer.Unlock()
+	if m.err != nil {
+		m.rollback()
+		return m.err
+	}
+	m.s.mu.Lock()
+	m.s.committed = len(m.s.blocks)
+	m.s.mu.Unlock()
+	return nil
+}
+
+// Rollback undoes every insert of the transaction
+func (m *memoryTx) Rollback() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	defer m.s.writer.Unlock()
+	defer observeTx(m.start, m.readOnly, errRollback)
+	m.rollback()
+	return nil
+}
+
+func (m *memoryTx) rollback() {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	for i := len(m.undo) - 1; i >= 0; i-- {
+		m.undo[i]()
+	}
+}
+
+func check(ok bool, table, column string) error {
+	if !ok {
+		return fmt.Errorf("db: check constraint failed: %s.%s", table, column)
+	}
+	return nil
+}
+
+func isAddress(s string) bool {
+	return strings.HasPrefix(s, "xtb:")
+}
+
+func isAddressOrEmpty(s string) bool {
+	return s == "" || isAddress(s)
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert adds a copy of b to the store and updates its head. A non-empty previous must be the hash of a block in
+// the same table and is unique if uniquePrevious is set.
+func (m *memoryTx) insert(tag int, table string, b tradeblocks.Block, account, key, previous string, uniquePrevious bool, signature string) error {
+	s := m.s
+	hash := b.Hash()
+	if _, ok := s.index[hash]; ok {
+		return fmt.Errorf("db: unique constraint failed: %s.hash", table)
+	}
+	uniques := []string{fmt.Sprintf("%s.signature:%s", table, signature)}
+	if previous != "" {
+		if i, ok := s.index[previous]; !ok || s.blocks[i].tag != tag {
+			return fmt.Errorf("db: foreign key constraint failed: %s.previous", table)
+		}
+		if uniquePrevious {
+			uniques = append(uniques, fmt.Sprintf("%s.previous:%s", table, previous))
+		}
+	}
+	for _, u := range uniques {
+		if s.unique[u] {
+			return fmt.Errorf("db: unique constraint failed: %s", u[:strings.Index(u, ":")])
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range uniques {
+		s.unique[u] = true
+	}
+	s.index[hash] = len(s.blocks)
+	h := memoryHead{tag, account, key}
+	s.heads[h] = append(s.heads[h], len(s.blocks))
+	s.blocks = append(s.blocks, memoryBlock{
+		tag:   tag,
+		block: copyBlock(b),
+		added: time.Now().UTC(),
+	})
+
+	m.undo = append(m.undo, func() {
+		for _, u := range uniques {
+			delete(s.unique, u)
+		}
+		delete(s.index, hash)
+		s.blocks = s.blocks[:len(s.blocks)-1]
+		if heads := s.heads[h]; len(heads) > 1 {
+			s.heads[h] = heads[:len(heads)-1]
+		} else {
+			delete(s.heads, h)
+		}
+	})
+	return nil
+}
+
+func copyBlock(b tradeblocks.Block) tradeblocks.Block {
+	switch b := b.(type) {
+	case *tradeblocks.AccountBlock:
+		c := *b
+		return &c
+	case *tradeblocks.SwapBlock:
+		c := *b
+		return &c
+	case *tradeblocks.OrderBlock:
+		c := *b
+		return &c
+	case *tradeblocks.ConfirmBlock:
+		c := *b
+		return &c
+	}
+	return b
+}
+
+// InsertAccountBlock inserts the specified block into the store
+func (m *memoryTx) InsertAccountBlock(b *tradeblocks.AccountBlock) error {
+	m.err = firstError(
+		check(b.Action == "open" || b.Action == "issue" || b.Action == "send" || b.Action == "receive", "accounts", "action"),
+		check(isAddress(b.Account), "accounts", "account"),
+		check(isAddress(b.Token), "accounts", "token"),
+		check(isAddress(b.Representative), "accounts", "representative"),
+		check(b.Balance >= 0, "accounts", "balance"))
+	if m.err != nil {
+		return m.err
+	}
+	m.err = m.insert(AccountTag, "accounts", b, b.Account, b.Token, b.Previous, true, b.Signature)
+	return m.err
+}
+
+// InsertSwapBlock inserts the specified block into the store
+func (m *memoryTx) InsertSwapBlock(b *tradeblocks.SwapBlock) error {
+	m.err = firstError(
+		check(b.Action == "offer" || b.Action == "commit" || b.Action == "refund-left" || b.Action == "refund-right", "swaps", "action"),
+		check(isAddress(b.Account), "swaps", "account"),
+		check(isAddress(b.Token), "swaps", "token"),
+		check(isAddressOrEmpty(b.RefundLeft), "swaps", "refund_left"),
+		check(isAddressOrEmpty(b.RefundRight), "swaps", "refund_right"),
+		check(isAddress(b.Counterparty), "swaps", "counterparty"),
+		check(isAddress(b.Want), "swaps", "want"),
+		check(b.Quantity >= 0, "swaps", "quantity"),
+		check(isAddressOrEmpty(b.Executor), "swaps", "executor"))
+	if m.err != nil {
+		return m.err
+	}
+	m.err = m.insert(SwapTag, "swaps", b, b.Account, b.ID, b.Previous, true, b.Signature)
+	return m.err
+}
+
+// InsertOrderBlock inserts the specified block into the store
+func (m *memoryTx) InsertOrderBlock(b *tradeblocks.OrderBlock) error {
+	m.err = firstError(
+		check(b.Action == "create-order" || b.Action == "accept-order" || b.Action == "refund-order", "orders", "action"),
+		check(isAddress(b.Account), "orders", "account"),
+		check(isAddress(b.Token), "orders", "token"),
+		check(b.Balance >= 0, "orders", "balance"),
+		check(b.Price >= 0, "orders", "price"),
+		check(isAddressOrEmpty(b.Executor), "orders", "executor"))
+	if m.err != nil {
+		return m.err
+	}
+	m.err = m.insert(OrderTag, "orders", b, b.Account, b.ID, b.Previous, false, b.Signature)
+	return m.err
+}
+
+// InsertConfirmBlock inserts the specified block into the store
+func (m *memoryTx) InsertConfirmBlock(b *tradeblocks.ConfirmBlock) error {
+	m.err = firstError(
+		check(isAddress(b.Addr), "confirms", "addr"),
+		check(isAddress(b.Account), "confirms", "account"))
+	if m.err != nil {
+		return m.err
+	}
+	m.err = m.insert(ConfirmTag, "confirms", b, b.Account, b.Addr, b.Previous, false, b.Signature)
+	return m.err
+}
+
+// get returns a copy of the block with the specified hash and tag
+func (m *memoryTx) get(tag int, hash string) (tradeblocks.Block, error) {
+	i, ok := m.lookup(hash)
+	if !ok || m.view()[i].tag != tag {
+		return nil, ErrNotFound
+	}
+	return copyBlock(m.view()[i].block), nil
+}
+
+func (m *memoryTx) head(tag int, account, key string) (tradeblocks.Block, error) {
+	i, ok := m.headIndex(memoryHead{tag, account, key})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBlock(m.view()[i].block), nil
+}
+
+// GetBlock returns a block by hash
+func (m *memoryTx) GetBlock(hash string) (int, tradeblocks.Block, error) {
+	i, ok := m.lookup(hash)
+	if !ok {
+		return 0, nil, ErrNotFound
+	}
+	b := m.view()[i]
+	return b.tag, copyBlock(b.block), nil
+}
+
+// GetAccountBlock gets a block with the specified parameters
+func (m *memoryTx) GetAccountBlock(hash string) (*tradeblocks.AccountBlock, error) {
+	b, err := m.get(AccountTag, hash)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.AccountBlock), nil
+}
+
+// GetSwapBlock gets a block with the specified parameters
+func (m *memoryTx) GetSwapBlock(hash string) (*tradeblocks.SwapBlock, error) {
+	b, err := m.get(SwapTag, hash)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.SwapBlock), nil
+}
+
+// GetOrderBlock gets a block with the specified parameters
+func (m *memoryTx) GetOrderBlock(hash string) (*tradeblocks.OrderBlock, error) {
+	b, err := m.get(OrderTag, hash)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.OrderBlock), nil
+}
+
+// GetConfirmBlock gets a block with the specified parameters
+func (m *memoryTx) GetConfirmBlock(hash string) (*tradeblocks.ConfirmBlock, error) {
+	b, err := m.get(ConfirmTag, hash)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.ConfirmBlock), nil
+}
+
+// GetAccountHead gets the head block for the specified parameters
+func (m *memoryTx) GetAccountHead(account, token string) (*tradeblocks.AccountBlock, error) {
+	b, err := m.head(AccountTag, account, token)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.AccountBlock), nil
+}
+
+// GetSwapHead gets the head block for the specified parameters
+func (m *memoryTx) GetSwapHead(account, id string) (*tradeblocks.SwapBlock, error) {
+	b, err := m.head(SwapTag, account, id)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.SwapBlock), nil
+}
+
+// GetOrderHead gets the head block for the specified parameters
+func (m *memoryTx) GetOrderHead(account, id string) (*tradeblocks.OrderBlock, error) {
+	b, err := m.head(OrderTag, account, id)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.OrderBlock), nil
+}
+
+// GetConfirmHead gets the head block for the specified parameters
+func (m *memoryTx) GetConfirmHead(account, addr string) (*tradeblocks.ConfirmBlock, error) {
+	b, err := m.head(ConfirmTag, account, addr)
+	if err != nil {
+		return nil, err
+	}
+	return b.(*tradeblocks.ConfirmBlock), nil
+}
+
+// GetReceiveBlock gets the open or receive block that claims the specified send
+func (m *memoryTx) GetReceiveBlock(send string) (*tradeblocks.AccountBlock, error) {
+	for _, b := range m.view() {
+		if a, ok := b.block.(*tradeblocks.AccountBlock); ok && a.Link == send && (a.Action == "open" || a.Action == "receive") {
+			c := *a
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetClaimBlocks gets the open and receive blocks of every account that claim link
+func (m *memoryTx) GetClaimBlocks(link string) ([]*tradeblocks.AccountBlock, error) {
+	var result []*tradeblocks.AccountBlock
+	for _, b := range m.view() {
+		if a, ok := b.block.(*tradeblocks.AccountBlock); ok && a.Link == link && (a.Action == "open" || a.Action == "receive") {
+			c := *a
+			result = append(result, &c)
+		}
+	}
+	return result, nil
+}
+
+// GetLimitOrders returns orders with the specified parameters
+func (m *memoryTx) GetLimitOrders(base, condition string, ppu float64, quote string) ([]*tradeblocks.OrderBlock, error) {
+	if condition != ">=" && condition != "<=" {
+		return nil, fmt.Errorf("db: condition must be >= or <=")
+	}
+	var result []*tradeblocks.OrderBlock
+	for _, b := range m.view() {
+		o, ok := b.block.(*tradeblocks.OrderBlock)
+		if !ok || o.Token != base || o.Quote != quote {
+			continue
+		}
+		if (condition == ">=" && o.Price >= ppu) || (condition == "<=" && o.Price <= ppu) {
+			c := *o
+			result = append(result, &c)
+		}
+	}
+	return result, nil
+}
+
+// query calls fn with up to limit blocks that have the specified tag and a sequence greater than since.
+// A tag of -1 matches every block.
+func (m *memoryTx) query(tag, since, limit int, fn func(sequence int, b tradeblocks.Block) bool) {
+	if since < 0 {
+		since = 0
+	}
+	blocks := m.view()
+	n := 0
+	for i := since; i < len(blocks) && (limit <= 0 || n < limit); i++ {
+		b := blocks[i]
+		if (tag == -1 || b.tag == tag) && fn(i+1, b.block) {
+			n++
+		}
+	}
+}
+
+func (f BlockFilter) match(account, token, action string) bool {
+	return (f.Account == "" || f.Account == account) &&
+		(f.Token == "" || f.Token == token) &&
+		(f.Action == "" || f.Action == action)
+}
+
+// QueryAccountBlocks gets the account blocks matching the specified filter in sequence order
+func (m *memoryTx) QueryAccountBlocks(f BlockFilter) ([]tradeblocks.NetworkAccountBlock, error) {
+	var result []tradeblocks.NetworkAccountBlock
+	m.query(AccountTag, f.Since, f.Limit, func(sequence int, b tradeblocks.Block) bool {
+		a := b.(*tradeblocks.AccountBlock)
+		if !f.match(a.Account, a.Token, a.Action) {
+			return false
+		}
+		result = append(result, tradeblocks.NetworkAccountBlock{
+			AccountBlock: copyBlock(a).(*tradeblocks.AccountBlock),
+			Sequence:     sequence,
+		})
+		return true
+	})
+	return result, nil
+}
+
+// QuerySwapBlocks gets the swap blocks matching the specified filter in sequence order
+func (m *memoryTx) QuerySwapBlocks(f BlockFilter) ([]tradeblocks.NetworkSwapBlock, error) {
+	var result []tradeblocks.NetworkSwapBlock
+	m.query(SwapTag, f.Since, f.Limit, func(sequence int, b tradeblocks.Block) bool {
+		s := b.(*tradeblocks.SwapBlock)
+		if !f.match(s.Account, s.Token, s.Action) {
+			return false
+		}
+		result = append(result, tradeblocks.NetworkSwapBlock{
+			SwapBlock: copyBlock(s).(*tradeblocks.SwapBlock),
+			Sequence:  sequence,
+		})
+		return true
+	})
+	return result, nil
+}
+
+// QueryOrderBlocks gets the order blocks matching the specified filter in sequence order
+func (m *memoryTx) QueryOrderBlocks(f BlockFilter) ([]tradeblocks.NetworkOrderBlock, error) {
+	var result []tradeblocks.NetworkOrderBlock
+	m.query(OrderTag, f.Since, f.Limit, func(sequence int, b tradeblocks.Block) bool {
+		o := b.(*tradeblocks.OrderBlock)
+		if !f.match(o.Account, o.Token, o.Action) {
+			return false
+		}
+		result = append(result, tradeblocks.NetworkOrderBlock{
+			OrderBlock: copyBlock(o).(*tradeblocks.OrderBlock),
+			Sequence:   sequence,
+		})
+		return true
+	})
+	return result, nil
+}
+
+// QueryBlocks returns up to limit blocks of every type with a sequence greater than since, in sequence order.
+// A limit of zero returns all blocks.
+func (m *memoryTx) QueryBlocks(since, limit int) ([]tradeblocks.NetworkBlock, error) {
+	blocks := m.view()
+	result := []tradeblocks.NetworkBlock{}
+	m.query(-1, since, limit, func(sequence int, b tradeblocks.Block) bool {
+		result = append(result, tradeblocks.NetworkBlock{
+			Type:     TagType(blocks[sequence-1].tag),
+			Sequence: sequence,
+			Time:     blocks[sequence-1].added,
+			Block:    copyBlock(b),
+		})
+		return true
+	})
+	return result, nil
+}
+
+// LastSequence returns the sequence of the most recently inserted block or zero if there are no blocks
+func (m *memoryTx) LastSequence() (int, error) {
+	return len(m.view()), nil
+}
@@ -0,0 +1,21 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jephir/tradeblocks/metrics"
+)
+
+var txDuration = metrics.Default.NewHistogram("tradeblocks_db_transaction_duration_seconds", "Time from the start of a transaction to its commit or rollback, by mode (read or write) and result (commit or rollback).", metrics.DefaultBuckets, "mode", "result")
+
+// observeTx records the duration of a finished transaction
+func observeTx(start time.Time, readOnly bool, err error) {
+	mode, result := "write", "commit"
+	if readOnly {
+		mode = "read"
+	}
+	if err != nil {
+		result = "rollback"
+	}
+	txDuration.ObserveSince(start, mode, result)
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Migration is a versioned change to the database schema
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+
+	// upgrade returns more statements that depend on the schema of the database, or nil
+	upgrade func(m *DB) ([]string, error)
+}
+
+// migrations is the schema history in version order. Released migrations must not be changed, add a new one
+// instead. Statements use {real}, {sequence} and {boolean} in place of the column types of the dialect.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create block tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS accounts(
+				action TEXT NOT NULL CHECK (action IN ('open', 'issue', 'send', 'receive')),
+				account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+				token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
+				previous TEXT UNIQUE,
+				representative TEXT NOT NULL CHECK (representative LIKE 'xtb:%'),
+				balance {real} NOT NULL CHECK (balance >= 0),
+				link TEXT,
+				signature TEXT NOT NULL UNIQUE,
+				hash TEXT NOT NULL UNIQUE,
+				FOREIGN KEY (previous) REFERENCES accounts(hash),
+				PRIMARY KEY (hash)
+				);`,
+			`CREATE TABLE IF NOT EXISTS swaps(
+				action TEXT NOT NULL CHECK (action IN ('offer', 'commit', 'refund-left', 'refund-right')),
+				account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+				token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
+				id TEXT NOT NULL,
+				previous TEXT UNIQUE,
+				"left" TEXT NOT NULL,
+				"right" TEXT,
+				refund_left TEXT CHECK (refund_left LIKE 'xtb:%'),
+				refund_right TEXT CHECK (refund_right LIKE 'xtb:%'),
+				counterparty TEXT NOT NULL CHECK (counterparty LIKE 'xtb:%'),
+				want TEXT NOT NULL CHECK (want LIKE 'xtb:%'),
+				quantity {real} NOT NULL CHECK (quantity >= 0),
+				executor TEXT CHECK (executor LIKE 'xtb:%'),
+				fee {real},
+				signature TEXT NOT NULL UNIQUE,
+				hash TEXT NOT NULL UNIQUE,
+				FOREIGN KEY (previous) REFERENCES swaps(hash),
+				PRIMARY KEY (hash)
+				);`,
+			`CREATE TABLE IF NOT EXISTS orders(
+				action TEXT NOT NULL CHECK (action IN ('create-order', 'accept-order', 'refund-order')),
+				account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+				token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
+				id TEXT NOT NULL,
+				previous TEXT,
+				balance {real} NOT NULL CHECK (balance >= 0),
+				quote TEXT NOT NULL,
+				price {real} NOT NULL CHECK (price >= 0),
+				link TEXT NOT NULL,
+				partial {boolean} NOT NULL,
+				executor TEXT CHECK (executor LIKE 'xtb:%'),
+				fee {real},
+				signature TEXT NOT NULL UNIQUE,
+				hash TEXT NOT NULL UNIQUE,
+				FOREIGN KEY (previous) REFERENCES orders(hash),
+				PRIMARY KEY (hash)
+				);`,
+			`CREATE TABLE IF NOT EXISTS confirms(
+				previous TEXT,
+				addr TEXT NOT NULL CHECK (addr LIKE 'xtb:%'),
+				head TEXT NOT NULL,
+				account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+				signature TEXT NOT NULL UNIQUE,
+				hash TEXT NOT NULL UNIQUE,
+				FOREIGN KEY (previous) REFERENCES confirms(hash),
+				PRIMARY KEY (hash)
+				);`,
+			`CREATE TABLE IF NOT EXISTS blocks(
+				sequence {sequence},
+				tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
+				hash TEXT NOT NULL UNIQUE
+				);`,
+			`CREATE TABLE IF NOT EXISTS heads(
+				tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
+				account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+				key TEXT NOT NULL,
+				head TEXT NOT NULL,
+				PRIMARY KEY (tag, account, key)
+				);`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "record when blocks are added",
+		Statements: []string{
+			`ALTER TABLE blocks ADD COLUMN added TEXT;`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add sequences to blocks tables created before migrations",
+		upgrade:     upgradeLegacyBlocks,
+	},
+	{
+		Version:     4,
+		Description: "index account blocks by link for claim checks",
+		Statements: []string{
+			`CREATE INDEX IF NOT EXISTS accounts_link ON accounts(link);`,
+		},
+	},
+}
+
+// upgradeLegacyBlocks rebuilds a blocks table that was created before migrations and has no sequence column.
+// Its blocks get sequences in the order they were added, which is rowid order. Only sqlite databases were
+// created before migrations.
+func upgradeLegacyBlocks(m *DB) ([]string, error) {
+	if m.dialect != sqliteDialect {
+		return nil, nil
+	}
+	var tables, columns int
+	if err := m.db.QueryRow(m.dialect.tableExists, "blocks").Scan(&tables); err != nil {
+		return nil, err
+	}
+	if tables == 0 {
+		return nil, nil
+	}
+	if err := m.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('blocks') WHERE name = 'sequence'`).Scan(&columns); err != nil {
+		return nil, err
+	}
+	if columns > 0 {
+		return nil, nil
+	}
+	return []string{
+		`ALTER TABLE blocks RENAME TO legacy_blocks;`,
+		`CREATE TABLE blocks(
+			sequence {sequence},
+			tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
+			hash TEXT NOT NULL UNIQUE,
+			added TEXT
+			);`,
+		`INSERT INTO blocks (sequence, tag, hash, added) SELECT rowid, tag, hash, added FROM legacy_blocks ORDER BY rowid;`,
+		`DROP TABLE legacy_blocks;`,
+	}, nil
+}
+
+const createSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version(
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	applied TEXT NOT NULL
+	);`
+
+// SchemaVersion returns the version of the last applied migration or zero if no migrations were applied
+func (m *DB) SchemaVersion() (int, error) {
+	var tables int
+	if err := m.db.QueryRow(m.dialect.tableExists, "schema_version").Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	var version int
+	err := m.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// PendingMigrations returns the migrations that are not applied yet in version order, with the column types of
+// this database
+func (m *DB) PendingMigrations() ([]Migration, error) {
+	version, err := m.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	types := strings.NewReplacer(
+		"{real}", m.dialect.real,
+		"{sequence}", m.dialect.sequence,
+		"{boolean}", m.dialect.boolean)
+	var result []Migration
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+		statements := append([]string(nil), migration.Statements...)
+		if migration.upgrade != nil {
+			more, err := migration.upgrade(m)
+			if err != nil {
+				return nil, fmt.Errorf("db: error checking schema for migration %d: %s", migration.Version, err.Error())
+			}
+			statements = append(statements, more...)
+		}
+		for i, stmnt := range statements {
+			statements[i] = types.Replace(stmnt)
+		}
+		migration.Statements = statements
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// Migrate applies the pending migrations in version order and returns them. Each migration runs in its own
+// transaction together with its schema_version record.
+func (m *DB) Migrate() ([]Migration, error) {
+	if _, err := m.db.Exec(createSchemaVersionTable); err != nil {
+		return nil, fmt.Errorf("db: error creating schema_version table: %s", err.Error())
+	}
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range pending {
+		if err := m.apply(migration); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+func (m *DB) apply(migration Migration) (err error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				m.log.Error("can't roll back migration", "version", migration.Version, "error", err2)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	for i, stmnt := range migration.Statements {
+		if _, err = tx.Exec(stmnt); err != nil {
+			return fmt.Errorf("db: error executing statement %d of migration %d: %s", i+1, migration.Version, err.Error())
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description, applied) VALUES ($1, $2, $3)`,
+		migration.Version,
+		migration.Description,
+		time.Now().UTC().Format(time.RFC3339))
+	return err
+}
@@ -0,0 +1,63 @@
+package db
+
+import "github.com/jephir/tradeblocks"
+
+// Store is a block storage backend
+type Store interface {
+	// NewTransaction initializes a new write transaction. It must be finished with a call to Commit().
+	// Write transactions are serialized.
+	NewTransaction() (Tx, error)
+
+	// NewReadTransaction initializes a new read-only transaction. It must be finished with a call to Commit().
+	// Read transactions don't wait for each other or for the writer.
+	NewReadTransaction() (ReadTx, error)
+
+	// Close releases all resources used by this store
+	Close() error
+}
+
+// Tx is a write transaction on a block storage backend
+type Tx interface {
+	ReadTx
+
+	// Rollback discards every insert of the transaction
+	Rollback() error
+
+	InsertAccountBlock(b *tradeblocks.AccountBlock) error
+	InsertSwapBlock(b *tradeblocks.SwapBlock) error
+	InsertOrderBlock(b *tradeblocks.OrderBlock) error
+	InsertConfirmBlock(b *tradeblocks.ConfirmBlock) error
+}
+
+// ReadTx is a read-only transaction on a block storage backend. Getters return ErrNotFound if there is no
+// matching block.
+type ReadTx interface {
+	// Commit commits the transaction or does a rollback if an insert failed
+	Commit() error
+
+	GetBlock(hash string) (tag int, block tradeblocks.Block, err error)
+	GetAccountBlock(hash string) (*tradeblocks.AccountBlock, error)
+	GetSwapBlock(hash string) (*tradeblocks.SwapBlock, error)
+	GetOrderBlock(hash string) (*tradeblocks.OrderBlock, error)
+	GetConfirmBlock(hash string) (*tradeblocks.ConfirmBlock, error)
+
+	GetAccountHead(account, token string) (*tradeblocks.AccountBlock, error)
+	GetSwapHead(account, id string) (*tradeblocks.SwapBlock, error)
+	GetOrderHead(account, id string) (*tradeblocks.OrderBlock, error)
+	GetConfirmHead(account, addr string) (*tradeblocks.ConfirmBlock, error)
+
+	// GetReceiveBlock gets the open or receive block that claims the specified send
+	GetReceiveBlock(send string) (*tradeblocks.AccountBlock, error)
+
+	// GetClaimBlocks gets the open and receive blocks of every account that claim link
+	GetClaimBlocks(link string) ([]*tradeblocks.AccountBlock, error)
+
+	// GetLimitOrders returns orders for base priced in quote with a price that meets condition (">=" or "<=") against ppu
+	GetLimitOrders(base, condition string, ppu float64, quote string) ([]*tradeblocks.OrderBlock, error)
+
+	QueryAccountBlocks(f BlockFilter) ([]tradeblocks.NetworkAccountBlock, error)
+	QuerySwapBlocks(f BlockFilter) ([]tradeblocks.NetworkSwapBlock, error)
+	QueryOrderBlocks(f BlockFilter) ([]tradeblocks.NetworkOrderBlock, error)
+	QueryBlocks(since, limit int) ([]tradeblocks.NetworkBlock, error)
+	LastSequence() (int, error)
+}
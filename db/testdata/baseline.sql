@@ -0,0 +1,86 @@
+-- Schema and blocks of a database created before versioned migrations
+CREATE TABLE IF NOT EXISTS accounts(
+	action TEXT NOT NULL CHECK (action IN ('open', 'issue', 'send', 'receive')),
+	account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+	token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
+	previous TEXT UNIQUE,
+	representative TEXT NOT NULL CHECK (representative LIKE 'xtb:%'),
+	balance REAL NOT NULL CHECK (balance >= 0),
+	link TEXT,
+	signature TEXT NOT NULL UNIQUE,
+	hash TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (previous) REFERENCES accounts(hash),
+	PRIMARY KEY (hash)
+	);
+CREATE TABLE IF NOT EXISTS swaps(
+	action TEXT NOT NULL CHECK (action IN ('offer', 'commit', 'refund-left', 'refund-right')),
+	account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+	token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
+	id TEXT NOT NULL,
+	previous TEXT UNIQUE,
+	left TEXT NOT NULL,
+	right TEXT,
+	refund_left TEXT CHECK (refund_left LIKE 'xtb:%'),
+	refund_right TEXT CHECK (refund_right LIKE 'xtb:%'),
+	counterparty TEXT NOT NULL CHECK (counterparty LIKE 'xtb:%'),
+	want TEXT NOT NULL CHECK (want LIKE 'xtb:%'),
+	quantity REAL NOT NULL CHECK (quantity >= 0),
+	executor TEXT CHECK (executor LIKE 'xtb:%'),
+	fee REAL,
+	signature TEXT NOT NULL UNIQUE,
+	hash TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (previous) REFERENCES swaps(hash),
+	PRIMARY KEY (hash)
+	);
+CREATE TABLE IF NOT EXISTS orders(
+	action TEXT NOT NULL CHECK (action IN ('create-order', 'accept-order', 'refund-order')),
+	account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+	token TEXT NOT NULL CHECK (token LIKE 'xtb:%'),
+	id TEXT NOT NULL,
+	previous TEXT,
+	balance REAL NOT NULL CHECK (balance >= 0),
+	quote TEXT NOT NULL,
+	price REAL NOT NULL CHECK (price >= 0),
+	link TEXT NOT NULL,
+	partial INTEGER NOT NULL,
+	executor TEXT CHECK (executor LIKE 'xtb:%'),
+	fee REAL,
+	signature TEXT NOT NULL UNIQUE,
+	hash TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (previous) REFERENCES orders(hash),
+	PRIMARY KEY (hash)
+	);
+CREATE TABLE IF NOT EXISTS confirms(
+	previous TEXT,
+	addr TEXT NOT NULL CHECK (addr LIKE 'xtb:%'),
+	head TEXT NOT NULL,
+	account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+	signature TEXT NOT NULL UNIQUE,
+	hash TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (previous) REFERENCES confirms(hash),
+	PRIMARY KEY (hash)
+	);
+CREATE TABLE IF NOT EXISTS blocks(
+	tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
+	hash TEXT NOT NULL,
+	PRIMARY KEY (hash)
+	);
+CREATE TABLE IF NOT EXISTS heads(
+	tag INTEGER NOT NULL CHECK (tag BETWEEN 0 AND 3),
+	account TEXT NOT NULL CHECK (account LIKE 'xtb:%'),
+	key TEXT NOT NULL,
+	head TEXT NOT NULL,
+	PRIMARY KEY (tag, account, key)
+	);
+
+INSERT INTO accounts (action, account, token, previous, representative, balance, link, signature, hash) VALUES
+	('issue', 'xtb:alice', 'xtb:alice', NULL, 'xtb:alice', 100, '', 'sig-issue', 'I5TTDDX5ZOYGUDJZUMBDA225HMELJUCS6P4RAWHMFMD4UCTEUABA');
+INSERT INTO blocks (tag, hash) VALUES (0, 'I5TTDDX5ZOYGUDJZUMBDA225HMELJUCS6P4RAWHMFMD4UCTEUABA');
+INSERT INTO accounts (action, account, token, previous, representative, balance, link, signature, hash) VALUES
+	('send', 'xtb:alice', 'xtb:alice', 'I5TTDDX5ZOYGUDJZUMBDA225HMELJUCS6P4RAWHMFMD4UCTEUABA', 'xtb:alice', 60, 'xtb:bob', 'sig-send', 'RNOVD4DMJFKHFZBQLRR45FGWJVKVW2S4K2B2FMAITBCIXH64U4IQ');
+INSERT INTO blocks (tag, hash) VALUES (0, 'RNOVD4DMJFKHFZBQLRR45FGWJVKVW2S4K2B2FMAITBCIXH64U4IQ');
+INSERT INTO heads (tag, account, key, head) VALUES (0, 'xtb:alice', 'xtb:alice', 'RNOVD4DMJFKHFZBQLRR45FGWJVKVW2S4K2B2FMAITBCIXH64U4IQ');
+INSERT INTO accounts (action, account, token, previous, representative, balance, link, signature, hash) VALUES
+	('issue', 'xtb:dave', 'xtb:dave', NULL, 'xtb:dave', 50, '', 'sig-dave', 'C6XCZZODBZ5VH2DYWKXTUDREHTRBFEES44GGI7BBI22PMYPEZSDA');
+INSERT INTO blocks (tag, hash) VALUES (0, 'C6XCZZODBZ5VH2DYWKXTUDREHTRBFEES44GGI7BBI22PMYPEZSDA');
+INSERT INTO heads (tag, account, key, head) VALUES (0, 'xtb:dave', 'xtb:dave', 'C6XCZZODBZ5VH2DYWKXTUDREHTRBFEES44GGI7BBI22PMYPEZSDA');
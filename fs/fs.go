@@ -2,18 +2,31 @@ package fs
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
 )
 
-// BlockStorage saves and loads blocks on the filesystem
+// tempPrefix starts the names of files that are being written
+const tempPrefix = ".tmp-"
+
+// BlockStorage is a content-addressed archive of blocks on the filesystem. Every block is stored once as a
+// tradeblocks.NetworkBlock in blocks/<first two characters of its hash>/<hash>, so an archive can be copied
+// to cold storage and updated in place.
 type BlockStorage struct {
 	blockstore *app.BlockStore
 	dir        string
+
+	mu       sync.Mutex
+	sequence int // last sequence of the block store that was saved
 }
 
 // NewBlockStorage returns a new storage adapter for the specified blockstore and data directory
@@ -24,204 +37,143 @@ func NewBlockStorage(blockstore *app.BlockStore, dir string) *BlockStorage {
 	}
 }
 
-// Save saves all blocks to the filesystem
+// Save saves every block of the block store that wasn't saved by an earlier call. Blocks that are already
+// in the archive aren't written again.
 func (s *BlockStorage) Save() error {
-	// Create storage directory
-	if err := s.createStorageDir(); err != nil {
-		return err
-	}
-	// Serialize each block and write it to the directory
-	var err error
-	s.blockstore.AccountBlocks(func(sequence int, b *tradeblocks.AccountBlock) bool {
-		err = s.SaveAccountBlock(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		blocks, err := s.blockstore.QueryBlocks(s.sequence, 1000)
 		if err != nil {
-			return false
+			return err
 		}
-		return true
-	})
-	s.blockstore.SwapBlocks(func(sequence int, b *tradeblocks.SwapBlock) bool {
-		err = s.SaveSwapBlock(b)
-		if err != nil {
-			return false
+		if len(blocks) == 0 {
+			return nil
 		}
-		return true
-	})
-	s.blockstore.OrderBlocks(func(sequence int, b *tradeblocks.OrderBlock) bool {
-		err = s.SaveOrderBlock(b)
-		if err != nil {
-			return false
+		for _, b := range blocks {
+			if err := s.SaveBlock(b); err != nil {
+				return err
+			}
+			s.sequence = b.Sequence
 		}
-		return true
-	})
-	return err
-}
-
-func (s *BlockStorage) createStorageDir() error {
-	if err := os.MkdirAll(accountsDir(s.dir), 0700); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(swapsDir(s.dir), 0700); err != nil {
-		return err
 	}
-	if err := os.MkdirAll(ordersDir(s.dir), 0700); err != nil {
-		return err
-	}
-	return nil
 }
 
-// SaveAccountBlock saves the specified block with the specified hash to the filesystem
-func (s *BlockStorage) SaveAccountBlock(block *tradeblocks.AccountBlock) error {
-	if err := s.createStorageDir(); err != nil {
+// SaveBlock saves the specified block unless it's already in the archive. The block is written to a
+// temporary file that's renamed when it's complete, so the archive never has a partial block.
+func (s *BlockStorage) SaveBlock(b tradeblocks.NetworkBlock) error {
+	p := s.path(b.Block.Hash())
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
 		return err
 	}
-	hash := block.Hash()
-	p := filepath.Join(accountsDir(s.dir), hash)
-	f, err := os.Create(p)
-	if err != nil {
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
-	defer f.Close()
-	if err := json.NewEncoder(f).Encode(block); err != nil {
-		return err
-	}
-	return f.Close()
-}
-
-// SaveSwapBlock saves the specified block with the specified hash to the filesystem
-func (s *BlockStorage) SaveSwapBlock(block *tradeblocks.SwapBlock) error {
-	if err := s.createStorageDir(); err != nil {
-		return err
-	}
-	hash := block.Hash()
-	p := filepath.Join(swapsDir(s.dir), hash)
-	f, err := os.Create(p)
+	f, err := ioutil.TempFile(dir, tempPrefix)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
-	if err := json.NewEncoder(f).Encode(block); err != nil {
+	if err := json.NewEncoder(f).Encode(b); err != nil {
 		return err
 	}
-	return f.Close()
-}
-
-// SaveOrderBlock saves the specified block with the specified hash to the filesystem
-func (s *BlockStorage) SaveOrderBlock(block *tradeblocks.OrderBlock) error {
-	if err := s.createStorageDir(); err != nil {
+	if err := f.Sync(); err != nil {
 		return err
 	}
-	hash := block.Hash()
-	p := filepath.Join(ordersDir(s.dir), hash)
-	f, err := os.Create(p)
-	if err != nil {
+	if err := f.Close(); err != nil {
 		return err
 	}
-	defer f.Close()
-	if err := json.NewEncoder(f).Encode(block); err != nil {
-		return err
-	}
-	return f.Close()
+	return os.Rename(f.Name(), p)
 }
 
-// Load loads all blocks from the filesystem
+// Load adds every block in the archive that isn't in the block store yet, in the sequence order they were
+// saved in. It returns an error if a block file doesn't match its hash or a block fails validation.
 func (s *BlockStorage) Load() error {
-	if err := s.loadAccounts(); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	if err := s.loadSwaps(); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	if err := s.loadOrders(); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	return nil
-}
-
-func (s *BlockStorage) loadAccounts() error {
-	dir := accountsDir(s.dir)
-	files, err := ioutil.ReadDir(dir)
+	blocks, err := s.readBlocks()
 	if err != nil {
 		return err
 	}
-	for _, file := range files {
-		p := filepath.Join(dir, file.Name())
-		if err := s.loadAccountBlock(p); err != nil {
-			return err
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Sequence < blocks[j].Sequence
+	})
+	for _, b := range blocks {
+		if err := s.addBlock(b); err != nil {
+			return fmt.Errorf("fs: loading block %s: %s", b.Block.Hash(), err.Error())
 		}
 	}
 	return nil
 }
 
-func (s *BlockStorage) loadSwaps() error {
-	dir := swapsDir(s.dir)
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		return err
+// readBlocks reads and verifies every block in the archive
+func (s *BlockStorage) readBlocks() ([]tradeblocks.NetworkBlock, error) {
+	root := blocksDir(s.dir)
+	shards, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	for _, file := range files {
-		p := filepath.Join(dir, file.Name())
-		if err := s.loadSwapBlock(p); err != nil {
-			return err
+	var blocks []tradeblocks.NetworkBlock
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
 		}
-	}
-	return nil
-}
-
-func (s *BlockStorage) loadOrders() error {
-	dir := ordersDir(s.dir)
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-	for _, file := range files {
-		p := filepath.Join(dir, file.Name())
-		if err := s.loadOrderBlock(p); err != nil {
-			return err
+		files, err := ioutil.ReadDir(filepath.Join(root, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), tempPrefix) {
+				continue
+			}
+			b, err := s.readBlock(filepath.Join(root, shard.Name(), file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, b)
 		}
 	}
-	return nil
+	return blocks, nil
 }
 
-func (s *BlockStorage) loadAccountBlock(p string) error {
+// readBlock reads the block file at p and checks that it's stored at the path of its hash
+func (s *BlockStorage) readBlock(p string) (b tradeblocks.NetworkBlock, err error) {
 	f, err := os.Open(p)
 	if err != nil {
-		return err
+		return
 	}
 	defer f.Close()
-	var b tradeblocks.AccountBlock
-	if err := json.NewDecoder(f).Decode(&b); err != nil {
-		return err
-	}
-	s.blockstore.AddAccountBlock(&b)
-	return nil
-}
-
-func (s *BlockStorage) loadSwapBlock(p string) error {
-	f, err := os.Open(p)
-	if err != nil {
-		return err
+	if err = json.NewDecoder(f).Decode(&b); err != nil {
+		return b, fmt.Errorf("fs: decoding %s: %s", p, err.Error())
 	}
-	defer f.Close()
-	var b tradeblocks.SwapBlock
-	if err := json.NewDecoder(f).Decode(&b); err != nil {
-		return err
+	if hash := b.Block.Hash(); s.path(hash) != p {
+		return b, fmt.Errorf("fs: block in %s has hash %s", p, hash)
 	}
-	s.blockstore.AddSwapBlock(&b)
-	return nil
+	return
 }
 
-func (s *BlockStorage) loadOrderBlock(p string) error {
-	f, err := os.Open(p)
-	if err != nil {
+// addBlock adds the specified block to the block store unless it's already there
+func (s *BlockStorage) addBlock(b tradeblocks.NetworkBlock) error {
+	if _, err := s.blockstore.Block(b.Block.Hash()); err == nil {
+		return nil
+	} else if err != db.ErrNotFound {
 		return err
 	}
-	defer f.Close()
-	var b tradeblocks.OrderBlock
-	if err := json.NewDecoder(f).Decode(&b); err != nil {
-		return err
+	switch b := b.Block.(type) {
+	case *tradeblocks.AccountBlock:
+		return s.blockstore.AddAccountBlock(b)
+	case *tradeblocks.SwapBlock:
+		return s.blockstore.AddSwapBlock(b)
+	case *tradeblocks.OrderBlock:
+		return s.blockstore.AddOrderBlock(b)
+	case *tradeblocks.ConfirmBlock:
+		return s.blockstore.AddConfirmBlock(b)
 	}
-	s.blockstore.AddOrderBlock(&b)
-	return nil
+	return fmt.Errorf("fs: unknown block type '%s'", b.Type)
 }
 
 // Dir returns the working directory of this storage
@@ -229,14 +181,15 @@ func (s *BlockStorage) Dir() string {
 	return s.dir
 }
 
-func accountsDir(root string) string {
-	return filepath.Join(root, "accounts")
-}
-
-func swapsDir(root string) string {
-	return filepath.Join(root, "swaps")
+// path returns the file of the block with the specified hash
+func (s *BlockStorage) path(hash string) string {
+	shard := hash
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return filepath.Join(blocksDir(s.dir), shard, hash)
 }
 
-func ordersDir(root string) string {
-	return filepath.Join(root, "orders")
+func blocksDir(root string) string {
+	return filepath.Join(root, "blocks")
 }
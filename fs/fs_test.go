@@ -3,6 +3,8 @@ package fs
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/jephir/tradeblocks"
@@ -69,3 +71,85 @@ func TestFS(t *testing.T) {
 		t.Fatalf("block [2] %s is missing", h2)
 	}
 }
+
+func TestIncrementalSave(t *testing.T) {
+	store1 := app.NewBlockStore()
+	key, address := app.CreateAccount(t)
+	node, nodeAddress := app.CreateAccount(t)
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bs1 := NewBlockStorage(store1, dir)
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(address, 100), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store1.AddAccountBlock(issue); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs1.Save(); err != nil {
+		t.Fatal(err)
+	}
+	confirm := tradeblocks.NewConfirmBlock(nil, nodeAddress, issue.Address(), issue.Hash())
+	if err := confirm.SignBlock(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := store1.AddConfirmBlock(confirm); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs1.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := confirm.Hash()
+	if _, err := os.Stat(filepath.Join(dir, "blocks", h[:2], h)); err != nil {
+		t.Fatalf("expected confirm block in its shard: %s", err.Error())
+	}
+
+	store2 := app.NewBlockStore()
+	if err := NewBlockStorage(store2, dir).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store2.GetConfirmHead(nodeAddress, issue.Address()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadVerifiesHash(t *testing.T) {
+	store1 := app.NewBlockStore()
+	key, address := app.CreateAccount(t)
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(address, 100), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store1.AddAccountBlock(issue); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := NewBlockStorage(store1, dir).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := issue.Hash()
+	p := filepath.Join(dir, "blocks", h[:2], h)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(b), `"Balance":100`, `"Balance":1000`, 1)
+	if err := ioutil.WriteFile(p, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewBlockStorage(app.NewBlockStore(), dir).Load()
+	if err == nil || !strings.Contains(err.Error(), "has hash") {
+		t.Fatalf("expected hash mismatch error, got %v", err)
+	}
+}
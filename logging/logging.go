@@ -0,0 +1,253 @@
+// Package logging makes structured, leveled loggers for the subsystems of a node, such as "node", "web" and "db"
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+)
+
+// Level is the severity of a record
+type Level int
+
+// Levels of records
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// parseLevel parses the name of a level: debug, info, warn or error
+func parseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("logging: unknown level '%s'", s)
+}
+
+// timeFormat is the format of the time of a record
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Root writes the records of every subsystem to one writer, with a level for each subsystem
+type Root struct {
+	out    *log.Logger
+	text   bool
+	level  Level
+	levels map[string]Level
+}
+
+// New returns a root logger that writes JSON records to w, or text records if format is "text". Subsystems log at
+// level unless levels has a level for them.
+func New(w io.Writer, format string, level Level, levels map[string]Level) (*Root, error) {
+	var text bool
+	switch format {
+	case "", "json":
+	case "text":
+		text = true
+	default:
+		return nil, fmt.Errorf("logging: unknown format '%s'", format)
+	}
+	return &Root{out: log.New(w, "", 0), text: text, level: level, levels: levels}, nil
+}
+
+// For returns the logger of a subsystem, which adds the name of the subsystem to its records
+func (r *Root) For(subsystem string) *Logger {
+	level, ok := r.levels[subsystem]
+	if !ok {
+		level = r.level
+	}
+	return &Logger{out: r.out, text: r.text, level: level, args: []interface{}{"subsystem", subsystem}}
+}
+
+// ParseLevels parses a comma-separated list of a default level and subsystem=level pairs, such as
+// "info,db=debug,web=warn". Levels are debug, info, warn and error.
+func ParseLevels(s string) (level Level, levels map[string]Level, err error) {
+	levels = make(map[string]Level)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem, name := "", part
+		if i := strings.Index(part, "="); i >= 0 {
+			subsystem, name = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+			if subsystem == "" {
+				return 0, nil, fmt.Errorf("logging: missing subsystem in '%s'", part)
+			}
+		}
+		l, err := parseLevel(name)
+		if err != nil {
+			return 0, nil, err
+		}
+		if subsystem == "" {
+			level = l
+		} else {
+			levels[subsystem] = l
+		}
+	}
+	return level, levels, nil
+}
+
+// Logger writes the records of a subsystem that are at or above its level. Records are written with the standard
+// log package, so concurrent records aren't interleaved.
+type Logger struct {
+	out   *log.Logger // nil for the standard logger
+	text  bool
+	level Level
+	args  []interface{} // key-value pairs added to every record
+}
+
+// Default returns the logger of a subsystem that writes text records to the standard logger at info level, for
+// packages that aren't given a logger
+func Default(subsystem string) *Logger {
+	return &Logger{text: true, level: LevelInfo, args: []interface{}{"subsystem", subsystem}}
+}
+
+// Discard returns a logger that drops every record
+func Discard() *Logger {
+	return &Logger{level: LevelError + 1}
+}
+
+// Block returns the key-value pairs of the block that a record is about: its hash and address
+func Block(b tradeblocks.Block) []interface{} {
+	return []interface{}{"hash", b.Hash(), "address", b.Address()}
+}
+
+// With returns a logger that adds the specified key-value pairs to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+	c := *l
+	c.args = append(l.args[:len(l.args):len(l.args)], args...)
+	return &c
+}
+
+// Debug writes a record at debug level
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.Log(LevelDebug, msg, args...)
+}
+
+// Info writes a record at info level
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.Log(LevelInfo, msg, args...)
+}
+
+// Warn writes a record at warn level
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.Log(LevelWarn, msg, args...)
+}
+
+// Error writes a record at error level
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.Log(LevelError, msg, args...)
+}
+
+// Log writes a record with a message and key-value pairs if its level is at or above the level of the logger
+func (l *Logger) Log(level Level, msg string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	args = append(l.args[:len(l.args):len(l.args)], args...)
+	if l.out == nil {
+		// The standard logger adds the time
+		log.Print(textRecord("", level, msg, args))
+		return
+	}
+	now := time.Now().Format(timeFormat)
+	if l.text {
+		l.out.Print(textRecord(now, level, msg, args))
+	} else {
+		l.out.Print(jsonRecord(now, level, msg, args))
+	}
+}
+
+// jsonRecord returns a record as a JSON object
+func jsonRecord(now string, level Level, msg string, args []interface{}) string {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	b.Write(jsonValue(now))
+	b.WriteString(`,"level":`)
+	b.Write(jsonValue(level.String()))
+	b.WriteString(`,"msg":`)
+	b.Write(jsonValue(msg))
+	eachPair(args, func(key string, value interface{}) {
+		b.WriteByte(',')
+		b.Write(jsonValue(key))
+		b.WriteByte(':')
+		b.Write(jsonValue(value))
+	})
+	b.WriteByte('}')
+	return b.String()
+}
+
+// textRecord returns a record as key=value pairs, starting with the time unless now is empty
+func textRecord(now string, level Level, msg string, args []interface{}) string {
+	var b bytes.Buffer
+	if now != "" {
+		b.WriteString("time=" + now + " ")
+	}
+	b.WriteString("level=" + level.String() + " msg=" + textValue(msg))
+	eachPair(args, func(key string, value interface{}) {
+		b.WriteString(" " + textValue(key) + "=" + textValue(value))
+	})
+	return b.String()
+}
+
+// eachPair calls fn with every key-value pair of args. A value without a key gets the key "!BADKEY".
+func eachPair(args []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fn("!BADKEY", args[i])
+			return
+		}
+		fn(fmt.Sprint(args[i]), args[i+1])
+	}
+}
+
+// jsonValue encodes a value as JSON. Errors are encoded as their message, and values that can't be encoded as text.
+func jsonValue(v interface{}) []byte {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return data
+}
+
+// textValue formats a value, quoting it if it's empty or has spaces, quotes, equals signs or special characters
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if q := strconv.Quote(s); s == "" || q[1:len(q)-1] != s || strings.ContainsAny(s, " =") {
+		return q
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	level, levels, err := ParseLevels("warn, db=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	l, err := New(&buf, "json", level, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.For("web").Info("dropped")
+	l.For("web").Warn("kept", "hash", "h")
+	l.For("db").Debug("kept")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", lines)
+	}
+	var r struct {
+		Level     string
+		Msg       string `json:"msg"`
+		Subsystem string `json:"subsystem"`
+		Hash      string `json:"hash"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Msg != "kept" || r.Subsystem != "web" || r.Hash != "h" {
+		t.Fatalf("unexpected record %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"subsystem":"db"`) || !strings.Contains(lines[1], `"level":"DEBUG"`) {
+		t.Fatalf("unexpected record %s", lines[1])
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	level, levels, err := ParseLevels("db=error,info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != LevelInfo || levels["db"] != LevelError {
+		t.Fatalf("unexpected levels %v %v", level, levels)
+	}
+	for _, s := range []string{"loud", "=debug", "db=loud"} {
+		if _, _, err := ParseLevels(s); err == nil {
+			t.Errorf("expected an error for '%s'", s)
+		}
+	}
+	if _, err := New(nil, "xml", 0, nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	r, err := New(&buf, "text", LevelInfo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := r.For("node").With("peer", "http://localhost:8081")
+	l.Error("can't sync block", "error", errors.New("connection refused"), "retries", 2)
+	Discard().Error("dropped")
+
+	line := strings.TrimSpace(buf.String())
+	want := `level=ERROR msg="can't sync block" subsystem=node peer=http://localhost:8081 error="connection refused" retries=2`
+	if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, want) {
+		t.Fatalf("unexpected record %s", line)
+	}
+}
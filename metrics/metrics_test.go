@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_blocks_total", "Blocks by type.", "type")
+	g := r.NewGauge("test_peers", "Peers.")
+	r.NewGaugeFunc("test_clients", "Clients.", func() float64 { return 3 })
+	h := r.NewHistogram("test_duration_seconds", "Duration.", []float64{.1, 1}, "mode")
+
+	c.Inc("account")
+	c.Add(2, `a"b`)
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(.05, "read")
+	h.Observe(.5, "read")
+	h.Observe(5, "read")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP test_blocks_total Blocks by type.
+# TYPE test_blocks_total counter
+test_blocks_total{type="a\"b"} 2
+test_blocks_total{type="account"} 1
+# HELP test_clients Clients.
+# TYPE test_clients gauge
+test_clients 3
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{mode="read",le="0.1"} 1
+test_duration_seconds_bucket{mode="read",le="1"} 2
+test_duration_seconds_bucket{mode="read",le="+Inf"} 3
+test_duration_seconds_sum{mode="read"} 5.55
+test_duration_seconds_count{mode="read"} 3
+# HELP test_peers Peers.
+# TYPE test_peers gauge
+test_peers 1
+`
+	if b.String() != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, b.String())
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a duplicate metric")
+		}
+	}()
+	r.NewGauge("test_total", "Test.")
+}
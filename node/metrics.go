@@ -0,0 +1,9 @@
+package node
+
+import "github.com/jephir/tradeblocks/metrics"
+
+var (
+	peerCount         = metrics.Default.NewGauge("tradeblocks_peers", "Peers that blocks are broadcast to.")
+	broadcastFailures = metrics.Default.NewCounter("tradeblocks_broadcast_failures_total", "Blocks that failed to be posted to a peer.")
+	executorFills     = metrics.Default.NewCounter("tradeblocks_executor_fills_total", "Swap offers filled by this node as the executor of the order.")
+)
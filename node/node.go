@@ -1,17 +1,23 @@
 package node
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
 	"sync"
 
 	"github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/app"
 	"github.com/jephir/tradeblocks/db"
+	"github.com/jephir/tradeblocks/fs"
+	"github.com/jephir/tradeblocks/logging"
 	"github.com/jephir/tradeblocks/web"
 )
 
@@ -27,6 +33,12 @@ type Node struct {
 	client *http.Client
 	server *web.Server
 
+	archive *fs.BlockStorage
+	log     *logging.Logger
+
+	executor bool    // whether the node fills orders for offers that name it as executor
+	minFee   float64 // minimum fee of an offer that the node fills orders for
+
 	priv    *rsa.PrivateKey
 	address string
 	hostURL string
@@ -36,20 +48,25 @@ type Node struct {
 	seenAccountBlocks blockHashMap
 }
 
-// NewNode creates a new node or returns an error if it fails.
-func NewNode(dir string) (n *Node, err error) {
+// NewNode creates a new node that stores blocks and its key in the specified directory or returns an error if it fails.
+func NewNode(dir string) (*Node, error) {
+	priv, err := LoadKey(dir)
+	if err != nil {
+		return nil, err
+	}
 	f := filepath.Join(dir, "tradeblocks.db")
 	store, err := app.NewPersistBlockStore(f)
 	if err != nil {
 		return nil, err
 	}
+	return NewNodeWithStore(store, priv)
+}
+
+// NewNodeWithStore creates a new node that uses the specified block store and key or returns an error if it fails.
+func NewNodeWithStore(store *app.BlockStore, priv *rsa.PrivateKey) (n *Node, err error) {
 	server := web.NewServer(store)
 	c := &http.Client{}
 
-	priv, err := rsa.GenerateKey(rand.Reader, keySize)
-	if err != nil {
-		return
-	}
 	address, err := app.PrivateKeyToAddress(priv)
 	if err != nil {
 		return
@@ -61,6 +78,8 @@ func NewNode(dir string) (n *Node, err error) {
 		server:            server,
 		priv:              priv,
 		address:           address,
+		executor:          true,
+		log:               logging.Default("node"),
 		peers:             make(peerMap),
 		seenAccountBlocks: make(blockHashMap),
 	}
@@ -68,284 +87,202 @@ func NewNode(dir string) (n *Node, err error) {
 		return
 	}
 	server.BlockHandler = n.handleBlock
+	server.Address = address
 	return
 }
 
-// Bootstrap registers with the specified server and downloads all blocks
-func (n *Node) Bootstrap(hostURL, bootstrapURL string) error {
-	n.hostURL = hostURL
+// SetLogger makes the node and its server log to the "node" and "web" subsystems of the specified logger
+func (n *Node) SetLogger(l *logging.Root) {
+	n.log = l.For("node")
+	n.server.SetLogger(l.For("web"))
+}
 
-	client := web.NewClient(bootstrapURL)
-	accounts, err := n.bootstrapAccounts(hostURL, client)
-	if err != nil {
+// SetArchive saves every block of the node to a block archive in the specified directory and makes the node
+// save every new block to it
+func (n *Node) SetArchive(dir string) error {
+	archive := fs.NewBlockStorage(n.store, dir)
+	if err := archive.Save(); err != nil {
 		return err
 	}
-	swaps, err := n.bootstrapSwaps(hostURL, client)
-	if err != nil {
-		return err
-	}
-	orders, err := n.bootstrapOrders(hostURL, client)
-	if err != nil {
-		return err
-	}
-	var sequence int
-	for {
-		var found bool
-		if b := accountWithSequence(accounts, sequence); b != nil {
-			if err := n.store.AddAccountBlock(b.AccountBlock); err != nil {
-				return err
-			}
-			found = true
-		}
-		if b := swapWithSequence(swaps, sequence); b != nil {
-			if err := n.store.AddSwapBlock(b.SwapBlock); err != nil {
-				return err
-			}
-			found = true
-		}
-		if b := orderWithSequence(orders, sequence); b != nil {
-			if err := n.store.AddOrderBlock(b.OrderBlock); err != nil {
-				return err
-			}
-			found = true
-		}
-		if !found {
-			break
-		}
-		sequence++
-	}
+	n.archive = archive
 	return nil
 }
 
-func accountWithSequence(accounts map[string]tradeblocks.NetworkAccountBlock, sequence int) *tradeblocks.NetworkAccountBlock {
-	for _, b := range accounts {
-		if b.Sequence == sequence {
-			return &b
-		}
-	}
-	return nil
+// SetExecutor sets whether the node fills orders for offers that name it as executor, and the minimum fee an
+// offer must pay for the node to fill it
+func (n *Node) SetExecutor(enabled bool, minFee float64) {
+	n.executor = enabled
+	n.minFee = minFee
 }
 
-func swapWithSequence(swaps map[string]tradeblocks.NetworkSwapBlock, sequence int) *tradeblocks.NetworkSwapBlock {
-	for _, b := range swaps {
-		if b.Sequence == sequence {
-			return &b
-		}
-	}
-	return nil
+// AddPeer makes the node broadcast every new block to the node at the specified URL
+func (n *Node) AddPeer(address string) {
+	n.addPeer(address)
 }
 
-func orderWithSequence(orders map[string]tradeblocks.NetworkOrderBlock, sequence int) *tradeblocks.NetworkOrderBlock {
-	for _, b := range orders {
-		if b.Sequence == sequence {
-			return &b
-		}
-	}
-	return nil
+// LoadKey reads the node key from node.pem in the specified directory, or generates and saves a new key if
+// there's none, so the node keeps its address across restarts
+func LoadKey(dir string) (*rsa.PrivateKey, error) {
+	return LoadKeyFile(filepath.Join(dir, "node.pem"))
 }
 
-func (n *Node) bootstrapAccounts(hostURL string, client *web.Client) (map[string]tradeblocks.NetworkAccountBlock, error) {
-	// Create get all blocks request and register this server
-	r, err := client.NewGetAccountBlocksRequest()
-	if err != nil {
+// LoadKeyFile is like LoadKey but reads the node key from the specified file
+func LoadKeyFile(f string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		priv, err := rsa.GenerateKey(rand.Reader, keySize)
+		if err != nil {
+			return nil, err
+		}
+		p := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(priv),
+		})
+		if err := ioutil.WriteFile(f, p, 0600); err != nil {
+			return nil, err
+		}
+		return priv, nil
+	} else if err != nil {
 		return nil, err
 	}
-	r.Header.Add("TradeBlocks-Register", hostURL)
-
-	// Execute get all blocks request
-	res, err := n.client.Do(r)
-	if err != nil {
-		return nil, err
+	p, _ := pem.Decode(b)
+	if p == nil {
+		return nil, fmt.Errorf("node: no key found in '%s'", f)
 	}
-	defer res.Body.Close()
-
-	// Decode response
-	return client.DecodeGetAccountBlocksResponse(res)
+	return x509.ParsePKCS1PrivateKey(p.Bytes)
 }
 
-func (n *Node) bootstrapSwaps(hostURL string, client *web.Client) (map[string]tradeblocks.NetworkSwapBlock, error) {
-	// Create get all blocks request and register this server
-	r, err := client.NewGetSwapBlocksRequest()
-	if err != nil {
-		return nil, err
-	}
-	r.Header.Add("TradeBlocks-Register", hostURL)
+// Bootstrap registers with the specified server and downloads all blocks in sequence order.
+// Blocks that are already in the store are skipped, so an interrupted bootstrap can be run again.
+func (n *Node) Bootstrap(hostURL, bootstrapURL string) error {
+	return n.BootstrapSince(hostURL, bootstrapURL, 0)
+}
 
-	// Execute get all blocks request
-	res, err := n.client.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+// BootstrapSince is like Bootstrap but only downloads blocks with a sequence greater than since on the
+// bootstrap server, such as the blocks after a snapshot of that server
+func (n *Node) BootstrapSince(hostURL, bootstrapURL string, since int) error {
+	n.hostURL = hostURL
 
-	// Decode response
-	return client.DecodeGetSwapBlocksResponse(res)
+	client := n.registerClient(bootstrapURL)
+	return client.BlockPages(client.Do, since, 0, func(page *web.BlocksPage) error {
+		for _, b := range page.Blocks {
+			if err := n.addBootstrapBlock(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (n *Node) bootstrapOrders(hostURL string, client *web.Client) (map[string]tradeblocks.NetworkOrderBlock, error) {
-	// Create get all blocks request and register this server
-	r, err := client.NewGetOrderBlocksRequest()
-	if err != nil {
-		return nil, err
-	}
-	r.Header.Add("TradeBlocks-Register", hostURL)
-
-	// Execute get all blocks request
-	res, err := n.client.Do(r)
-	if err != nil {
-		return nil, err
+// addBootstrapBlock adds the specified block unless it's already in the store
+func (n *Node) addBootstrapBlock(b tradeblocks.NetworkBlock) error {
+	if _, err := n.store.Block(b.Block.Hash()); err == nil {
+		return nil
+	} else if err != db.ErrNotFound {
+		return err
 	}
-	defer res.Body.Close()
-
-	// Decode response
-	return client.DecodeGetOrderBlocksResponse(res)
+	switch b := b.Block.(type) {
+	case *tradeblocks.AccountBlock:
+		return n.store.AddAccountBlock(b)
+	case *tradeblocks.SwapBlock:
+		return n.store.AddSwapBlock(b)
+	case *tradeblocks.OrderBlock:
+		return n.store.AddOrderBlock(b)
+	case *tradeblocks.ConfirmBlock:
+		return n.store.AddConfirmBlock(b)
+	}
+	return fmt.Errorf("node: unknown block type '%s'", b.Type)
 }
 
 func (n *Node) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	if addr := r.Header.Get("TradeBlocks-Register"); addr != "" {
 		if n.addPeer(addr) {
 			if err := n.register(addr); err != nil {
-				log.Println(err)
+				n.log.Warn("can't register with peer", "peer", addr, "error", err)
 			}
 		}
 	}
-	if r.URL.Path == "/address" {
-		n.handleAddress().ServeHTTP(rw, r)
-		return
-	}
 	n.server.ServeHTTP(rw, r)
 }
 
 func (n *Node) register(addr string) error {
-	c := web.NewClient(addr)
-	r, err := c.NewGetAccountBlocksRequest()
-	if err != nil {
-		return err
-	}
-	r.Header.Add("TradeBlocks-Register", n.hostURL)
-
-	// Execute get all blocks request
-	res, err := n.client.Do(r)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	// Decode response
 	// TODO add new blocks
-	_, err = c.DecodeGetAccountBlocksResponse(res)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (n *Node) handleAddress() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, n.address)
-	}
+	_, err := n.registerClient(addr).AccountBlocks(context.Background(), db.BlockFilter{Limit: 1})
+	return err
 }
 
 func (n *Node) handleBlock(b app.TypedBlock) {
 	// TODO don't broadcast if block already seen
-
-	// Save block
+	var block tradeblocks.Block
 	switch b.T {
 	case "account":
-		if err := n.server.BroadcastBlock(b.AccountBlock); err != nil {
-			log.Println(err)
-		}
-		if err := n.confirmBlock(b.AccountBlock); err != nil {
-			log.Printf("node: confirm error: %s", err.Error())
-		}
+		block = b.AccountBlock
 	case "swap":
-		if err := n.server.BroadcastBlock(b.SwapBlock); err != nil {
-			log.Println(err)
-		}
-		if err := n.confirmBlock(b.SwapBlock); err != nil {
-			log.Printf("node: confirm error: %s", err.Error())
-		}
+		block = b.SwapBlock
 	case "order":
-		if err := n.server.BroadcastBlock(b.OrderBlock); err != nil {
-			log.Println(err)
-		}
-		if err := n.confirmBlock(b.OrderBlock); err != nil {
-			log.Printf("node: confirm error: %s", err.Error())
+		block = b.OrderBlock
+	case "confirm":
+		block = b.ConfirmBlock
+	default:
+		return
+	}
+	l := n.log.With(logging.Block(block)...)
+
+	// Archive new blocks, including bootstrapped blocks and the confirm blocks of this node
+	if n.archive != nil {
+		if err := n.archive.Save(); err != nil {
+			l.Error("can't archive blocks", "error", err)
 		}
-		// TODO save confirm blocks
+	}
+
+	if err := n.server.BroadcastBlock(block); err != nil {
+		l.Error("can't broadcast block", "error", err)
+	}
+	if b.T == "confirm" {
+		return
+	}
+	if err := n.confirmBlock(block); err != nil {
+		l.Error("can't confirm block", "error", err)
 	}
 
 	// Check if block matches an open order
 	if b.T == "swap" {
 		if err := n.handleSwap(b.SwapBlock); err != nil {
-			log.Printf("node: swap error: %s", err.Error())
+			l.Warn("can't execute swap", "error", err)
 		}
 	}
 
 	// Broadcast to peers
 	for address := range n.peers {
-		c := web.NewClient(address)
-		switch b.T {
-		case "account":
-			req, err := c.NewPostAccountBlockRequest(b.AccountBlock)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			res, err := n.client.Do(req)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			defer res.Body.Close()
-			var rb tradeblocks.AccountBlock
-			if err := c.DecodeAccountBlockResponse(res, &rb); err != nil {
-				log.Println(err)
-				return
-			}
-			fmt.Println("synced " + b.AccountBlock.Hash() + " to " + address)
-		case "swap":
-			req, err := c.NewPostSwapBlockRequest(b.SwapBlock)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			res, err := n.client.Do(req)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			defer res.Body.Close()
-			var rb tradeblocks.SwapBlock
-			if err := c.DecodeSwapBlockResponse(res, &rb); err != nil {
-				log.Println(err)
-				return
-			}
-			fmt.Println("synced " + b.SwapBlock.Hash())
-		case "order":
-			req, err := c.NewPostOrderBlockRequest(b.OrderBlock)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			res, err := n.client.Do(req)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			defer res.Body.Close()
-			var rb tradeblocks.OrderBlock
-			if err := c.DecodeOrderBlockResponse(res, &rb); err != nil {
-				log.Println(err)
-				return
-			}
-			fmt.Println("synced " + b.OrderBlock.Hash())
+		if err := n.peerClient(address).PostBlock(context.Background(), block); err != nil {
+			broadcastFailures.Inc()
+			l.Warn("can't sync block to peer", "peer", address, "error", err)
+			continue
 		}
+		l.Debug("synced block to peer", "peer", address)
 	}
 }
 
+// peerClient returns a client for the node at the specified URL
+func (n *Node) peerClient(address string) *web.Client {
+	c := web.NewClient(address)
+	c.HTTPClient = n.client
+	return c
+}
+
+// registerClient returns a client for a peer whose requests register this node with it
+func (n *Node) registerClient(address string) *web.Client {
+	c := n.peerClient(address)
+	c.Header = http.Header{}
+	c.Header.Set("TradeBlocks-Register", n.hostURL)
+	return c
+}
+
 func (n *Node) handleSwap(b *tradeblocks.SwapBlock) error {
-	if b.Action == "offer" && b.Executor == n.address {
+	if b.Action == "offer" && b.Executor == n.address && n.executor {
+		if b.Fee < n.minFee {
+			return fmt.Errorf("node: offer fee '%f' is less than the minimum fee '%f'", b.Fee, n.minFee)
+		}
 		order, err := n.store.GetOrderHead(b.Counterparty, b.ID)
 		if err != nil {
 			return err
@@ -378,6 +315,8 @@ func (n *Node) handleSwap(b *tradeblocks.SwapBlock) error {
 		if err := n.store.AddSwapBlock(commit); err != nil {
 			return fmt.Errorf("error adding swap: %s", err.Error())
 		}
+		executorFills.Inc()
+		n.log.With(logging.Block(commit)...).Info("filled order", "order", send.Hash())
 		n.server.BlockHandler(app.TypedBlock{
 			SwapBlock: commit,
 			T:         "swap",
@@ -420,6 +359,7 @@ func (n *Node) addPeer(address string) bool {
 		return false
 	}
 	n.peers[address] = struct{}{}
+	peerCount.Inc()
 	return true
 
 }
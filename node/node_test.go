@@ -10,12 +10,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/jephir/tradeblocks/web"
 
 	tb "github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/fs"
 )
 
 func TestAddress(t *testing.T) {
@@ -331,3 +333,40 @@ func addBlockToNode(t *testing.T, url string, b app.TypedBlock) string {
 	}
 	panic("node: unknown type")
 }
+
+func TestArchive(t *testing.T) {
+	n, s := newNode(t, "")
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := n.SetArchive(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	key, address := app.CreateAccount(t)
+	issue, err := tb.SignedAccountBlock(tb.NewIssueBlock(address, 100), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.store.AddAccountBlock(issue); err != nil {
+		t.Fatal(err)
+	}
+	n.handleBlock(app.TypedBlock{
+		AccountBlock: issue,
+		T:            "account",
+	})
+
+	store := app.NewBlockStore()
+	if err := fs.NewBlockStorage(store, dir).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetAccountBlock(issue.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetConfirmHead(n.address, issue.Address()); err != nil {
+		t.Fatalf("expected archived confirm block: %s", err.Error())
+	}
+}
@@ -0,0 +1,322 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jephir/tradeblocks/app"
+)
+
+// pemType is the PEM block type of an encrypted wallet key
+const pemType = "ENCRYPTED RSA PRIVATE KEY"
+
+// iterations is the number of PBKDF2 iterations that derive the encryption key from a passphrase
+const iterations = 100000
+
+// ErrNotFound is returned when a wallet doesn't exist
+var ErrNotFound = errors.New("wallet: not found")
+
+// ErrPassphrase is returned when a wallet key can't be decrypted with a passphrase
+var ErrPassphrase = errors.New("wallet: wrong passphrase")
+
+// Wallet is a named key pair in a keystore
+type Wallet struct {
+	Name    string
+	Address string
+}
+
+// Keystore is a directory of wallets. Each wallet is stored in <name>.pem as a PEM block with the private key
+// encrypted by AES-256-GCM with a key derived from a passphrase by PBKDF2-SHA256. The address is stored in the
+// clear in a PEM header, so wallets can be listed without their passphrase.
+type Keystore struct {
+	dir string
+}
+
+// DefaultDir returns the keystore directory in the config directory of the current user, which is
+// $XDG_CONFIG_HOME or ~/.config
+func DefaultDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return "", errors.New("wallet: neither $XDG_CONFIG_HOME nor $HOME is set")
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "tradeblocks", "wallets"), nil
+}
+
+// NewKeystore returns a keystore in the specified directory, which is created when the first wallet is saved
+func NewKeystore(dir string) *Keystore {
+	return &Keystore{dir: dir}
+}
+
+// Dir returns the directory of this keystore
+func (k *Keystore) Dir() string {
+	return k.dir
+}
+
+// List returns every wallet in the keystore sorted by name
+func (k *Keystore) List() ([]Wallet, error) {
+	files, err := ioutil.ReadDir(k.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var wallets []Wallet
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".pem")
+		if file.IsDir() || name == file.Name() {
+			continue
+		}
+		w, err := k.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, *w)
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		return wallets[i].Name < wallets[j].Name
+	})
+	return wallets, nil
+}
+
+// Get returns the wallet with the specified name
+func (k *Keystore) Get(name string) (*Wallet, error) {
+	p, err := k.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{
+		Name:    name,
+		Address: p.Headers["Address"],
+	}, nil
+}
+
+// Create generates a new key pair and saves it as a wallet encrypted with the specified passphrase
+func (k *Keystore) Create(name, passphrase string, keySize int) (*Wallet, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, err
+	}
+	return k.Add(name, priv, passphrase)
+}
+
+// Add saves the specified private key as a wallet encrypted with the specified passphrase
+func (k *Keystore) Add(name string, priv *rsa.PrivateKey, passphrase string) (*Wallet, error) {
+	p, err := Encrypt(priv, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.write(name, p); err != nil {
+		return nil, err
+	}
+	return k.Get(name)
+}
+
+// Import saves a wallet from a PEM file. An encrypted wallet key, such as one written by Export, is saved as
+// it is if it can be decrypted with the passphrase. An unencrypted RSA private key is encrypted with the
+// passphrase.
+func (k *Keystore) Import(name string, data []byte, passphrase string) (*Wallet, error) {
+	p, _ := pem.Decode(data)
+	if p == nil {
+		return nil, errors.New("wallet: no PEM data found")
+	}
+	switch p.Type {
+	case pemType:
+		if _, err := Decrypt(p, passphrase); err != nil {
+			return nil, err
+		}
+		if err := k.write(name, p); err != nil {
+			return nil, err
+		}
+		return k.Get(name)
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return k.Add(name, priv, passphrase)
+	}
+	return nil, fmt.Errorf("wallet: unsupported PEM type '%s'", p.Type)
+}
+
+// Export returns the encrypted PEM file of the wallet with the specified name
+func (k *Keystore) Export(name string) ([]byte, error) {
+	p, err := k.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(p), nil
+}
+
+// PrivateKey decrypts the private key of the wallet with the specified name
+func (k *Keystore) PrivateKey(name, passphrase string) (*rsa.PrivateKey, error) {
+	p, err := k.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(p, passphrase)
+}
+
+func (k *Keystore) read(name string) (*pem.Block, error) {
+	f, err := k.path(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	p, _ := pem.Decode(b)
+	if p == nil || p.Type != pemType {
+		return nil, fmt.Errorf("wallet: no encrypted key found in '%s'", f)
+	}
+	return p, nil
+}
+
+// write saves a new wallet file, which can only be read by the current user
+func (k *Keystore) write(name string, p *pem.Block) error {
+	f, err := k.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(k.dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("wallet: '%s' already exists", name)
+	} else if err != nil {
+		return err
+	}
+	if err := pem.Encode(file, p); err != nil {
+		file.Close()
+		os.Remove(f)
+		return err
+	}
+	return file.Close()
+}
+
+func (k *Keystore) path(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("wallet: invalid name '%s'", name)
+	}
+	return filepath.Join(k.dir, name+".pem"), nil
+}
+
+// Encrypt returns a PEM block with the specified private key encrypted with the passphrase
+func Encrypt(priv *rsa.PrivateKey, passphrase string) (*pem.Block, error) {
+	address, err := app.PrivateKeyToAddress(priv)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &pem.Block{
+		Type: pemType,
+		Headers: map[string]string{
+			"Address":    address,
+			"KDF":        "pbkdf2-sha256",
+			"Iterations": strconv.Itoa(iterations),
+			"Salt":       hex.EncodeToString(salt),
+			"Cipher":     "aes-256-gcm",
+			"Nonce":      hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, x509.MarshalPKCS1PrivateKey(priv), []byte(address)),
+	}, nil
+}
+
+// Decrypt returns the private key of a PEM block written by Encrypt
+func Decrypt(p *pem.Block, passphrase string) (*rsa.PrivateKey, error) {
+	if p.Headers["KDF"] != "pbkdf2-sha256" || p.Headers["Cipher"] != "aes-256-gcm" {
+		return nil, fmt.Errorf("wallet: unsupported encryption '%s' with '%s'", p.Headers["Cipher"], p.Headers["KDF"])
+	}
+	n, err := strconv.Atoi(p.Headers["Iterations"])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("wallet: invalid iterations '%s'", p.Headers["Iterations"])
+	}
+	salt, err := hex.DecodeString(p.Headers["Salt"])
+	if err != nil {
+		return nil, errors.New("wallet: invalid salt")
+	}
+	nonce, err := hex.DecodeString(p.Headers["Nonce"])
+	if err != nil {
+		return nil, errors.New("wallet: invalid nonce")
+	}
+	aead, err := newAEAD(passphrase, salt, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("wallet: invalid nonce")
+	}
+	// The address is authenticated, so a wallet can't be relabeled with another address
+	b, err := aead.Open(nil, nonce, p.Bytes, []byte(p.Headers["Address"]))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return x509.ParsePKCS1PrivateKey(b)
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key of the specified length from a password with PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	var counter [4]byte
+	for i := uint32(1); len(key) < keyLen; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for j := 1; j < iterations; j++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for k := range t {
+				t[k] ^= u[k]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k := NewKeystore(dir)
+
+	w, err := k.Create("alice", "secret", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Create("alice", "secret", 1024); err == nil {
+		t.Fatal("expected an error for an existing wallet")
+	}
+	if _, err := k.Create("../alice", "secret", 1024); err == nil {
+		t.Fatal("expected an error for an invalid name")
+	}
+	if _, err := k.PrivateKey("alice", "wrong"); err != ErrPassphrase {
+		t.Fatalf("expected %v, got %v", ErrPassphrase, err)
+	}
+	if _, err := k.PrivateKey("bob", "secret"); err != ErrNotFound {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	if _, err := k.PrivateKey("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Export and import into another keystore
+	data, err := k.Export("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewKeystore(dir + "/other")
+	if _, err := other.Import("alice", data, "wrong"); err != ErrPassphrase {
+		t.Fatalf("expected %v, got %v", ErrPassphrase, err)
+	}
+	imported, err := other.Import("alice", data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Address != w.Address {
+		t.Fatalf("expected address %s, got %s", w.Address, imported.Address)
+	}
+
+	// Import an unencrypted key
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	})
+	if _, err := k.Import("bob", plain, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	data, err = k.Export("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := pem.Decode(data); p == nil || p.Type != pemType {
+		t.Fatal("expected the imported key to be encrypted")
+	}
+
+	wallets, err := k.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wallets) != 2 || wallets[0].Name != "alice" || wallets[1].Name != "bob" {
+		t.Fatalf("expected wallets alice and bob, got %+v", wallets)
+	}
+	if wallets[0].Address != w.Address {
+		t.Fatalf("expected address %s, got %s", w.Address, wallets[0].Address)
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// Test vector from RFC 7914 section 11
+	expect := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)); got != expect {
+		t.Fatalf("expected %s, got %s", expect, got)
+	}
+}
+
+func TestDefaultDir(t *testing.T) {
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+
+	os.Setenv("XDG_CONFIG_HOME", "/config")
+	os.Setenv("HOME", "/home/test")
+	if dir, err := DefaultDir(); err != nil || dir != filepath.Join("/config", "tradeblocks", "wallets") {
+		t.Fatalf("expected keystore in $XDG_CONFIG_HOME, got %s (%v)", dir, err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", "")
+	if dir, err := DefaultDir(); err != nil || dir != filepath.Join("/home/test", ".config", "tradeblocks", "wallets") {
+		t.Fatalf("expected keystore in ~/.config, got %s (%v)", dir, err)
+	}
+}
@@ -10,19 +10,60 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
 	"github.com/jephir/tradeblocks/db"
 )
 
 // Client communicates with a TradeBlocks server
 type Client struct {
 	base string
+
+	// HTTPClient executes requests, or http.DefaultClient if it's nil
+	HTTPClient *http.Client
+
+	// Timeout limits each attempt of a request, or zero for no limit
+	Timeout time.Duration
+
+	// Retries is how many times a failed request that is safe to repeat is retried
+	Retries int
+
+	// RetryDelay is the wait before the first retry, which doubles for every retry after it
+	RetryDelay time.Duration
+
+	// Header is added to every request executed by Do, such as TradeBlocks-Register to register with the node
+	Header http.Header
 }
 
 // NewClient allocates and returns a new client with the specified node URL
 func NewClient(url string) *Client {
-	return &Client{base: url}
+	return &Client{
+		base:       url,
+		Timeout:    defaultTimeout,
+		Retries:    defaultRetries,
+		RetryDelay: defaultRetryDelay,
+	}
+}
+
+// StatusError is returned for a response with an unexpected status that isn't a validation error
+type StatusError struct {
+	StatusCode int
+	URL        string // empty if it's unknown
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.URL != "" {
+		return fmt.Sprintf("client: unexpected status %d on %s: %s", e.StatusCode, e.URL, e.Body)
+	}
+	return fmt.Sprintf("client: unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary returns whether the request may succeed if it's retried
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
 }
 
 // NewPostAccountBlockRequest returns an http.Request to send the specified block
@@ -79,39 +120,261 @@ func (c *Client) NewPostOrderBlockRequest(b *tradeblocks.OrderBlock) (r *http.Re
 	return
 }
 
-// NewGetBlocksRequest returns an http.Request to get all blocks
-func (c *Client) NewGetBlocksRequest() (r *http.Request, err error) {
-	r, err = c.newRequest("GET", "/blocks", nil)
+// NewPostBatchRequest returns an http.Request to add a batch of blocks in order, all or none of them. Each block
+// needs its Type. If dryRun is true, the batch is validated but not added.
+func (c *Client) NewPostBatchRequest(blocks []tradeblocks.NetworkBlock, dryRun bool) (r *http.Request, err error) {
+	var buf bytes.Buffer
+	err = json.NewEncoder(&buf).Encode(blocks)
+	if err != nil {
+		return
+	}
+	r, err = c.newRequest("POST", "/blocks/batch", &buf)
+	if err != nil {
+		return
+	}
+	if dryRun {
+		q := r.URL.Query()
+		q.Add("dry_run", "true")
+		r.URL.RawQuery = q.Encode()
+	}
+	r.Header.Set("Content-Type", "application/json")
 	return
 }
 
-// NewGetAccountBlocksRequest returns an http.Request to get all account blocks
-func (c *Client) NewGetAccountBlocksRequest() (r *http.Request, err error) {
-	r, err = c.newRequest("GET", "/blocks", nil)
+// NewValidateRequest returns an http.Request to validate the specified block of type t ("account", "swap" or
+// "order") without adding it
+func (c *Client) NewValidateRequest(t string, b tradeblocks.Block) (r *http.Request, err error) {
+	var buf bytes.Buffer
+	err = json.NewEncoder(&buf).Encode(b)
+	if err != nil {
+		return
+	}
+	r, err = c.newRequest("POST", "/validate", &buf)
+	if err != nil {
+		return
+	}
 	q := r.URL.Query()
-	q.Add("type", "account")
+	q.Add("type", t)
 	r.URL.RawQuery = q.Encode()
+	r.Header.Set("Content-Type", "application/json")
 	return
 }
 
-// NewGetSwapBlocksRequest returns an http.Request to get all swap blocks
-func (c *Client) NewGetSwapBlocksRequest() (r *http.Request, err error) {
+// NewGetBlocksRequest returns an http.Request to get a page of blocks of every type with a sequence greater than since
+func (c *Client) NewGetBlocksRequest(since, limit int) (r *http.Request, err error) {
 	r, err = c.newRequest("GET", "/blocks", nil)
+	if err != nil {
+		return
+	}
 	q := r.URL.Query()
-	q.Add("type", "swap")
+	if since > 0 {
+		q.Add("since", strconv.Itoa(since))
+	}
+	if limit > 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
 	r.URL.RawQuery = q.Encode()
 	return
 }
 
-// NewGetOrderBlocksRequest returns an http.Request to get all order blocks
-func (c *Client) NewGetOrderBlocksRequest() (r *http.Request, err error) {
+// BlockPages calls fn with every page of blocks with a sequence greater than since. Requests are executed with do.
+func (c *Client) BlockPages(do func(*http.Request) (*http.Response, error), since, limit int, fn func(page *BlocksPage) error) error {
+	for {
+		r, err := c.NewGetBlocksRequest(since, limit)
+		if err != nil {
+			return err
+		}
+		res, err := do(r)
+		if err != nil {
+			return err
+		}
+		page, err := c.DecodeGetBlocksResponse(res)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.Next == 0 {
+			return nil
+		}
+		since = page.Next
+	}
+}
+
+// NewExportRequest returns an http.Request to export every block with a sequence greater than since
+func (c *Client) NewExportRequest(since int) (r *http.Request, err error) {
+	r, err = c.newRequest("GET", "/export", nil)
+	if err != nil {
+		return
+	}
+	if since > 0 {
+		q := r.URL.Query()
+		q.Add("since", strconv.Itoa(since))
+		r.URL.RawQuery = q.Encode()
+	}
+	return
+}
+
+// Export calls fn with every exported block with a sequence greater than since and returns the sequence of the
+// last record that fn accepted, so an interrupted export can be resumed from it. Requests are executed with do.
+func (c *Client) Export(do func(*http.Request) (*http.Response, error), since int, fn func(e *ExportRecord) error) (cursor int, err error) {
+	cursor = since
+	r, err := c.NewExportRequest(since)
+	if err != nil {
+		return
+	}
+	res, err := do(r)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if err = c.checkResponse(res); err != nil {
+		return
+	}
+	last, err := strconv.Atoi(res.Header.Get("TradeBlocks-Last-Sequence"))
+	if err != nil {
+		return cursor, fmt.Errorf("client: export has no valid last sequence: %s", err.Error())
+	}
+	dec := json.NewDecoder(res.Body)
+	for {
+		var e ExportRecord
+		if err = dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+		if err = fn(&e); err != nil {
+			return
+		}
+		cursor = e.Sequence
+	}
+	if cursor < last {
+		return cursor, fmt.Errorf("client: export ended at sequence %d before the last sequence %d", cursor, last)
+	}
+	return cursor, nil
+}
+
+// NewGetAccountBlocksRequest returns an http.Request to get a page of account blocks matching the specified filter
+func (c *Client) NewGetAccountBlocksRequest(f db.BlockFilter) (r *http.Request, err error) {
+	return c.newGetBlocksRequest("account", f)
+}
+
+// NewGetSwapBlocksRequest returns an http.Request to get a page of swap blocks matching the specified filter
+func (c *Client) NewGetSwapBlocksRequest(f db.BlockFilter) (r *http.Request, err error) {
+	return c.newGetBlocksRequest("swap", f)
+}
+
+// NewGetOrderBlocksRequest returns an http.Request to get a page of order blocks matching the specified filter
+func (c *Client) NewGetOrderBlocksRequest(f db.BlockFilter) (r *http.Request, err error) {
+	return c.newGetBlocksRequest("order", f)
+}
+
+func (c *Client) newGetBlocksRequest(t string, f db.BlockFilter) (r *http.Request, err error) {
 	r, err = c.newRequest("GET", "/blocks", nil)
+	if err != nil {
+		return
+	}
 	q := r.URL.Query()
-	q.Add("type", "order")
+	q.Add("type", t)
+	if f.Account != "" {
+		q.Add("account", strings.TrimSpace(f.Account))
+	}
+	if f.Token != "" {
+		q.Add("token", strings.TrimSpace(f.Token))
+	}
+	if f.Action != "" {
+		q.Add("action", strings.TrimSpace(f.Action))
+	}
+	if f.Since > 0 {
+		q.Add("since", strconv.Itoa(f.Since))
+	}
+	if f.Limit > 0 {
+		q.Add("limit", strconv.Itoa(f.Limit))
+	}
 	r.URL.RawQuery = q.Encode()
 	return
 }
 
+// AccountBlockPages calls fn with every page of account blocks matching the specified filter. Requests are executed with do.
+func (c *Client) AccountBlockPages(do func(*http.Request) (*http.Response, error), f db.BlockFilter, fn func(page *AccountBlocksPage) error) error {
+	for {
+		r, err := c.NewGetAccountBlocksRequest(f)
+		if err != nil {
+			return err
+		}
+		res, err := do(r)
+		if err != nil {
+			return err
+		}
+		page, err := c.DecodeGetAccountBlocksResponse(res)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.Next == 0 {
+			return nil
+		}
+		f.Since = page.Next
+	}
+}
+
+// SwapBlockPages calls fn with every page of swap blocks matching the specified filter. Requests are executed with do.
+func (c *Client) SwapBlockPages(do func(*http.Request) (*http.Response, error), f db.BlockFilter, fn func(page *SwapBlocksPage) error) error {
+	for {
+		r, err := c.NewGetSwapBlocksRequest(f)
+		if err != nil {
+			return err
+		}
+		res, err := do(r)
+		if err != nil {
+			return err
+		}
+		page, err := c.DecodeGetSwapBlocksResponse(res)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.Next == 0 {
+			return nil
+		}
+		f.Since = page.Next
+	}
+}
+
+// OrderBlockPages calls fn with every page of order blocks matching the specified filter. Requests are executed with do.
+func (c *Client) OrderBlockPages(do func(*http.Request) (*http.Response, error), f db.BlockFilter, fn func(page *OrderBlocksPage) error) error {
+	for {
+		r, err := c.NewGetOrderBlocksRequest(f)
+		if err != nil {
+			return err
+		}
+		res, err := do(r)
+		if err != nil {
+			return err
+		}
+		page, err := c.DecodeGetOrderBlocksResponse(res)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.Next == 0 {
+			return nil
+		}
+		f.Since = page.Next
+	}
+}
+
 // NewGetBlockRequest returns an http.Request to get a block by hash
 func (c *Client) NewGetBlockRequest(hash string) (r *http.Request, err error) {
 	hash = strings.TrimSpace(hash)
@@ -209,6 +472,28 @@ func (c *Client) NewGetSellOrdersRequest(base string, ppu float64, quote string)
 	return
 }
 
+// NewGetHistoryRequest returns an http.Request to get a page of an account-token chain, starting at the head if cursor is empty
+func (c *Client) NewGetHistoryRequest(account, token, cursor string, limit int) (r *http.Request, err error) {
+	account = strings.TrimSpace(account)
+	token = strings.TrimSpace(token)
+	cursor = strings.TrimSpace(cursor)
+	r, err = c.newRequest("GET", "/history", nil)
+	if err != nil {
+		return
+	}
+	q := r.URL.Query()
+	q.Add("account", account)
+	q.Add("token", token)
+	if cursor != "" {
+		q.Add("cursor", cursor)
+	}
+	if limit > 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
+	r.URL.RawQuery = q.Encode()
+	return
+}
+
 // NewGetAddressRequest returns the address (public key) of the node
 func (c *Client) NewGetAddressRequest() (r *http.Request, err error) {
 	r, err = c.newRequest("GET", "/address", nil)
@@ -239,6 +524,45 @@ func (c *Client) DecodeOrderBlockResponse(res *http.Response, result *tradeblock
 	return json.NewDecoder(res.Body).Decode(&result)
 }
 
+// DecodeBatchResponse returns the hashes of the blocks of a batch request. If a block is invalid, the error is an
+// *app.BatchError with the *app.ValidationError of the block.
+func (c *Client) DecodeBatchResponse(res *http.Response) ([]string, error) {
+	if res.StatusCode == http.StatusBadRequest && strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		var f batchFailure
+		if err := json.NewDecoder(res.Body).Decode(&f); err != nil {
+			return nil, err
+		}
+		if f.Error == nil {
+			return nil, fmt.Errorf("client: batch failed at block %d (%s)", f.Index, f.Hash)
+		}
+		return nil, &app.BatchError{
+			Index: f.Index,
+			Hash:  f.Hash,
+			Err:   f.Error,
+		}
+	}
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
+	}
+	var result BatchResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Hashes, nil
+}
+
+// DecodeValidateResponse returns the result of a validate request
+func (c *Client) DecodeValidateResponse(res *http.Response) (*ValidateResult, error) {
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
+	}
+	var result ValidateResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // DecodeBlockResponse returns the result of any block request
 func (c *Client) DecodeBlockResponse(res *http.Response) (tradeblocks.Block, error) {
 	if err := c.checkResponse(res); err != nil {
@@ -278,43 +602,51 @@ func (c *Client) DecodeBlockResponse(res *http.Response) (tradeblocks.Block, err
 }
 
 // DecodeGetBlocksResponse returns the result of a blocks request
-func (c *Client) DecodeGetBlocksResponse(res *http.Response) (result []tradeblocks.Block, err error) {
-	err = c.checkResponse(res)
-	if err != nil {
-		return
+func (c *Client) DecodeGetBlocksResponse(res *http.Response) (*BlocksPage, error) {
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
 	}
-	err = json.NewDecoder(res.Body).Decode(&result)
-	return
+	var result BlocksPage
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
 
-// DecodeGetAccountBlocksResponse returns the result of a blocks request
-func (c *Client) DecodeGetAccountBlocksResponse(res *http.Response) (result map[string]tradeblocks.NetworkAccountBlock, err error) {
-	err = c.checkResponse(res)
-	if err != nil {
-		return
+// DecodeGetAccountBlocksResponse returns the result of an account blocks request
+func (c *Client) DecodeGetAccountBlocksResponse(res *http.Response) (*AccountBlocksPage, error) {
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
 	}
-	err = json.NewDecoder(res.Body).Decode(&result)
-	return
+	var result AccountBlocksPage
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
 
-// DecodeGetSwapBlocksResponse returns the result of a blocks request
-func (c *Client) DecodeGetSwapBlocksResponse(res *http.Response) (result map[string]tradeblocks.NetworkSwapBlock, err error) {
-	err = c.checkResponse(res)
-	if err != nil {
-		return
+// DecodeGetSwapBlocksResponse returns the result of a swap blocks request
+func (c *Client) DecodeGetSwapBlocksResponse(res *http.Response) (*SwapBlocksPage, error) {
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
 	}
-	err = json.NewDecoder(res.Body).Decode(&result)
-	return
+	var result SwapBlocksPage
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
 
-// DecodeGetOrderBlocksResponse returns the result of a blocks request
-func (c *Client) DecodeGetOrderBlocksResponse(res *http.Response) (result map[string]tradeblocks.NetworkOrderBlock, err error) {
-	err = c.checkResponse(res)
-	if err != nil {
-		return
+// DecodeGetOrderBlocksResponse returns the result of an order blocks request
+func (c *Client) DecodeGetOrderBlocksResponse(res *http.Response) (*OrderBlocksPage, error) {
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
 	}
-	err = json.NewDecoder(res.Body).Decode(&result)
-	return
+	var result OrderBlocksPage
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
 
 // DecodeGetOrdersArrayResponse returns the result of a blocks request
@@ -329,6 +661,18 @@ func (c *Client) DecodeGetOrdersArrayResponse(res *http.Response) ([]*tradeblock
 	return result, nil
 }
 
+// DecodeGetHistoryResponse returns the result of a history request
+func (c *Client) DecodeGetHistoryResponse(res *http.Response) (*app.AccountHistory, error) {
+	if err := c.checkResponse(res); err != nil {
+		return nil, err
+	}
+	var result app.AccountHistory
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // DecodeGetAddressResponse returns the result of a get address request
 func (c *Client) DecodeGetAddressResponse(res *http.Response) (string, error) {
 	if err := c.checkResponse(res); err != nil {
@@ -351,17 +695,28 @@ func (c *Client) newRequest(method, path string, body io.Reader) (r *http.Reques
 	return
 }
 
+// checkResponse returns an error for a response that is not OK, which is an *app.ValidationError for an invalid
+// block and a *StatusError otherwise
 func (c *Client) checkResponse(res *http.Response) error {
 	if res.StatusCode != http.StatusOK {
 		b, err := ioutil.ReadAll(res.Body)
 		if err != nil {
 			return err
 		}
+		if res.StatusCode == http.StatusBadRequest && strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+			var e app.ValidationError
+			if err := json.Unmarshal(b, &e); err == nil && e.Code != "" {
+				return &e
+			}
+		}
+		e := &StatusError{
+			StatusCode: res.StatusCode,
+			Body:       string(b),
+		}
 		if res.Request != nil {
-			return fmt.Errorf("client: unexpected status %d on %s: %s", res.StatusCode, res.Request.URL.String(), string(b))
-		} else {
-			return fmt.Errorf("client: unexpected status %d: %s", res.StatusCode, string(b))
+			e.URL = res.Request.URL.String()
 		}
+		return e
 	}
 	return nil
 }
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/jephir/tradeblocks/metrics"
+)
+
+// clientSet is the set of connected clients of one kind, whose count and queued messages are reported as metrics
+type clientSet struct {
+	mu      sync.Mutex
+	clients map[interface{}]func() int // returns the number of messages queued for the client
+}
+
+var (
+	sseClients    = newClientSet("sse", "block event listeners", "events")
+	streamClients = newClientSet("stream", "WebSocket stream clients", "messages")
+)
+
+func newClientSet(kind, clients, messages string) *clientSet {
+	s := &clientSet{clients: make(map[interface{}]func() int)}
+	metrics.Default.NewGaugeFunc("tradeblocks_"+kind+"_clients", "Connected "+clients+".", s.count)
+	metrics.Default.NewGaugeFunc("tradeblocks_"+kind+"_queued_"+messages, "Pending "+messages+" queued for connected "+clients+".", s.queued)
+	return s
+}
+
+func (s *clientSet) add(c interface{}, queued func() int) {
+	s.mu.Lock()
+	s.clients[c] = queued
+	s.mu.Unlock()
+}
+
+func (s *clientSet) remove(c interface{}) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+}
+
+func (s *clientSet) count() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return float64(len(s.clients))
+}
+
+func (s *clientSet) queued() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, queued := range s.clients {
+		n += queued()
+	}
+	return float64(n)
+}
+
+func (s *Server) handleMetrics() http.HandlerFunc {
+	return metrics.Default.ServeHTTP
+}
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+)
+
+func TestMetrics(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	ts := httptest.NewServer(NewServer(app.NewBlockStore()))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	ctx := context.Background()
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PostBlock(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 200), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PostBlock(ctx, send); err == nil {
+		t.Fatal("expected an error for a negative balance")
+	}
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`tradeblocks_blocks_accepted_total{type="account"} `,
+		`tradeblocks_blocks_rejected_total{type="account",code="negative_balance"} `,
+		`tradeblocks_validation_duration_seconds_count{type="account"} `,
+		`tradeblocks_db_transaction_duration_seconds_count{mode="write",result="commit"} `,
+		"tradeblocks_sse_clients ",
+		"tradeblocks_stream_queued_messages ",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("expected %q in metrics:\n%s", s, b)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+)
+
+// The HTTP API is described by apiPaths. Server routes are registered from it, requests are validated against it,
+// and /openapi.json is generated from it, so handlers can't be added without documenting them.
+
+// apiPath is a path of the HTTP API
+type apiPath struct {
+	path       string
+	operations []apiOperation
+}
+
+// apiOperation is a method of a path
+type apiOperation struct {
+	method      string
+	summary     string
+	params      []apiParam
+	body        interface{} // value of the JSON request body type, nil if there's none
+	result      interface{} // value of the response body type
+	contentType string      // content type of the response, application/json if empty
+	status      int         // status of a successful response, 200 if zero
+	notFound    bool        // whether the operation responds with 404 if nothing is found
+}
+
+// apiParam is a param of an operation
+type apiParam struct {
+	name        string
+	kind        string // "string", "integer", "number" or "boolean"
+	required    bool
+	enum        []string
+	header      bool // whether it's a header instead of a query param
+	description string
+}
+
+// oneOf is a result or body that is one of several types
+type oneOf []interface{}
+
+var (
+	blockTypes        = oneOf{tradeblocks.AccountBlock{}, tradeblocks.SwapBlock{}, tradeblocks.OrderBlock{}, tradeblocks.ConfirmBlock{}}
+	addableBlockTypes = oneOf{tradeblocks.AccountBlock{}, tradeblocks.SwapBlock{}, tradeblocks.OrderBlock{}}
+	addableTypeParam  = apiParam{name: "type", kind: "string", required: true, enum: []string{"account", "swap", "order"}, description: "Type of the block"}
+)
+
+var apiPaths = []apiPath{
+	{"/block", []apiOperation{
+		{
+			method:   "GET",
+			summary:  "Get a block by hash. The TradeBlocks-Tag header has the type of the block.",
+			params:   []apiParam{{name: "hash", kind: "string", required: true}},
+			result:   blockTypes,
+			notFound: true,
+		},
+		{
+			method:  "POST",
+			summary: "Add a block and broadcast it",
+			params:  []apiParam{addableTypeParam},
+			body:    addableBlockTypes,
+			result:  addableBlockTypes,
+		},
+	}},
+	{"/validate", []apiOperation{{
+		method:  "POST",
+		summary: "Validate a block without adding it",
+		params:  []apiParam{addableTypeParam},
+		body:    addableBlockTypes,
+		result:  ValidateResult{},
+	}}},
+	{"/blocks", []apiOperation{{
+		method:  "GET",
+		summary: "Get a page of blocks in sequence order, or with stream, new blocks as server-sent events",
+		params: []apiParam{
+			{name: "type", kind: "string", enum: []string{"account", "swap", "order"}, description: "Only blocks of this type, required by account, token and action"},
+			{name: "account", kind: "string"},
+			{name: "token", kind: "string"},
+			{name: "action", kind: "string"},
+			{name: "since", kind: "integer", description: "Only blocks with a greater sequence"},
+			{name: "limit", kind: "integer", description: "Page size, up to " + strconv.Itoa(maxBlocksLimit)},
+			{name: "stream", kind: "boolean", description: "Send server-sent events instead of a page"},
+			{name: "last_event_id", kind: "integer", description: "Also stream the blocks in the store with a greater sequence"},
+			{name: "Last-Event-ID", kind: "integer", header: true, description: "Also stream the blocks in the store with a greater sequence"},
+		},
+		result: oneOf{BlocksPage{}, AccountBlocksPage{}, SwapBlocksPage{}, OrderBlocksPage{}},
+	}}},
+	{"/blocks/batch", []apiOperation{{
+		method:  "POST",
+		summary: "Add a batch of up to " + strconv.Itoa(maxBatchSize) + " blocks in order, all or none of them",
+		params:  []apiParam{{name: "dry_run", kind: "boolean", description: "Only validate the batch"}},
+		body:    []tradeblocks.NetworkBlock{},
+		result:  BatchResult{},
+	}}},
+	{"/stream", []apiOperation{{
+		method:  "GET",
+		summary: "Open a WebSocket to subscribe to blocks and submit blocks",
+		status:  http.StatusSwitchingProtocols,
+	}}},
+	{"/rpc", []apiOperation{{
+		method:  "POST",
+		summary: "Make a JSON-RPC 2.0 call or batch of calls",
+		body:    oneOf{RPCRequest{}, []RPCRequest{}},
+		result:  oneOf{RPCResponse{}, []RPCResponse{}},
+	}}},
+	{"/head", []apiOperation{{
+		method:  "GET",
+		summary: "Get the head of a chain",
+		params: []apiParam{
+			addableTypeParam,
+			{name: "account", kind: "string", required: true},
+			{name: "token", kind: "string", description: "Token of an account chain"},
+			{name: "id", kind: "string", description: "ID of a swap or order chain"},
+		},
+		result:   addableBlockTypes,
+		notFound: true,
+	}}},
+	{"/orders", []apiOperation{{
+		method:  "GET",
+		summary: "Get the orders that match a buy or sell",
+		params: []apiParam{
+			{name: "side", kind: "string", required: true, enum: []string{"buy", "sell"}},
+			{name: "base", kind: "string", required: true},
+			{name: "ppu", kind: "number", required: true, description: "Price per unit of base in quote"},
+			{name: "quote", kind: "string", required: true},
+		},
+		result: []tradeblocks.OrderBlock{},
+	}}},
+	{"/history", []apiOperation{{
+		method:  "GET",
+		summary: "Get a page of an account-token chain, newest block first",
+		params: []apiParam{
+			{name: "account", kind: "string", required: true},
+			{name: "token", kind: "string", required: true},
+			{name: "cursor", kind: "string", description: "Next of the previous page"},
+			{name: "limit", kind: "integer", description: "Page size, up to " + strconv.Itoa(maxHistoryLimit)},
+		},
+		result:   app.AccountHistory{},
+		notFound: true,
+	}}},
+	{"/export", []apiOperation{{
+		method:      "GET",
+		summary:     "Export blocks in sequence order as newline-delimited JSON",
+		params:      []apiParam{{name: "since", kind: "integer", description: "Only blocks with a greater sequence"}},
+		result:      ExportRecord{},
+		contentType: "application/x-ndjson",
+	}}},
+	{"/address", []apiOperation{{
+		method:      "GET",
+		summary:     "Get the address of the node",
+		result:      "",
+		contentType: "text/plain",
+	}}},
+	{"/metrics", []apiOperation{{
+		method:      "GET",
+		summary:     "Get the metrics of the node in the Prometheus text format",
+		result:      "",
+		contentType: "text/plain",
+	}}},
+	{"/openapi.json", []apiOperation{{
+		method:  "GET",
+		summary: "Get this document",
+		result:  map[string]interface{}{},
+	}}},
+}
+
+// operation returns the operation of a path for the specified method, or nil if there's none
+func (p apiPath) operation(method string) *apiOperation {
+	for i := range p.operations {
+		if p.operations[i].method == method {
+			return &p.operations[i]
+		}
+	}
+	return nil
+}
+
+// check returns an error if the query has unknown params, is missing a required param or has an invalid value
+func (op *apiOperation) check(q url.Values) error {
+	var names []string
+	known := make(map[string]bool)
+	for _, p := range op.params {
+		if !p.header {
+			names = append(names, p.name)
+			known[p.name] = true
+		}
+	}
+	for name := range q {
+		if !known[name] {
+			if len(names) == 0 {
+				return fmt.Errorf("unknown query param '%s'", name)
+			}
+			return fmt.Errorf("unknown query param '%s'; expected %s", name, strings.Join(names, ", "))
+		}
+	}
+	for _, p := range op.params {
+		if p.header {
+			continue
+		}
+		v := q.Get(p.name)
+		if v == "" {
+			if p.required {
+				return fmt.Errorf("missing query param '%s'", p.name)
+			}
+			continue
+		}
+		var err error
+		switch p.kind {
+		case "integer":
+			_, err = strconv.Atoi(v)
+		case "number":
+			_, err = strconv.ParseFloat(v, 64)
+		case "boolean":
+			_, err = strconv.ParseBool(v)
+		}
+		if err != nil {
+			return fmt.Errorf("query param '%s' must be %s %s", p.name, article(p.kind), p.kind)
+		}
+		if len(p.enum) > 0 && !contains(p.enum, v) {
+			return fmt.Errorf("query param '%s' must be one of %s", p.name, strings.Join(p.enum, ", "))
+		}
+	}
+	return nil
+}
+
+func article(s string) string {
+	if strings.ContainsAny(s[:1], "aeiou") {
+		return "an"
+	}
+	return "a"
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRequest rejects requests with a method or query params that aren't in the spec of the path
+func (s *Server) validateRequest(p apiPath, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op := p.operation(r.Method)
+		if op == nil {
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := op.check(r.URL.Query()); err != nil {
+			s.serverError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleOpenAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec, err := openAPISpec()
+		if err != nil {
+			s.serverError(w, "error encoding spec: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+var (
+	specOnce sync.Once
+	spec     []byte
+	specErr  error
+)
+
+// openAPISpec returns the OpenAPI 3 document of the HTTP API
+func openAPISpec() ([]byte, error) {
+	specOnce.Do(func() {
+		spec, specErr = json.MarshalIndent(newOpenAPISpec(), "", "  ")
+	})
+	return spec, specErr
+}
+
+func newOpenAPISpec() map[string]interface{} {
+	b := &schemaBuilder{schemas: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+	for _, p := range apiPaths {
+		ops := make(map[string]interface{})
+		for _, op := range p.operations {
+			ops[strings.ToLower(op.method)] = b.operation(op)
+		}
+		paths[p.path] = ops
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "TradeBlocks node API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+		},
+	}
+}
+
+// schemaBuilder makes OpenAPI schemas of Go types, and collects the schemas of named types as components
+type schemaBuilder struct {
+	schemas map[string]interface{}
+}
+
+func (b *schemaBuilder) operation(op apiOperation) map[string]interface{} {
+	result := map[string]interface{}{
+		"summary": op.summary,
+	}
+	var params []interface{}
+	for _, p := range op.params {
+		schema := map[string]interface{}{"type": p.kind}
+		if len(p.enum) > 0 {
+			schema["enum"] = p.enum
+		}
+		param := map[string]interface{}{
+			"name":     p.name,
+			"in":       "query",
+			"required": p.required,
+			"schema":   schema,
+		}
+		if p.header {
+			param["in"] = "header"
+		}
+		if p.description != "" {
+			param["description"] = p.description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+	if op.body != nil {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.value(op.body)},
+			},
+		}
+	}
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if op.result != nil {
+		contentType := op.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": b.value(op.result)},
+		}
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "An error message, or a validation error for an invalid block",
+			"content": map[string]interface{}{
+				"text/plain":       map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				"application/json": map[string]interface{}{"schema": b.value(app.ValidationError{})},
+			},
+		},
+	}
+	if op.notFound {
+		responses["404"] = map[string]interface{}{"description": "Not found"}
+	}
+	result["responses"] = responses
+	return result
+}
+
+// value returns the schema of the type of v, or of one of several types
+func (b *schemaBuilder) value(v interface{}) map[string]interface{} {
+	if types, ok := v.(oneOf); ok {
+		var schemas []interface{}
+		for _, t := range types {
+			schemas = append(schemas, b.value(t))
+		}
+		return map[string]interface{}{"oneOf": schemas}
+	}
+	return b.schema(reflect.TypeOf(v))
+}
+
+var (
+	blockInterface = reflect.TypeOf((*tradeblocks.Block)(nil)).Elem()
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case t == blockInterface:
+		return b.value(blockTypes)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.schemas[name]; !ok {
+			b.schemas[name] = nil // breaks cycles
+			b.schemas[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object returns the schema of the JSON encoding of a struct
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	b.fields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *schemaBuilder) fields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.fields(ft, properties)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+	}
+}
+
+// schemaName returns the component name of a named type
+func schemaName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// paramNames returns the sorted names of the query params of every operation of a path
+func (p apiPath) paramNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, op := range p.operations {
+		for _, param := range op.params {
+			if !param.header && !seen[param.name] {
+				seen[param.name] = true
+				names = append(names, param.name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,241 @@
+package web
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+)
+
+func TestOpenAPI(t *testing.T) {
+	ts := httptest.NewServer(NewServer(app.NewBlockStore()))
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var spec struct {
+		Paths      map[string]map[string]interface{}
+		Components struct {
+			Schemas map[string]interface{}
+		}
+	}
+	if err := json.NewDecoder(res.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range apiPaths {
+		for _, op := range p.operations {
+			if _, ok := spec.Paths[p.path][strings.ToLower(op.method)]; !ok {
+				t.Errorf("expected %s %s in the spec", op.method, p.path)
+			}
+		}
+	}
+	for _, name := range []string{"AccountBlock", "NetworkBlock", "BatchResult", "ValidationError", "AccountHistory"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("expected schema %s in the spec", name)
+		}
+	}
+
+	// Requests that don't match the spec are rejected
+	for _, tt := range []struct {
+		method, url string
+		status      int
+	}{
+		{"DELETE", "/block?hash=x", http.StatusMethodNotAllowed},
+		{"GET", "/block", http.StatusBadRequest},
+		{"GET", "/block?hash=x&hsah=x", http.StatusBadRequest},
+		{"GET", "/blocks?limit=ten", http.StatusBadRequest},
+		{"GET", "/orders?side=both&base=x&ppu=1&quote=y", http.StatusBadRequest},
+		{"GET", "/orders?side=buy&base=x&ppu=1&quote=y", http.StatusOK},
+	} {
+		r, err := http.NewRequest(tt.method, ts.URL+tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.url, tt.status, res.StatusCode)
+		}
+	}
+}
+
+// TestOpenAPIParams fails if the query params that the handlers read drift from the params in the spec
+func TestOpenAPIParams(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs := make(map[string][]*ast.FuncDecl)
+	for _, f := range pkgs["web"].Files {
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Body != nil {
+				funcs[fd.Name.Name] = append(funcs[fd.Name.Name], fd)
+			}
+		}
+	}
+	handlers := routeHandlers(t, funcs["routes"])
+	if len(handlers) != len(apiPaths) {
+		t.Fatalf("expected %d routes, got %d", len(apiPaths), len(handlers))
+	}
+	for _, p := range apiPaths {
+		handler, ok := handlers[p.path]
+		if !ok {
+			t.Errorf("no handler for %s", p.path)
+			continue
+		}
+		params := make(map[string]bool)
+		queryParams(funcs, handler, make(map[string]bool), params)
+		var read []string
+		for name := range params {
+			read = append(read, name)
+		}
+		sort.Strings(read)
+		if expect := p.paramNames(); strings.Join(read, ",") != strings.Join(expect, ",") {
+			t.Errorf("%s: handler reads params %v, spec has %v", p.path, read, expect)
+		}
+	}
+}
+
+// routeHandlers returns the name of the handler function of each path in the routes map
+func routeHandlers(t *testing.T, routes []*ast.FuncDecl) map[string]string {
+	result := make(map[string]string)
+	for _, fd := range routes {
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			kv, ok := n.(*ast.KeyValueExpr)
+			if !ok {
+				return true
+			}
+			key, ok := kv.Key.(*ast.BasicLit)
+			if !ok {
+				return true
+			}
+			call, ok := kv.Value.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			path, err := strconv.Unquote(key.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result[path] = sel.Sel.Name
+			return true
+		})
+	}
+	return result
+}
+
+// queryParams adds the query params read by the functions with the specified name, and the functions they call
+func queryParams(funcs map[string][]*ast.FuncDecl, name string, visited, params map[string]bool) {
+	if visited[name] {
+		return
+	}
+	visited[name] = true
+	for _, fd := range funcs[name] {
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				queryParams(funcs, fun.Name, visited, params)
+			case *ast.SelectorExpr:
+				if fun.Sel.Name == "FormValue" || fun.Sel.Name == "Get" && isQueryCall(fun.X) {
+					if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+						if v, err := strconv.Unquote(lit.Value); err == nil {
+							params[v] = true
+						}
+					}
+					return true
+				}
+				queryParams(funcs, fun.Sel.Name, visited, params)
+			}
+			return true
+		})
+	}
+}
+
+// isQueryCall returns whether e is a call of URL.Query
+func isQueryCall(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "Query"
+}
+
+// TestOpenAPIClient fails if requests made by the client don't match the spec
+func TestOpenAPIClient(t *testing.T) {
+	_, a := app.CreateAccount(t)
+	c := NewClient("http://localhost")
+	block := tradeblocks.NewIssueBlock(a, 100)
+	requests := []func() (*http.Request, error){
+		func() (*http.Request, error) { return c.NewPostAccountBlockRequest(block) },
+		func() (*http.Request, error) { return c.NewPostSwapBlockRequest(&tradeblocks.SwapBlock{}) },
+		func() (*http.Request, error) { return c.NewPostOrderBlockRequest(&tradeblocks.OrderBlock{}) },
+		func() (*http.Request, error) { return c.NewPostBatchRequest(nil, true) },
+		func() (*http.Request, error) { return c.NewValidateRequest("account", block) },
+		func() (*http.Request, error) { return c.NewGetBlocksRequest(1, 10) },
+		func() (*http.Request, error) { return c.NewExportRequest(1) },
+		func() (*http.Request, error) {
+			return c.NewGetAccountBlocksRequest(db.BlockFilter{Account: a, Token: a, Action: "issue", Since: 1, Limit: 10})
+		},
+		func() (*http.Request, error) { return c.NewGetSwapBlocksRequest(db.BlockFilter{Account: a}) },
+		func() (*http.Request, error) { return c.NewGetOrderBlocksRequest(db.BlockFilter{Account: a}) },
+		func() (*http.Request, error) { return c.NewGetBlockRequest("hash") },
+		func() (*http.Request, error) { return c.NewGetAccountHeadRequest(a, a) },
+		func() (*http.Request, error) { return c.NewGetSwapHeadRequest(a, "id") },
+		func() (*http.Request, error) { return c.NewGetOrderHeadRequest(a, "id") },
+		func() (*http.Request, error) { return c.NewGetBuyOrdersRequest(a, 1.5, a) },
+		func() (*http.Request, error) { return c.NewGetSellOrdersRequest(a, 1.5, a) },
+		func() (*http.Request, error) { return c.NewGetHistoryRequest(a, a, "cursor", 10) },
+		c.NewGetAddressRequest,
+	}
+	for _, newRequest := range requests {
+		r, err := newRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		op := specOperation(r)
+		if op == nil {
+			t.Errorf("%s %s: not in the spec", r.Method, r.URL.Path)
+			continue
+		}
+		if err := op.check(r.URL.Query()); err != nil {
+			t.Errorf("%s %s: %s", r.Method, r.URL, err.Error())
+		}
+	}
+}
+
+func specOperation(r *http.Request) *apiOperation {
+	for _, p := range apiPaths {
+		if p.path == r.URL.Path {
+			return p.operation(r.Method)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+)
+
+// BlocksPage represents a page of blocks of every type in sequence order
+type BlocksPage struct {
+	Blocks []tradeblocks.NetworkBlock
+
+	// Next is the sequence to request the following page with or zero if this is the last page
+	Next int
+}
+
+// AccountBlocksPage represents a page of account blocks in sequence order
+type AccountBlocksPage struct {
+	Blocks []tradeblocks.NetworkAccountBlock
+
+	// Next is the sequence to request the following page with or zero if this is the last page
+	Next int
+}
+
+// SwapBlocksPage represents a page of swap blocks in sequence order
+type SwapBlocksPage struct {
+	Blocks []tradeblocks.NetworkSwapBlock
+
+	// Next is the sequence to request the following page with or zero if this is the last page
+	Next int
+}
+
+// OrderBlocksPage represents a page of order blocks in sequence order
+type OrderBlocksPage struct {
+	Blocks []tradeblocks.NetworkOrderBlock
+
+	// Next is the sequence to request the following page with or zero if this is the last page
+	Next int
+}
+
+// ValidateResult is whether a block would be added to the store of a node
+type ValidateResult struct {
+	Hash  string
+	Valid bool
+	Error *app.ValidationError `json:",omitempty"` // why the block is invalid, nil if it's valid
+}
+
+// BatchResult is the response to a batch of blocks
+type BatchResult struct {
+	Hashes []string // hashes of the blocks in batch order
+}
+
+// batchFailure is the response to a batch with an invalid block
+type batchFailure struct {
+	Index int
+	Hash  string
+	Error *app.ValidationError
+}
+
+// ExportRecord is a line of a block export
+type ExportRecord struct {
+	Type     string
+	Hash     string
+	Sequence int
+	Time     time.Time // when the block was added to the exporting node, zero if unknown
+	Block    tradeblocks.Block
+}
+
+// UnmarshalJSON decodes the block into the concrete block type named by Type
+func (e *ExportRecord) UnmarshalJSON(data []byte) error {
+	var nb tradeblocks.NetworkBlock
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return err
+	}
+	var raw struct {
+		Hash string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	e.Type = nb.Type
+	e.Hash = raw.Hash
+	e.Sequence = nb.Sequence
+	e.Time = nb.Time
+	e.Block = nb.Block
+	return nil
+}
@@ -0,0 +1,421 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+)
+
+const (
+	// maxRPCBatchSize is the largest number of calls allowed in a JSON-RPC batch
+	maxRPCBatchSize = 100
+
+	// maxRPCBodySize is the largest JSON-RPC request body in bytes
+	maxRPCBodySize = 8 << 20
+)
+
+// JSON-RPC error codes. See the JSON-RPC API section of the README.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcInvalidBlock   = -32000
+	rpcNotFound       = -32001
+)
+
+// RPCRequest is a JSON-RPC 2.0 request. A request without an ID is a notification, which gets no response.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// RPCResponse is a JSON-RPC 2.0 response with either a result or an error
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// RPCError is the error of a JSON-RPC call
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"` // validation error of an invalid block
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc: error %d: %s", e.Code, e.Message)
+}
+
+// BlockResult is a block with its type and hash
+type BlockResult struct {
+	Type  string
+	Hash  string
+	Block tradeblocks.Block
+}
+
+// UnmarshalJSON decodes the block into the concrete block type named by Type
+func (r *BlockResult) UnmarshalJSON(data []byte) error {
+	var nb tradeblocks.NetworkBlock
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return err
+	}
+	var raw struct {
+		Hash string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.Type = nb.Type
+	r.Hash = raw.Hash
+	r.Block = nb.Block
+	return nil
+}
+
+// Balance is the balance of an account for a token
+type Balance struct {
+	Token   string
+	Balance float64
+	Head    string // hash of the head of the account-token chain
+}
+
+// NodeInfo describes a node
+type NodeInfo struct {
+	Address      string // address that the node signs confirm blocks with, empty if it's unknown
+	LastSequence int    // sequence of the most recently added block
+}
+
+// rpcMethod handles a call with the specified params. Blocks that it adds are appended to added, and handled
+// after the response is sent.
+type rpcMethod func(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error)
+
+var rpcMethods = map[string]rpcMethod{
+	"getBlock":      rpcGetBlock,
+	"getHead":       rpcGetHead,
+	"getBalances":   rpcGetBalances,
+	"getOrderBook":  rpcGetOrderBook,
+	"getHistory":    rpcGetHistory,
+	"getNodeInfo":   rpcGetNodeInfo,
+	"submitBlock":   rpcSubmitBlock,
+	"submitBatch":   rpcSubmitBatch,
+	"validateBlock": rpcValidateBlock,
+}
+
+func rpcGetBlock(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	var p struct {
+		Hash string
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	_, b, err := s.block(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockResult{Type: blockType(b), Hash: b.Hash(), Block: b}, nil
+}
+
+func rpcGetHead(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	var p struct {
+		Type    string
+		Account string
+		Token   string // token of an account chain
+		ID      string // id of a swap or order chain
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	key := p.ID
+	if p.Type == "account" {
+		key = p.Token
+	}
+	b, err := s.head(p.Type, p.Account, key)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockResult{Type: p.Type, Hash: b.Hash(), Block: b}, nil
+}
+
+func rpcGetBalances(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	var p struct {
+		Account string
+		Token   string `json:",omitempty"` // only return the balance of this token
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return s.balances(p.Account, p.Token)
+}
+
+func rpcGetOrderBook(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	var p struct {
+		Side  string
+		Base  string
+		PPU   float64
+		Quote string
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	orders, err := s.orders(p.Side, p.Base, p.PPU, p.Quote)
+	if orders == nil && err == nil {
+		orders = []*tradeblocks.OrderBlock{}
+	}
+	return orders, err
+}
+
+func rpcGetHistory(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	var p struct {
+		Account string
+		Token   string
+		Cursor  string
+		Limit   int
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return s.history(p.Account, p.Token, p.Cursor, p.Limit)
+}
+
+func rpcGetNodeInfo(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	if err := decodeParams(params, &struct{}{}); err != nil {
+		return nil, err
+	}
+	last, err := s.store.LastSequence()
+	if err != nil {
+		return nil, err
+	}
+	return &NodeInfo{Address: s.Address, LastSequence: last}, nil
+}
+
+func rpcSubmitBlock(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	nb, err := decodeBlockParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addBlock(nb.Block); err != nil {
+		return nil, err
+	}
+	*added = append(*added, nb)
+	return &BlockResult{Type: nb.Type, Hash: nb.Block.Hash(), Block: nb.Block}, nil
+}
+
+func rpcSubmitBatch(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	var p struct {
+		Blocks []tradeblocks.NetworkBlock
+		DryRun bool
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	result, err := s.addBatch(p.Blocks, p.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !p.DryRun {
+		*added = append(*added, p.Blocks...)
+	}
+	return result, nil
+}
+
+func rpcValidateBlock(s *Server, params json.RawMessage, added *[]tradeblocks.NetworkBlock) (interface{}, error) {
+	nb, err := decodeBlockParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return s.validate(nb.Block)
+}
+
+// decodeParams decodes the params object of a call into v, rejecting unknown params
+func decodeParams(params json.RawMessage, v interface{}) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	if params[0] != '{' {
+		return badRequest("params must be an object")
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid params: " + err.Error())
+	}
+	return nil
+}
+
+// decodeBlockParams decodes the params of a call with a Type and an account, swap or order Block
+func decodeBlockParams(params json.RawMessage) (tradeblocks.NetworkBlock, error) {
+	var p struct {
+		Type  string
+		Block json.RawMessage
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return tradeblocks.NetworkBlock{}, err
+	}
+	b := newBlock(p.Type)
+	if b == nil {
+		return tradeblocks.NetworkBlock{}, badRequest("invalid block type '" + p.Type + "'")
+	}
+	if len(p.Block) == 0 {
+		return tradeblocks.NetworkBlock{}, badRequest("missing param 'Block'")
+	}
+	if err := json.Unmarshal(p.Block, b); err != nil {
+		return tradeblocks.NetworkBlock{}, badRequest("error decoding block: " + err.Error())
+	}
+	return tradeblocks.NetworkBlock{Type: p.Type, Block: b}, nil
+}
+
+// balances returns the balance of every token of an account, or only of the specified token
+func (s *Server) balances(account, token string) ([]Balance, error) {
+	if account == "" {
+		return nil, badRequest("missing param 'Account'")
+	}
+	blocks, err := s.store.QueryAccountBlocks(db.BlockFilter{Account: account, Token: token})
+	if err != nil {
+		return nil, fmt.Errorf("error getting blocks: %s", err.Error())
+	}
+	result := []Balance{}
+	index := make(map[string]int)
+	for _, b := range blocks {
+		balance := Balance{Token: b.Token, Balance: b.Balance, Head: b.Hash()}
+		if i, ok := index[b.Token]; ok {
+			result[i] = balance
+			continue
+		}
+		index[b.Token] = len(result)
+		result = append(result, balance)
+	}
+	return result, nil
+}
+
+// handleRPC serves JSON-RPC 2.0 calls and batches of calls
+func (s *Server) handleRPC() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCBodySize))
+		if err != nil {
+			s.serverError(w, "error reading request: "+err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = bytes.TrimSpace(body)
+
+		var added []tradeblocks.NetworkBlock
+		var result interface{}
+		switch {
+		case !json.Valid(body):
+			result = rpcFailure(nil, rpcParseError, "parse error")
+		case body[0] == '[':
+			var calls []json.RawMessage
+			if err := json.Unmarshal(body, &calls); err != nil {
+				result = rpcFailure(nil, rpcParseError, "parse error")
+				break
+			}
+			if len(calls) == 0 || len(calls) > maxRPCBatchSize {
+				result = rpcFailure(nil, rpcInvalidRequest, "batch must have between 1 and "+strconv.Itoa(maxRPCBatchSize)+" calls")
+				break
+			}
+			var responses []*RPCResponse
+			for _, call := range calls {
+				if res := s.rpcCall(call, &added); res != nil {
+					responses = append(responses, res)
+				}
+			}
+			if len(responses) > 0 {
+				result = responses
+			}
+		default:
+			if res := s.rpcCall(body, &added); res != nil {
+				result = res
+			}
+		}
+
+		// Only notifications
+		if result == nil {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(result); err != nil {
+				s.log.Error("can't encode rpc response", "error", err)
+			}
+		}
+		for _, nb := range added {
+			s.blockAdded(nb.Type, nb.Block)
+		}
+	}
+}
+
+// rpcCall handles one call and returns its response, or nil for a notification
+func (s *Server) rpcCall(data json.RawMessage, added *[]tradeblocks.NetworkBlock) *RPCResponse {
+	var req RPCRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return rpcFailure(nil, rpcInvalidRequest, "invalid request")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, rpcInvalidRequest, "invalid request: 'jsonrpc' must be \"2.0\" and 'method' is required")
+	}
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		if req.ID == nil {
+			return nil
+		}
+		return rpcFailure(req.ID, rpcMethodNotFound, "method not found: "+req.Method)
+	}
+	result, err := method(s, req.Params, added)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return &RPCResponse{JSONRPC: "2.0", Error: s.rpcErrorFor(req.Method, err), ID: req.ID}
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return rpcFailure(req.ID, rpcInternalError, "error encoding result: "+err.Error())
+	}
+	return &RPCResponse{JSONRPC: "2.0", Result: b, ID: req.ID}
+}
+
+func rpcFailure(id json.RawMessage, code int, message string) *RPCResponse {
+	return &RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &RPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
+
+// rpcErrorFor returns the JSON-RPC error of a failed call
+func (s *Server) rpcErrorFor(method string, err error) *RPCError {
+	switch e := err.(type) {
+	case *requestError:
+		if e.status == http.StatusNotFound {
+			return &RPCError{Code: rpcNotFound, Message: e.message}
+		}
+		return &RPCError{Code: rpcInvalidParams, Message: e.message}
+	case *app.ValidationError:
+		return &RPCError{Code: rpcInvalidBlock, Message: e.Error(), Data: e}
+	case *app.BatchError:
+		if ve, ok := e.Err.(*app.ValidationError); ok {
+			return &RPCError{
+				Code:    rpcInvalidBlock,
+				Message: e.Error(),
+				Data:    batchFailure{Index: e.Index, Hash: e.Hash, Error: ve},
+			}
+		}
+	}
+	s.log.Error("rpc call failed", "method", method, "error", err)
+	return &RPCError{Code: rpcInternalError, Message: err.Error()}
+}
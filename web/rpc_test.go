@@ -0,0 +1,191 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+)
+
+func TestRPC(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	srv := NewServer(app.NewBlockStore())
+	srv.Address = "xtb:node"
+	var added []string
+	srv.BlockHandler = func(b app.TypedBlock) {
+		added = append(added, b.T)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 200), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Submit a block
+	var res RPCResponse
+	callRPC(t, ts.URL, rpcBody(t, 1, "submitBlock", map[string]interface{}{"Type": "account", "Block": issue}), &res)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if string(res.ID) != "1" {
+		t.Fatalf("expected id 1, got %s", res.ID)
+	}
+	if len(added) != 1 || added[0] != "account" {
+		t.Fatalf("expected the block handler to get the account block, got %v", added)
+	}
+
+	// Heads and balances
+	var head BlockResult
+	res = RPCResponse{}
+	callRPC(t, ts.URL, rpcBody(t, 2, "getHead", map[string]string{"Type": "account", "Account": a, "Token": a}), &res)
+	if err := json.Unmarshal(res.Result, &head); err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash != issue.Hash() || head.Block.Hash() != issue.Hash() {
+		t.Fatalf("expected head %s, got %+v", issue.Hash(), head)
+	}
+	var balances []Balance
+	res = RPCResponse{}
+	callRPC(t, ts.URL, rpcBody(t, 3, "getBalances", map[string]string{"Account": a}), &res)
+	if err := json.Unmarshal(res.Result, &balances); err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Token != a || balances[0].Balance != 100 || balances[0].Head != issue.Hash() {
+		t.Fatalf("expected a balance of 100 %s, got %+v", a, balances)
+	}
+
+	// Invalid blocks return their validation error
+	res = RPCResponse{}
+	callRPC(t, ts.URL, rpcBody(t, 4, "submitBlock", map[string]interface{}{"Type": "account", "Block": send}), &res)
+	if res.Error == nil || res.Error.Code != rpcInvalidBlock {
+		t.Fatalf("expected error %d, got %+v", rpcInvalidBlock, res.Error)
+	}
+	if data, ok := res.Error.Data.(map[string]interface{}); !ok || data["Code"] != app.CodeNegativeBalance {
+		t.Fatalf("expected %s data, got %+v", app.CodeNegativeBalance, res.Error.Data)
+	}
+
+	// Node info
+	var info NodeInfo
+	res = RPCResponse{}
+	callRPC(t, ts.URL, rpcBody(t, 5, "getNodeInfo", nil), &res)
+	if err := json.Unmarshal(res.Result, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Address != "xtb:node" || info.LastSequence != 1 {
+		t.Fatalf("expected address xtb:node and last sequence 1, got %+v", info)
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	ts := httptest.NewServer(NewServer(app.NewBlockStore()))
+	defer ts.Close()
+
+	// Each call gets a response in order, except notifications
+	body := `[
+		{"jsonrpc": "2.0", "method": "getBlock", "params": {"Hash": "missing"}, "id": "a"},
+		{"jsonrpc": "2.0", "method": "getNodeInfo"},
+		{"jsonrpc": "2.0", "method": "nope", "id": 2},
+		{"jsonrpc": "2.0", "method": "getHead", "params": {"Type": "account", "Acount": "x"}, "id": 3},
+		{"jsonrpc": "2.0", "method": "getOrderBook", "params": {"Side": "buy", "Base": "x", "PPU": 1, "Quote": "y"}, "id": 4},
+		1
+	]`
+	var responses []RPCResponse
+	callRPC(t, ts.URL, body, &responses)
+	expect := []struct {
+		id   string
+		code int
+	}{
+		{`"a"`, rpcNotFound},
+		{"2", rpcMethodNotFound},
+		{"3", rpcInvalidParams},
+		{"4", 0},
+		{"null", rpcInvalidRequest},
+	}
+	if len(responses) != len(expect) {
+		t.Fatalf("expected %d responses, got %d", len(expect), len(responses))
+	}
+	for i, e := range expect {
+		res := responses[i]
+		if string(res.ID) != e.id {
+			t.Errorf("response %d: expected id %s, got %s", i, e.id, res.ID)
+		}
+		if e.code == 0 && res.Error != nil {
+			t.Errorf("response %d: unexpected error %v", i, res.Error)
+		}
+		if e.code != 0 && (res.Error == nil || res.Error.Code != e.code) {
+			t.Errorf("response %d: expected error %d, got %+v", i, e.code, res.Error)
+		}
+	}
+	if string(responses[3].Result) == "" {
+		t.Errorf("expected a result for the order book")
+	}
+
+	// Invalid bodies
+	for body, code := range map[string]int{
+		`{"jsonrpc": "2.0", "method"`:        rpcParseError,
+		`[]`:                                 rpcInvalidRequest,
+		`{"method": "getNodeInfo", "id": 1}`: rpcInvalidRequest,
+	} {
+		var res RPCResponse
+		callRPC(t, ts.URL, body, &res)
+		if res.Error == nil || res.Error.Code != code {
+			t.Errorf("body %s: expected error %d, got %+v", body, code, res.Error)
+		}
+	}
+
+	// Only notifications
+	res, err := http.Post(ts.URL+"/rpc", "application/json", strings.NewReader(`[{"jsonrpc": "2.0", "method": "getNodeInfo"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+}
+
+func rpcBody(t *testing.T, id int, method string, params interface{}) string {
+	req := RPCRequest{JSONRPC: "2.0", Method: method, ID: json.RawMessage(strconv.Itoa(id))}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Params = b
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func callRPC(t *testing.T, url, body string, result interface{}) {
+	res, err := http.Post(url+"/rpc", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.StatusCode, b)
+	}
+	if err := json.Unmarshal(b, result); err != nil {
+		t.Fatalf("error decoding %s: %s", b, err.Error())
+	}
+}
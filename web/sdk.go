@@ -0,0 +1,293 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+)
+
+// Defaults of a new client
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultRetries    = 2
+	defaultRetryDelay = 200 * time.Millisecond
+)
+
+// Do adds Header to a request and executes it with the HTTP client of this client
+func (c *Client) Do(r *http.Request) (*http.Response, error) {
+	for k, vs := range c.Header {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if c.HTTPClient == nil {
+		return http.DefaultClient.Do(r)
+	}
+	return c.HTTPClient.Do(r)
+}
+
+// GetBlock returns the block with the specified hash. If it's not found, the error is db.ErrNotFound.
+func (c *Client) GetBlock(ctx context.Context, hash string) (b tradeblocks.Block, err error) {
+	err = c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewGetBlockRequest(hash)
+	}, func(res *http.Response) (err error) {
+		b, err = c.DecodeBlockResponse(res)
+		return
+	})
+	return b, asNotFound(err)
+}
+
+// GetAccountBlock returns the account block with the specified hash
+func (c *Client) GetAccountBlock(ctx context.Context, hash string) (*tradeblocks.AccountBlock, error) {
+	b, err := c.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := b.(*tradeblocks.AccountBlock)
+	if !ok {
+		return nil, fmt.Errorf("client: block %s is not an account block", hash)
+	}
+	return result, nil
+}
+
+// GetSwapBlock returns the swap block with the specified hash
+func (c *Client) GetSwapBlock(ctx context.Context, hash string) (*tradeblocks.SwapBlock, error) {
+	b, err := c.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := b.(*tradeblocks.SwapBlock)
+	if !ok {
+		return nil, fmt.Errorf("client: block %s is not a swap block", hash)
+	}
+	return result, nil
+}
+
+// GetOrderBlock returns the order block with the specified hash
+func (c *Client) GetOrderBlock(ctx context.Context, hash string) (*tradeblocks.OrderBlock, error) {
+	b, err := c.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := b.(*tradeblocks.OrderBlock)
+	if !ok {
+		return nil, fmt.Errorf("client: block %s is not an order block", hash)
+	}
+	return result, nil
+}
+
+// AccountBlocks returns a page of account blocks matching the specified filter
+func (c *Client) AccountBlocks(ctx context.Context, f db.BlockFilter) (page *AccountBlocksPage, err error) {
+	err = c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewGetAccountBlocksRequest(f)
+	}, func(res *http.Response) (err error) {
+		page, err = c.DecodeGetAccountBlocksResponse(res)
+		return
+	})
+	return
+}
+
+// GetAccountHead returns the head of an account-token chain. If there's none, the error is db.ErrNotFound.
+func (c *Client) GetAccountHead(ctx context.Context, account, token string) (*tradeblocks.AccountBlock, error) {
+	var result tradeblocks.AccountBlock
+	if err := c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewGetAccountHeadRequest(account, token)
+	}, func(res *http.Response) error {
+		return c.DecodeAccountBlockResponse(res, &result)
+	}); err != nil {
+		return nil, asNotFound(err)
+	}
+	return &result, nil
+}
+
+// GetSwapHead returns the head of an account-id swap chain. If there's none, the error is db.ErrNotFound.
+func (c *Client) GetSwapHead(ctx context.Context, account, id string) (*tradeblocks.SwapBlock, error) {
+	var result tradeblocks.SwapBlock
+	if err := c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewGetSwapHeadRequest(account, id)
+	}, func(res *http.Response) error {
+		return c.DecodeSwapBlockResponse(res, &result)
+	}); err != nil {
+		return nil, asNotFound(err)
+	}
+	return &result, nil
+}
+
+// GetOrderHead returns the head of an account-id order chain. If there's none, the error is db.ErrNotFound.
+func (c *Client) GetOrderHead(ctx context.Context, account, id string) (*tradeblocks.OrderBlock, error) {
+	var result tradeblocks.OrderBlock
+	if err := c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewGetOrderHeadRequest(account, id)
+	}, func(res *http.Response) error {
+		return c.DecodeOrderBlockResponse(res, &result)
+	}); err != nil {
+		return nil, asNotFound(err)
+	}
+	return &result, nil
+}
+
+// PostBlock adds an account, swap or order block to the node. If the block is invalid, the error is an
+// *app.ValidationError. Blocks are posted once, since a retry after a lost response fails as a duplicate.
+func (c *Client) PostBlock(ctx context.Context, b tradeblocks.Block) error {
+	return c.call(ctx, false, func() (*http.Request, error) {
+		return c.newPostBlockRequest(b)
+	}, c.checkResponse)
+}
+
+func (c *Client) newPostBlockRequest(b tradeblocks.Block) (*http.Request, error) {
+	switch b := b.(type) {
+	case *tradeblocks.AccountBlock:
+		return c.NewPostAccountBlockRequest(b)
+	case *tradeblocks.SwapBlock:
+		return c.NewPostSwapBlockRequest(b)
+	case *tradeblocks.OrderBlock:
+		return c.NewPostOrderBlockRequest(b)
+	}
+	return nil, fmt.Errorf("client: can't post block type %T", b)
+}
+
+// PostBatch adds a batch of blocks in order, all or none of them, and returns their hashes. If a block is invalid,
+// the error is an *app.BatchError. If dryRun is true, the batch is validated but not added.
+func (c *Client) PostBatch(ctx context.Context, blocks []tradeblocks.NetworkBlock, dryRun bool) (hashes []string, err error) {
+	err = c.call(ctx, dryRun, func() (*http.Request, error) {
+		return c.NewPostBatchRequest(blocks, dryRun)
+	}, func(res *http.Response) (err error) {
+		hashes, err = c.DecodeBatchResponse(res)
+		return
+	})
+	return
+}
+
+// Validate returns whether the node would add the specified block
+func (c *Client) Validate(ctx context.Context, b tradeblocks.Block) (result *ValidateResult, err error) {
+	t := blockType(b)
+	err = c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewValidateRequest(t, b)
+	}, func(res *http.Response) (err error) {
+		result, err = c.DecodeValidateResponse(res)
+		return
+	})
+	return
+}
+
+// Book returns the open orders to sell base for quote at ppu or less if side is "buy", or to buy base at ppu or
+// more if side is "sell"
+func (c *Client) Book(ctx context.Context, side, base string, ppu float64, quote string) (orders []*tradeblocks.OrderBlock, err error) {
+	var newRequest func(base string, ppu float64, quote string) (*http.Request, error)
+	switch side {
+	case "buy":
+		newRequest = c.NewGetBuyOrdersRequest
+	case "sell":
+		newRequest = c.NewGetSellOrdersRequest
+	default:
+		return nil, fmt.Errorf("client: unknown side '%s'", side)
+	}
+	err = c.call(ctx, true, func() (*http.Request, error) {
+		return newRequest(base, ppu, quote)
+	}, func(res *http.Response) (err error) {
+		orders, err = c.DecodeGetOrdersArrayResponse(res)
+		return
+	})
+	return
+}
+
+// History returns a page of an account-token chain, starting at the head if cursor is empty
+func (c *Client) History(ctx context.Context, account, token, cursor string, limit int) (h *app.AccountHistory, err error) {
+	err = c.call(ctx, true, func() (*http.Request, error) {
+		return c.NewGetHistoryRequest(account, token, cursor, limit)
+	}, func(res *http.Response) (err error) {
+		h, err = c.DecodeGetHistoryResponse(res)
+		return
+	})
+	return
+}
+
+// Address returns the address of the node
+func (c *Client) Address(ctx context.Context) (address string, err error) {
+	err = c.call(ctx, true, c.NewGetAddressRequest, func(res *http.Response) (err error) {
+		address, err = c.DecodeGetAddressResponse(res)
+		return
+	})
+	return
+}
+
+// Subscribe opens a stream subscribed to the specified topics, which is closed when ctx is done
+func (c *Client) Subscribe(ctx context.Context, topics ...string) (*Stream, error) {
+	s, err := c.DialStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range topics {
+		if err := s.Subscribe(t); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+	return s, nil
+}
+
+// call executes the request made by newRequest and decodes its response with decode. If retry is true, the request
+// is safe to repeat and is retried after a temporary failure.
+func (c *Client) call(ctx context.Context, retry bool, newRequest func() (*http.Request, error), decode func(*http.Response) error) error {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, newRequest, decode)
+		if err == nil || !retry || attempt >= c.Retries || !temporary(err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, newRequest func() (*http.Request, error), decode func(*http.Response) error) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	r, err := newRequest()
+	if err != nil {
+		return err
+	}
+	res, err := c.Do(r.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return decode(res)
+}
+
+// temporary returns whether a failed request may succeed if it's retried
+func temporary(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		return se.Temporary()
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// asNotFound returns db.ErrNotFound for the error of a 404 response, or err otherwise
+func asNotFound(err error) error {
+	if se, ok := err.(*StatusError); ok && se.StatusCode == http.StatusNotFound {
+		return db.ErrNotFound
+	}
+	return err
+}
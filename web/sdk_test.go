@@ -0,0 +1,204 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+)
+
+func TestClientSDK(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	srv := NewServer(app.NewBlockStore())
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	ctx := context.Background()
+
+	// Missing heads return db.ErrNotFound
+	if _, err := c.GetAccountHead(ctx, a, a); err != db.ErrNotFound {
+		t.Fatalf("expected %v, got %v", db.ErrNotFound, err)
+	}
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PostBlock(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+	head, err := c.GetAccountHead(ctx, a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != issue.Hash() {
+		t.Fatalf("expected head %s, got %s", issue.Hash(), head.Hash())
+	}
+	if _, err := c.GetSwapBlock(ctx, issue.Hash()); err == nil {
+		t.Fatal("expected an error for an account block")
+	}
+	page, err := c.AccountBlocks(ctx, db.BlockFilter{Account: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Blocks) != 1 || page.Blocks[0].Hash() != issue.Hash() {
+		t.Fatalf("expected account block %s, got %+v", issue.Hash(), page.Blocks)
+	}
+
+	// Invalid blocks return their validation error
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 200), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Validate(ctx, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.Error.Code != app.CodeNegativeBalance {
+		t.Fatalf("expected %s, got %+v", app.CodeNegativeBalance, result)
+	}
+	err = c.PostBlock(ctx, send)
+	if ve, ok := err.(*app.ValidationError); !ok || ve.Code != app.CodeNegativeBalance {
+		t.Fatalf("expected %s, got %v", app.CodeNegativeBalance, err)
+	}
+
+	// Orders are matched by side
+	if _, err := c.Book(ctx, "buy", a, 1, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Book(ctx, "both", a, 1, a); err == nil {
+		t.Fatal("expected an error for an unknown side")
+	}
+}
+
+func TestClientHeader(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("TradeBlocks-Register")
+		w.Write([]byte(`{"Blocks":[]}`))
+	}))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	c.Header = http.Header{}
+	c.Header.Set("TradeBlocks-Register", "http://localhost:8081")
+	if _, err := c.AccountBlocks(context.Background(), db.BlockFilter{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "http://localhost:8081" {
+		t.Fatalf("expected the header of the client, got '%s'", got)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	srv := NewServer(app.NewBlockStore())
+	var failures, requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	c.RetryDelay = time.Millisecond
+	ctx := context.Background()
+
+	// Reads are retried
+	atomic.StoreInt32(&failures, 2)
+	if _, err := c.GetAccountHead(ctx, a, a); err != db.ErrNotFound {
+		t.Fatalf("expected %v after retries, got %v", db.ErrNotFound, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+
+	// Reads fail after the last retry
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 3)
+	_, err := c.GetAccountHead(ctx, a, a)
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %v", http.StatusServiceUnavailable, err)
+	}
+
+	// Blocks are posted once
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 1)
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PostBlock(ctx, issue); err == nil {
+		t.Fatal("expected a status error")
+	} else if _, ok := err.(*StatusError); !ok {
+		t.Fatalf("expected a status error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	c.Timeout = 10 * time.Millisecond
+	c.Retries = 0
+	if _, err := c.Address(context.Background()); !isTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// A cancelled context stops the request without retries
+	c.Timeout = 0
+	c.Retries = 5
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Address(ctx); !isTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+// isTimeout returns whether err is a timeout of a request
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestClientSubscribe(t *testing.T) {
+	ts := httptest.NewServer(NewServer(app.NewBlockStore()))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := c.Subscribe(ctx, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case _, ok := <-s.Events():
+		if ok {
+			t.Fatal("expected no events")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to close when the context is done")
+	}
+	if _, err := c.Subscribe(context.Background(), "blocks"); err == nil {
+		t.Fatal("expected an error for an unknown topic")
+	}
+}
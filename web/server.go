@@ -2,48 +2,96 @@ package web
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/app"
 	"github.com/jephir/tradeblocks/db"
+	"github.com/jephir/tradeblocks/logging"
+)
+
+const (
+	// defaultHistoryLimit is the page size of a history request without a limit
+	defaultHistoryLimit = 25
+
+	// maxHistoryLimit is the largest page size allowed for a history request
+	maxHistoryLimit = 100
+
+	// defaultBlocksLimit is the page size of a blocks request without a limit
+	defaultBlocksLimit = 100
+
+	// maxBlocksLimit is the largest page size allowed for a blocks request
+	maxBlocksLimit = 1000
+
+	// exportPageSize is the number of blocks an export reads from the store at a time
+	exportPageSize = 1000
+
+	// maxBatchSize is the largest number of blocks allowed in a batch
+	maxBatchSize = 100
 )
 
 // Server implements a TradeBlocks node
 type Server struct {
 	mux         *http.ServeMux
 	blockStream *sse
+	stream      *stream
 	store       *app.BlockStore
+	log         *logging.Logger
 
 	BlockHandler func(b app.TypedBlock)
+
+	// Address is the address of the node, served at /address and reported by the getNodeInfo RPC method
+	Address string
 }
 
 // NewServer allocates and returns a new server
 func NewServer(blockstore *app.BlockStore) *Server {
 	s := &Server{
-		mux:         http.NewServeMux(),
-		blockStream: newSSE(),
-		store:       blockstore,
+		mux:    http.NewServeMux(),
+		stream: newStream(),
+		store:  blockstore,
+	}
+	last, err := blockstore.LastSequence()
+	s.blockStream = newSSE(last, s.blockEvents)
+	s.SetLogger(logging.Default("web"))
+	if err != nil {
+		s.log.Error("can't get last sequence", "error", err)
 	}
 	s.routes()
-	s.blockStream.connectHandler = s.blockEvents
 	return s
 }
 
-func (s *Server) blockEvents() []event {
-	var result []event
-	s.store.Blocks(func(sequence int, b tradeblocks.Block) bool {
-		hash := b.Hash()
-		ss, err := blockEvent(hash, b)
+// SetLogger sets the logger of this server. It must be called before the server handles requests.
+func (s *Server) SetLogger(l *logging.Logger) {
+	s.log = l
+	s.blockStream.log = l
+	s.stream.log = l
+}
+
+// blockEvents calls f with an event for every block after the specified sequence in order until f returns false
+func (s *Server) blockEvents(since int, f func(e event) bool) error {
+	for {
+		blocks, err := s.store.QueryBlocks(since, exportPageSize)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
-		result = append(result, ss)
-		return true
-	})
-	return result
+		for _, b := range blocks {
+			e, err := blockEvent(b.Sequence, b.Block)
+			if err != nil {
+				return err
+			}
+			if !f(e) {
+				return nil
+			}
+			since = b.Sequence
+		}
+		if len(blocks) < exportPageSize {
+			return nil
+		}
+	}
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -51,284 +99,703 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) routes() {
-	s.mux.HandleFunc("/block", s.handleBlock())
-	s.mux.HandleFunc("/blocks", s.handleBlocks())
-	s.mux.HandleFunc("/head", s.handleHead())
-	s.mux.HandleFunc("/orders", s.handleOrders())
+	handlers := map[string]http.HandlerFunc{
+		"/block":        s.handleBlock(),
+		"/validate":     s.handleValidate(),
+		"/blocks":       s.handleBlocks(),
+		"/blocks/batch": s.handleBatch(),
+		"/stream":       s.handleStream(),
+		"/rpc":          s.handleRPC(),
+		"/head":         s.handleHead(),
+		"/orders":       s.handleOrders(),
+		"/history":      s.handleHistory(),
+		"/export":       s.handleExport(),
+		"/address":      s.handleAddress(),
+		"/openapi.json": s.handleOpenAPI(),
+		"/metrics":      s.handleMetrics(),
+	}
+	for _, p := range apiPaths {
+		h, ok := handlers[p.path]
+		if !ok {
+			panic("web: no handler for API path " + p.path)
+		}
+		s.mux.HandleFunc(p.path, s.validateRequest(p, h))
+		delete(handlers, p.path)
+	}
+	for path := range handlers {
+		panic("web: handler for " + path + " is missing from the API spec")
+	}
+}
+
+func (s *Server) handleAddress() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, s.Address)
+	}
 }
 
 func (s *Server) handleBlock() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "GET":
-			hash := r.FormValue("hash")
-			tag, block, err := s.store.BlockWithTag(hash)
-			if err == db.ErrNotFound {
-				serverError(w, "no block found with hash '"+hash+"'", http.StatusBadRequest)
-				return
-			}
+			tag, block, err := s.block(r.FormValue("hash"))
 			if err != nil {
-				serverError(w, "error getting block: "+err.Error(), http.StatusInternalServerError)
+				s.requestFailed(w, err)
+				return
 			}
 			w.Header().Set("TradeBlocks-Tag", strconv.Itoa(tag))
 			if err := json.NewEncoder(w).Encode(block); err != nil {
-				serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
+				s.serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
 		case "POST":
 			t := r.FormValue("type")
 			if t == "" {
-				serverError(w, "missing query param 'type'", http.StatusBadRequest)
+				s.serverError(w, "missing query param 'type'", http.StatusBadRequest)
+				return
+			}
+			b := newBlock(t)
+			if b == nil {
+				s.serverError(w, "invalid query type '"+t+"'", http.StatusBadRequest)
+				return
+			}
+			if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+				s.serverError(w, "error decoding block: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.addBlock(b); err != nil {
+				s.blockError(w, "can't add "+t+" block", err)
+				return
+			}
+			if err := json.NewEncoder(w).Encode(b); err != nil {
+				s.serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
+			s.blockAdded(t, b)
+		default:
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleValidate validates a block against the store without adding or broadcasting it
+func (s *Server) handleValidate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		t := r.URL.Query().Get("type")
+		if t == "" {
+			s.serverError(w, "missing query param 'type'", http.StatusBadRequest)
+			return
+		}
+		b := newBlock(t)
+		if b == nil {
+			s.serverError(w, "invalid query type '"+t+"'", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+			s.serverError(w, "error decoding block: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := s.validate(b)
+		if err != nil {
+			s.requestFailed(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			s.serverError(w, "error encoding result: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handleBatch adds an ordered batch of blocks, all or none of them. With dry_run=true the batch is only validated.
+func (s *Server) handleBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		var batch []tradeblocks.NetworkBlock
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			s.serverError(w, "error decoding batch: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := s.addBatch(batch, dryRun)
+		if err != nil {
+			s.requestFailed(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			s.serverError(w, "error encoding result: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if dryRun {
+			return
+		}
+		for _, nb := range batch {
+			s.blockAdded(nb.Type, nb.Block)
+		}
+	}
+}
+
+func (s *Server) handleHead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		t := r.FormValue("type")
+		if t == "" {
+			s.serverError(w, "missing query param 'type'", http.StatusBadRequest)
+			return
+		}
+		key := r.FormValue("id")
+		if t == "account" {
+			key = r.FormValue("token")
+		}
+		block, err := s.head(t, r.FormValue("account"), key)
+		if err != nil {
+			s.requestFailed(w, err)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(block); err != nil {
+			s.serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (s *Server) handleBlocks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("stream") != "" {
+			s.blockStream.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			f, err := blockFilter(r)
+			if err != nil {
+				s.serverError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			limit := f.Limit
+			f.Limit++
+			t := r.FormValue("type")
 			switch t {
-			case "account":
-				var b tradeblocks.AccountBlock
-				if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-					serverError(w, "error decoding block: "+err.Error(), http.StatusBadRequest)
+			case "":
+				if f.Account != "" || f.Token != "" || f.Action != "" {
+					s.serverError(w, "query params 'account', 'token' and 'action' require param 'type'", http.StatusBadRequest)
 					return
 				}
-				if err := s.store.AddAccountBlock(&b); err != nil {
-					serverError(w, "can't add account block: "+err.Error(), http.StatusBadRequest)
+				blocks, err := s.store.QueryBlocks(f.Since, f.Limit)
+				if err != nil {
+					s.serverError(w, "error getting blocks: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if err := json.NewEncoder(w).Encode(b); err != nil {
-					serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
-					return
+				result := BlocksPage{Blocks: blocks}
+				if len(blocks) > limit {
+					result.Blocks = blocks[:limit]
+					result.Next = blocks[limit-1].Sequence
 				}
-				if s.BlockHandler != nil {
-					s.BlockHandler(app.TypedBlock{
-						AccountBlock: &b,
-						T:            "account",
-					})
+				if err := json.NewEncoder(w).Encode(result); err != nil {
+					s.serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
+					return
 				}
-			case "swap":
-				var b tradeblocks.SwapBlock
-				if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-					serverError(w, "error decoding block: "+err.Error(), http.StatusBadRequest)
+			case "account":
+				blocks, err := s.store.QueryAccountBlocks(f)
+				if err != nil {
+					s.serverError(w, "error getting blocks: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if err := s.store.AddSwapBlock(&b); err != nil {
-					serverError(w, "can't add swap block: "+err.Error(), http.StatusBadRequest)
+				result := AccountBlocksPage{Blocks: blocks}
+				if len(blocks) > limit {
+					result.Blocks = blocks[:limit]
+					result.Next = blocks[limit-1].Sequence
+				}
+				if err := json.NewEncoder(w).Encode(result); err != nil {
+					s.serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if err := json.NewEncoder(w).Encode(b); err != nil {
-					serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
+			case "swap":
+				blocks, err := s.store.QuerySwapBlocks(f)
+				if err != nil {
+					s.serverError(w, "error getting blocks: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if s.BlockHandler != nil {
-					s.BlockHandler(app.TypedBlock{
-						SwapBlock: &b,
-						T:         "swap",
-					})
+				result := SwapBlocksPage{Blocks: blocks}
+				if len(blocks) > limit {
+					result.Blocks = blocks[:limit]
+					result.Next = blocks[limit-1].Sequence
 				}
-			case "order":
-				var b tradeblocks.OrderBlock
-				if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-					serverError(w, "error decoding block: "+err.Error(), http.StatusBadRequest)
+				if err := json.NewEncoder(w).Encode(result); err != nil {
+					s.serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if err := s.store.AddOrderBlock(&b); err != nil {
-					serverError(w, "can't add order block: "+err.Error(), http.StatusBadRequest)
+			case "order":
+				blocks, err := s.store.QueryOrderBlocks(f)
+				if err != nil {
+					s.serverError(w, "error getting blocks: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if err := json.NewEncoder(w).Encode(b); err != nil {
-					serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
-					return
+				result := OrderBlocksPage{Blocks: blocks}
+				if len(blocks) > limit {
+					result.Blocks = blocks[:limit]
+					result.Next = blocks[limit-1].Sequence
 				}
-				if s.BlockHandler != nil {
-					s.BlockHandler(app.TypedBlock{
-						OrderBlock: &b,
-						T:          "order",
-					})
+				if err := json.NewEncoder(w).Encode(result); err != nil {
+					s.serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
+					return
 				}
 			default:
-				serverError(w, "invalid query type '"+t+"'", http.StatusBadRequest)
+				s.serverError(w, "invalid query type '"+t+"'", http.StatusBadRequest)
 			}
+
 		default:
-			serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		}
 	}
 }
 
-func (s *Server) handleHead() http.HandlerFunc {
+// handleExport streams every block with a sequence greater than since as newline-delimited ExportRecords, up to the
+// last sequence when the request started. The last sequence is sent in the TradeBlocks-Last-Sequence header, so a
+// client can tell a complete export from a broken connection and resume from the sequence of the last record.
+func (s *Server) handleExport() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
-			serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-		}
-		t := r.FormValue("type")
-		if t == "" {
-			serverError(w, "missing query param 'type'", http.StatusBadRequest)
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 			return
 		}
-		switch t {
-		case "account":
-			account := r.FormValue("account")
-			token := r.FormValue("token")
-			block, err := s.store.GetAccountHead(account, token)
-			if err == db.ErrNotFound {
-				serverError(w, "no account head found for account '"+account+"' and token '"+token+"'", http.StatusBadRequest)
-				return
-			}
-			if err != nil {
-				serverError(w, "error getting block: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if err := json.NewEncoder(w).Encode(block); err != nil {
-				serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		case "swap":
-			account := r.FormValue("account")
-			id := r.FormValue("id")
-			block, err := s.store.GetSwapHead(account, id)
-			if err == db.ErrNotFound {
-				serverError(w, "no swap head found for account '"+account+"' and id '"+id+"'", http.StatusBadRequest)
+		var since int
+		if v := r.FormValue("since"); v != "" {
+			var err error
+			since, err = strconv.Atoi(v)
+			if err != nil || since < 0 {
+				s.serverError(w, "query param 'since' must be a non-negative sequence", http.StatusBadRequest)
 				return
 			}
+		}
+		last, err := s.store.LastSequence()
+		if err != nil {
+			s.serverError(w, "error getting last sequence: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("TradeBlocks-Last-Sequence", strconv.Itoa(last))
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		for since < last {
+			blocks, err := s.store.QueryBlocks(since, exportPageSize)
 			if err != nil {
-				serverError(w, "error getting block: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if err := json.NewEncoder(w).Encode(block); err != nil {
-				serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
+				// The status is already sent, so the client sees a truncated export
+				s.log.Error("can't export blocks", "error", err)
 				return
 			}
-		case "order":
-			account := r.FormValue("account")
-			id := r.FormValue("id")
-			block, err := s.store.GetOrderHead(account, id)
-			if err == db.ErrNotFound {
-				serverError(w, "no order head found for account '"+account+"' and id '"+id+"'", http.StatusBadRequest)
+			if len(blocks) == 0 {
 				return
 			}
-			if err != nil {
-				serverError(w, "error getting block: "+err.Error(), http.StatusInternalServerError)
-				return
+			for _, b := range blocks {
+				if b.Sequence > last {
+					return
+				}
+				if err := enc.Encode(ExportRecord{
+					Type:     b.Type,
+					Hash:     b.Block.Hash(),
+					Sequence: b.Sequence,
+					Time:     b.Time,
+					Block:    b.Block,
+				}); err != nil {
+					s.log.Debug("export interrupted", "error", err)
+					return
+				}
 			}
-			if err := json.NewEncoder(w).Encode(block); err != nil {
-				serverError(w, "error encoding block: "+err.Error(), http.StatusInternalServerError)
-				return
+			since = blocks[len(blocks)-1].Sequence
+			if flusher != nil {
+				flusher.Flush()
 			}
-		default:
-			serverError(w, "invalid query type '"+t+"'", http.StatusBadRequest)
 		}
 	}
 }
 
-func (s *Server) handleBlocks() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.FormValue("stream") != "" {
-			s.blockStream.ServeHTTP(w, r)
-			return
+// blockFilter returns the filter and page size of a blocks request
+func blockFilter(r *http.Request) (db.BlockFilter, error) {
+	f := db.BlockFilter{
+		Account: r.FormValue("account"),
+		Token:   r.FormValue("token"),
+		Action:  r.FormValue("action"),
+		Limit:   defaultBlocksLimit,
+	}
+	if since := r.FormValue("since"); since != "" {
+		var err error
+		f.Since, err = strconv.Atoi(since)
+		if err != nil || f.Since < 0 {
+			return f, errors.New("query param 'since' must be a non-negative sequence")
 		}
-		switch r.Method {
-		case "GET":
-			t := r.FormValue("type")
-			switch t {
-			case "account":
-				result := make(map[string]tradeblocks.NetworkAccountBlock)
-				s.store.AccountBlocks(func(sequence int, b *tradeblocks.AccountBlock) bool {
-					hash := b.Hash()
-					result[hash] = tradeblocks.NetworkAccountBlock{
-						AccountBlock: b,
-						Sequence:     sequence,
-					}
-					return true
-				})
-				if err := json.NewEncoder(w).Encode(result); err != nil {
-					serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-			case "swap":
-				result := make(map[string]tradeblocks.NetworkSwapBlock)
-				s.store.SwapBlocks(func(sequence int, b *tradeblocks.SwapBlock) bool {
-					hash := b.Hash()
-					result[hash] = tradeblocks.NetworkSwapBlock{
-						SwapBlock: b,
-						Sequence:  sequence,
-					}
-					return true
-				})
-				if err := json.NewEncoder(w).Encode(result); err != nil {
-					serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-			case "order":
-				result := make(map[string]tradeblocks.NetworkOrderBlock)
-				s.store.OrderBlocks(func(sequence int, b *tradeblocks.OrderBlock) bool {
-					hash := b.Hash()
-					result[hash] = tradeblocks.NetworkOrderBlock{
-						OrderBlock: b,
-						Sequence:   sequence,
-					}
-					return true
-				})
-				if err := json.NewEncoder(w).Encode(result); err != nil {
-					serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-			default:
-				serverError(w, "invalid query type '"+t+"'", http.StatusBadRequest)
-			}
-
-		default:
-			serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	if limit := r.FormValue("limit"); limit != "" {
+		var err error
+		f.Limit, err = strconv.Atoi(limit)
+		if err != nil || f.Limit < 1 || f.Limit > maxBlocksLimit {
+			return f, errors.New("query param 'limit' must be between 1 and " + strconv.Itoa(maxBlocksLimit))
 		}
 	}
+	return f, nil
 }
 
 func (s *Server) handleOrders() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		base := r.FormValue("base")
 		ppu, err := strconv.ParseFloat(r.FormValue("ppu"), 64)
 		if err != nil {
-			serverError(w, err.Error(), http.StatusBadRequest)
+			s.serverError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		quote := r.FormValue("quote")
-		side := r.FormValue("side")
-		var result []*tradeblocks.OrderBlock
-		if side == "buy" {
-			s.store.MatchOrdersForBuy(base, ppu, quote, func(b *tradeblocks.OrderBlock) {
-				result = append(result, b)
-			})
-		} else if side == "sell" {
-			s.store.MatchOrdersForSell(base, ppu, quote, func(b *tradeblocks.OrderBlock) {
-				result = append(result, b)
-			})
-		} else {
-			serverError(w, "unknown 'side' param '"+side+"'", http.StatusBadRequest)
+		result, err := s.orders(r.FormValue("side"), r.FormValue("base"), ppu, r.FormValue("quote"))
+		if err != nil {
+			s.requestFailed(w, err)
 			return
 		}
 		if err := json.NewEncoder(w).Encode(result); err != nil {
-			serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
+			s.serverError(w, "error encoding blocks: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-// BroadcastBlock broadcasts the specified block to all event listeners
-func (s *Server) BroadcastBlock(b tradeblocks.Block) error {
-	e, err := blockEvent(b.Hash(), b)
+func (s *Server) handleHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			s.serverError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var limit int
+		if l := r.FormValue("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil {
+				s.serverError(w, "query param 'limit' must be an integer", http.StatusBadRequest)
+				return
+			}
+		}
+		result, err := s.history(r.FormValue("account"), r.FormValue("token"), r.FormValue("cursor"), limit)
+		if err != nil {
+			s.requestFailed(w, err)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			s.serverError(w, "error encoding history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// requestError is an error caused by a request rather than the node
+type requestError struct {
+	status  int // HTTP status of the response
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &requestError{http.StatusBadRequest, message}
+}
+
+func notFound(message string) error {
+	return &requestError{http.StatusNotFound, message}
+}
+
+// block returns the tag and block with the specified hash
+func (s *Server) block(hash string) (int, tradeblocks.Block, error) {
+	tag, b, err := s.store.BlockWithTag(hash)
+	if err == db.ErrNotFound {
+		return 0, nil, notFound("no block found with hash '" + hash + "'")
+	}
 	if err != nil {
+		return 0, nil, fmt.Errorf("error getting block: %s", err.Error())
+	}
+	return tag, b, nil
+}
+
+// head returns the head of the chain of type t ("account", "swap" or "order") with the specified account and
+// key, which is the token of an account chain and the id of the others
+func (s *Server) head(t, account, key string) (tradeblocks.Block, error) {
+	var b tradeblocks.Block
+	var err error
+	var name string
+	switch t {
+	case "account":
+		b, err = s.store.GetAccountHead(account, key)
+		name = "token"
+	case "swap":
+		b, err = s.store.GetSwapHead(account, key)
+		name = "id"
+	case "order":
+		b, err = s.store.GetOrderHead(account, key)
+		name = "id"
+	default:
+		return nil, badRequest("invalid query type '" + t + "'")
+	}
+	if err == db.ErrNotFound {
+		return nil, notFound("no " + t + " head found for account '" + account + "' and " + name + " '" + key + "'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting block: %s", err.Error())
+	}
+	return b, nil
+}
+
+// validate returns whether the specified block would be added to the store
+func (s *Server) validate(b tradeblocks.Block) (*ValidateResult, error) {
+	result := &ValidateResult{Hash: b.Hash()}
+	if err := s.store.CheckBlock(b); err != nil {
+		e, ok := err.(*app.ValidationError)
+		if !ok {
+			return nil, fmt.Errorf("error validating block: %s", err.Error())
+		}
+		result.Error = e
+	}
+	result.Valid = result.Error == nil
+	return result, nil
+}
+
+// addBatch adds a batch of blocks in order, all or none of them, or only validates them if dryRun is true
+func (s *Server) addBatch(batch []tradeblocks.NetworkBlock, dryRun bool) (*BatchResult, error) {
+	if len(batch) == 0 || len(batch) > maxBatchSize {
+		return nil, badRequest("batch must have between 1 and " + strconv.Itoa(maxBatchSize) + " blocks")
+	}
+	blocks := make([]tradeblocks.Block, len(batch))
+	result := &BatchResult{Hashes: make([]string, len(batch))}
+	for i, nb := range batch {
+		if nb.Type == "confirm" {
+			return nil, badRequest("batch can't have confirm blocks")
+		}
+		blocks[i] = nb.Block
+		result.Hashes[i] = nb.Block.Hash()
+	}
+	add := s.store.AddBlocks
+	if dryRun {
+		add = s.store.CheckBlocks
+	}
+	if err := add(blocks); err != nil {
+		if be, ok := err.(*app.BatchError); ok && !dryRun {
+			s.log.With(logging.Block(blocks[be.Index])...).Info("batch rejected", "index", be.Index, "code", rejectionCode(be.Err), "error", be.Err)
+		}
+		return nil, err
+	}
+	if !dryRun {
+		for _, b := range blocks {
+			s.log.With(logging.Block(b)...).Debug("block added", "type", blockType(b))
+		}
+	}
+	return result, nil
+}
+
+// orders returns the orders that match a buy or sell of base at ppu in quote
+func (s *Server) orders(side, base string, ppu float64, quote string) ([]*tradeblocks.OrderBlock, error) {
+	var match func(base string, ppu float64, quote string, f func(b *tradeblocks.OrderBlock)) error
+	switch side {
+	case "buy":
+		match = s.store.MatchOrdersForBuy
+	case "sell":
+		match = s.store.MatchOrdersForSell
+	default:
+		return nil, badRequest("unknown 'side' param '" + side + "'")
+	}
+	var result []*tradeblocks.OrderBlock
+	if err := match(base, ppu, quote, func(b *tradeblocks.OrderBlock) {
+		result = append(result, b)
+	}); err != nil {
+		return nil, fmt.Errorf("error matching orders: %s", err.Error())
+	}
+	return result, nil
+}
+
+// history returns a page of an account-token chain. A zero limit uses the default page size.
+func (s *Server) history(account, token, cursor string, limit int) (*app.AccountHistory, error) {
+	if account == "" || token == "" {
+		return nil, badRequest("missing query param 'account' or 'token'")
+	}
+	if limit == 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit < 1 || limit > maxHistoryLimit {
+		return nil, badRequest("query param 'limit' must be between 1 and " + strconv.Itoa(maxHistoryLimit))
+	}
+	result, err := s.store.AccountHistory(account, token, cursor, limit)
+	if err == db.ErrNotFound {
+		return nil, notFound("no account head found for account '" + account + "' and token '" + token + "'")
+	}
+	if err == app.ErrInvalidCursor {
+		return nil, badRequest("invalid cursor '" + cursor + "'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting history: %s", err.Error())
+	}
+	return result, nil
+}
+
+// newBlock returns an empty block of type t ("account", "swap" or "order"), or nil for other types
+func newBlock(t string) tradeblocks.Block {
+	switch t {
+	case "account":
+		return &tradeblocks.AccountBlock{}
+	case "swap":
+		return &tradeblocks.SwapBlock{}
+	case "order":
+		return &tradeblocks.OrderBlock{}
+	}
+	return nil
+}
+
+// addBlock validates and adds an account, swap or order block to the store
+func (s *Server) addBlock(b tradeblocks.Block) error {
+	err := s.storeBlock(b)
+	if err != nil {
+		s.log.With(logging.Block(b)...).Info("block rejected", "type", blockType(b), "code", rejectionCode(err), "error", err)
 		return err
 	}
-	s.blockStream.broadcast <- e
+	s.log.With(logging.Block(b)...).Debug("block added", "type", blockType(b))
 	return nil
 }
 
-func blockEvent(hash string, b tradeblocks.Block) (event, error) {
+func (s *Server) storeBlock(b tradeblocks.Block) error {
+	switch b := b.(type) {
+	case *tradeblocks.AccountBlock:
+		return s.store.AddAccountBlock(b)
+	case *tradeblocks.SwapBlock:
+		return s.store.AddSwapBlock(b)
+	case *tradeblocks.OrderBlock:
+		return s.store.AddOrderBlock(b)
+	}
+	return fmt.Errorf("web: can't add block type %T", b)
+}
+
+// blockAdded calls BlockHandler with a block of type t that was added to the store
+func (s *Server) blockAdded(t string, b tradeblocks.Block) {
+	if s.BlockHandler == nil {
+		return
+	}
+	tb := app.TypedBlock{T: t}
+	switch b := b.(type) {
+	case *tradeblocks.AccountBlock:
+		tb.AccountBlock = b
+	case *tradeblocks.SwapBlock:
+		tb.SwapBlock = b
+	case *tradeblocks.OrderBlock:
+		tb.OrderBlock = b
+	case *tradeblocks.ConfirmBlock:
+		tb.ConfirmBlock = b
+	}
+	s.BlockHandler(tb)
+}
+
+// BroadcastBlock broadcasts the specified block to stream subscribers, and every block added to the store since
+// the last broadcast to event listeners in sequence order
+func (s *Server) BroadcastBlock(b tradeblocks.Block) error {
+	s.stream.publish(blockType(b), b)
+	return s.blockStream.broadcast()
+}
+
+// blockType returns the type name of a block
+func blockType(b tradeblocks.Block) string {
+	switch b.(type) {
+	case *tradeblocks.AccountBlock:
+		return "account"
+	case *tradeblocks.SwapBlock:
+		return "swap"
+	case *tradeblocks.OrderBlock:
+		return "order"
+	case *tradeblocks.ConfirmBlock:
+		return "confirm"
+	}
+	return ""
+}
+
+func blockEvent(sequence int, b tradeblocks.Block) (event, error) {
 	var res struct {
 		tradeblocks.Block
 		Hash string
 	}
 	res.Block = b
-	res.Hash = hash
-	return json.Marshal(res)
+	res.Hash = b.Hash()
+	data, err := json.Marshal(res)
+	if err != nil {
+		return event{}, err
+	}
+	return event{id: sequence, data: data}, nil
 }
 
-func serverError(w http.ResponseWriter, error string, code int) {
-	log.Printf("web: %s", error)
+// serverError responds with an error message. Server errors are logged as errors, and client errors for debugging.
+func (s *Server) serverError(w http.ResponseWriter, error string, code int) {
+	level := logging.LevelDebug
+	if code >= http.StatusInternalServerError {
+		level = logging.LevelError
+	}
+	s.log.Log(level, "request failed", "status", code, "error", error)
 	http.Error(w, error, code)
 }
+
+// rejectionCode returns the code of a validation error, or "error" for any other error
+func rejectionCode(err error) string {
+	if e, ok := err.(*app.ValidationError); ok {
+		return e.Code
+	}
+	return "error"
+}
+
+// blockError responds to a request with a block that can't be added. A validation error is sent as a JSON body,
+// so clients can handle it by its code, and any other error is an internal server error.
+func (s *Server) blockError(w http.ResponseWriter, prefix string, err error) {
+	e, ok := err.(*app.ValidationError)
+	if !ok {
+		s.serverError(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(e)
+}
+
+// requestFailed responds to a request with the status of a request error, the body of a validation or batch
+// error, or an internal server error
+func (s *Server) requestFailed(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *requestError:
+		s.serverError(w, e.message, e.status)
+	case *app.ValidationError:
+		s.blockError(w, "invalid block", e)
+	case *app.BatchError:
+		s.batchError(w, e)
+	default:
+		s.serverError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// batchError responds to a batch with a block that can't be added. A validation error is sent as a JSON body
+// with the position and hash of the invalid block, and any other error is an internal server error.
+func (s *Server) batchError(w http.ResponseWriter, err error) {
+	be, ok := err.(*app.BatchError)
+	if !ok {
+		s.serverError(w, "can't add batch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e, ok := be.Err.(*app.ValidationError)
+	if !ok {
+		s.serverError(w, "can't add batch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(batchFailure{
+		Index: be.Index,
+		Hash:  be.Hash,
+		Error: e,
+	})
+}
@@ -8,7 +8,7 @@ export default class BlockDataController extends Component {
     }
 
     componentDidMount() {
-        var accountsSource = new EventSource("http://localhost:8080/blocks?stream=1", {mode: 'no-cors'} )
+        var accountsSource = new EventSource("http://localhost:8080/blocks?stream=1&last_event_id=0", {mode: 'no-cors'} )
         accountsSource.onmessage = function(message) {
             var blockJson = JSON.parse(message.data)
             var block = blockJson.Block
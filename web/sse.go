@@ -3,46 +3,73 @@ package web
 import (
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/jephir/tradeblocks/logging"
 )
 
+// sseBufferSize is the number of events queued for an event listener before it's disconnected
+const sseBufferSize = 256
+
 // event represents an SSE event
-type event []byte
+type event struct {
+	id   int // sequence of the block
+	data []byte
+}
 
-// https://robots.thoughtbot.com/writing-a-server-sent-events-server-in-go
+// sse sends events to listeners in id order. New listeners receive the events after they connect. Listeners
+// resume after a disconnect by sending the id of the last event they received in the Last-Event-ID header.
 type sse struct {
-	broadcast      chan event
-	newClient      chan chan event
-	closeClient    chan chan event
-	clients        map[chan event]struct{}
-	connectHandler func() []event
+	mu      sync.Mutex
+	clients map[*sseClient]struct{}
+	last    int // id of the last broadcast event
+	log     *logging.Logger
+
+	// events calls f with every event after the specified id in order until f returns false
+	events func(since int, f func(e event) bool) error
+}
+
+type sseClient struct {
+	events    chan event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *sseClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
 }
 
-func newSSE() *sse {
-	s := &sse{
-		broadcast:   make(chan event, 1),
-		newClient:   make(chan chan event),
-		closeClient: make(chan chan event),
-		clients:     make(map[chan event]struct{}),
+func newSSE(last int, events func(since int, f func(e event) bool) error) *sse {
+	return &sse{
+		clients: make(map[*sseClient]struct{}),
+		last:    last,
+		events:  events,
+		log:     logging.Default("web"),
 	}
-	go s.listen()
-	return s
 }
 
-func (s *sse) listen() {
-	for {
-		select {
-		case e := <-s.newClient:
-			s.clients[e] = struct{}{}
-		case e := <-s.closeClient:
-			delete(s.clients, e)
-		case e := <-s.broadcast:
-			for c := range s.clients {
-				c <- e
+// broadcast sends the events after the last broadcast event to every listener. Listeners whose buffer is full are
+// disconnected instead of slowing down everyone else.
+func (s *sse) broadcast() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events(s.last, func(e event) bool {
+		s.last = e.id
+		for c := range s.clients {
+			select {
+			case c.events <- e:
+			default:
+				s.log.Warn("disconnecting lagging event listener", "buffered", sseBufferSize)
+				delete(s.clients, c)
+				c.close()
 			}
 		}
-	}
+		return true
+	})
 }
 
 func (s *sse) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -51,6 +78,12 @@ func (s *sse) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, "Streaming not supported.", http.StatusInternalServerError)
 		return
 	}
+	since, resume, err := lastEventID(r)
+	if err != nil {
+		s.log.Debug("request failed", "status", http.StatusBadRequest, "error", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Set the headers related to event streaming.
 	rw.Header().Set("Content-Type", "text/event-stream")
@@ -58,44 +91,82 @@ func (s *sse) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Connection", "keep-alive")
 	rw.Header().Set("Access-Control-Allow-Origin", "*")
 
-	c := make(chan event)
-	s.newClient <- c
-
-	// Remove this client from the map of connected clients
-	// when this handler exits.
+	// Register before replaying, so every event after the replay is queued
+	c := &sseClient{
+		events: make(chan event, sseBufferSize),
+		done:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.clients[c] = struct{}{}
+	live := s.last
+	s.mu.Unlock()
+	if !resume {
+		since = live
+	}
+	sseClients.add(c, func() int { return len(c.events) })
 	defer func() {
-		s.closeClient <- c
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		sseClients.remove(c)
+		c.close()
 	}()
 
-	// Listen to connection close and un-register client
-	if notifier, ok := rw.(http.CloseNotifier); ok {
-		n := notifier.CloseNotify()
-		go func() {
-			<-n
-			s.closeClient <- c
-		}()
-	}
-
-	// Send initial data
-	if s.connectHandler != nil {
-		for _, e := range s.connectHandler() {
-			writeSSE(rw, e)
+	// Send the events that the listener missed
+	var werr error
+	if err := s.events(since, func(e event) bool {
+		if e.id > live {
+			return false
 		}
-		f.Flush()
+		werr = writeSSE(rw, e)
+		return werr == nil
+	}); err != nil {
+		s.log.Error("can't replay events", "error", err)
+		return
+	}
+	if werr != nil {
+		return
 	}
+	f.Flush()
 
 	for {
-		// Write to the ResponseWriter
-		writeSSE(rw, <-c)
+		select {
+		case e := <-c.events:
+			if e.id <= since {
+				continue
+			}
+			if err := writeSSE(rw, e); err != nil {
+				return
+			}
 
-		// Flush the data immediatly instead of buffering it for later.
-		f.Flush()
+			// Flush the data immediatly instead of buffering it for later.
+			f.Flush()
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
 	}
 }
 
-func writeSSE(w io.Writer, e event) {
-	// Server Sent Events compatible
-	if _, err := fmt.Fprintf(w, "data: %s\n\n", e); err != nil {
-		log.Println(err)
+// lastEventID returns the id in the Last-Event-ID header or last_event_id query param, and whether there is one
+func lastEventID(r *http.Request) (int, bool, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.FormValue("last_event_id")
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return 0, false, fmt.Errorf("invalid last event id '%s'", s)
 	}
+	return id, true, nil
+}
+
+func writeSSE(w io.Writer, e event) error {
+	// Server Sent Events compatible
+	_, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.id, e.data)
+	return err
 }
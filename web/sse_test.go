@@ -1,9 +1,10 @@
 package web
 
 import (
-	"io/ioutil"
+	"bufio"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -20,39 +21,156 @@ func TestSSE(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-
 	if err := store.AddAccountBlock(issue); err != nil {
 		t.Fatal(err)
 	}
 	srv := NewServer(store)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
 
-	// Send request
-	req := httptest.NewRequest("GET", "/blocks", nil)
-	q := req.URL.Query()
-	q.Add("stream", "1")
-	req.URL.RawQuery = q.Encode()
+	// Existing blocks aren't replayed to a new listener
+	res, r := getEvents(t, ts.URL, "")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code, got: %d, want: %d", res.StatusCode, http.StatusOK)
+	}
 
-	w := httptest.NewRecorder()
+	// New blocks are sent with their sequence
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 10), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAccountBlock(send); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.BroadcastBlock(send); err != nil {
+		t.Fatal(err)
+	}
+	if id, data := readEvent(t, r); id != 2 || !strings.Contains(data, send.Hash()) {
+		t.Fatalf("expected event 2 with hash %s, got event %d: %s", send.Hash(), id, data)
+	}
+}
 
-	// Run test
-	go func() {
-		srv.ServeHTTP(w, req)
-	}()
-	for !w.Flushed {
+func TestSSEResume(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	store := app.NewBlockStore()
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
 	}
-	for c := range srv.blockStream.clients {
-		srv.blockStream.closeClient <- c
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 10), p)
+	if err != nil {
+		t.Fatal(err)
 	}
-	res := w.Result()
-	if res.StatusCode != http.StatusOK {
-		t.Fatalf("Unexpected status code, got: %d, want: %d", res.StatusCode, http.StatusOK)
+	if err := store.AddBlocks([]tradeblocks.Block{issue, send}); err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(store)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// Only blocks after the last event are sent
+	res, r := getEvents(t, ts.URL, "1")
+	defer res.Body.Close()
+	if id, data := readEvent(t, r); id != 2 || !strings.Contains(data, send.Hash()) {
+		t.Fatalf("expected event 2 with hash %s, got event %d: %s", send.Hash(), id, data)
+	}
+
+	// Blocks added without a broadcast are sent with the next broadcast
+	send2, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(send, a, 10), p)
+	if err != nil {
+		t.Fatal(err)
 	}
-	b, err := ioutil.ReadAll(res.Body)
+	send3, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(send2, a, 10), p)
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := strings.TrimSpace(string(b))
-	if !strings.Contains(got, issue.Hash()) {
-		t.Fatalf("Response missing hash %s; got: %s", issue.Hash(), got)
+	if err := store.AddBlocks([]tradeblocks.Block{send2, send3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.BroadcastBlock(send3); err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range []tradeblocks.Block{send2, send3} {
+		if id, data := readEvent(t, r); id != i+3 || !strings.Contains(data, b.Hash()) {
+			t.Fatalf("expected event %d with hash %s, got event %d: %s", i+3, b.Hash(), id, data)
+		}
+	}
+
+	// A last event id of zero replays every block
+	res3, r3 := getEvents(t, ts.URL, "0")
+	defer res3.Body.Close()
+	for i, b := range []tradeblocks.Block{issue, send, send2, send3} {
+		if id, data := readEvent(t, r3); id != i+1 || !strings.Contains(data, b.Hash()) {
+			t.Fatalf("expected event %d with hash %s, got event %d: %s", i+1, b.Hash(), id, data)
+		}
+	}
+
+	// Invalid ids are rejected
+	res2, _ := getEvents(t, ts.URL, "x")
+	res2.Body.Close()
+	if res2.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res2.StatusCode)
+	}
+}
+
+func TestSSELaggingClient(t *testing.T) {
+	s := newSSE(0, func(since int, f func(e event) bool) error {
+		f(event{id: since + 1, data: []byte("{}")})
+		return nil
+	})
+	c := &sseClient{
+		events: make(chan event), // always full
+		done:   make(chan struct{}),
+	}
+	s.clients[c] = struct{}{}
+	if err := s.broadcast(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.clients[c]; ok {
+		t.Fatal("expected a lagging client to be removed")
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("expected a lagging client to be closed")
+	}
+	if s.last != 1 {
+		t.Fatalf("expected last event 1, got %d", s.last)
+	}
+}
+
+func getEvents(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest("GET", url+"/blocks?stream=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, bufio.NewReader(res.Body)
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) (id int, data string) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return
+		case strings.HasPrefix(line, "id: "):
+			if id, err = strconv.Atoi(line[len("id: "):]); err != nil {
+				t.Fatal(err)
+			}
+		case strings.HasPrefix(line, "data: "):
+			data = line[len("data: "):]
+		}
 	}
 }
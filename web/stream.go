@@ -0,0 +1,365 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/logging"
+)
+
+const (
+	// streamBufferSize is the number of messages queued for a stream client before it's disconnected
+	streamBufferSize = 256
+
+	// maxStreamTopics is the largest number of topics a stream client can subscribe to
+	maxStreamTopics = 100
+)
+
+// StreamRequest is a message from a stream client. See the WebSocket API section of the README for the protocol.
+type StreamRequest struct {
+	ID     string            // echoed in the reply
+	Method string            // "subscribe", "unsubscribe" or "submit"
+	Topic  string            `json:",omitempty"` // topic to subscribe to or unsubscribe from
+	Type   string            `json:",omitempty"` // type of the submitted block
+	Block  tradeblocks.Block `json:",omitempty"` // block to submit
+}
+
+// UnmarshalJSON decodes the block into the concrete block type named by Type
+func (m *StreamRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID     string
+		Method string
+		Topic  string
+		Type   string
+		Block  json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	m.ID = raw.ID
+	m.Method = raw.Method
+	m.Topic = raw.Topic
+	m.Type = raw.Type
+	m.Block = nil
+	if len(raw.Block) > 0 && string(raw.Block) != "null" {
+		b := newBlock(raw.Type)
+		if b == nil {
+			return fmt.Errorf("web: invalid block type '%s'", raw.Type)
+		}
+		if err := json.Unmarshal(raw.Block, b); err != nil {
+			return err
+		}
+		m.Block = b
+	}
+	return nil
+}
+
+// StreamReply is the reply to a stream request
+type StreamReply struct {
+	ID      string
+	Hash    string               `json:",omitempty"` // hash of the submitted block
+	Error   string               `json:",omitempty"` // why the request failed
+	Invalid *app.ValidationError `json:",omitempty"` // why the submitted block is invalid
+}
+
+// StreamEvent is a new block that matches the topics of a stream client
+type StreamEvent struct {
+	Topics []string // subscribed topics that the block matches
+	Type   string
+	Hash   string
+	Block  tradeblocks.Block
+}
+
+// UnmarshalJSON decodes the block into the concrete block type named by Type
+func (e *StreamEvent) UnmarshalJSON(data []byte) error {
+	var nb tradeblocks.NetworkBlock
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return err
+	}
+	var raw struct {
+		Topics []string
+		Hash   string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	e.Topics = raw.Topics
+	e.Type = nb.Type
+	e.Hash = raw.Hash
+	e.Block = nb.Block
+	return nil
+}
+
+// topic selects the blocks that a stream client receives
+type topic struct {
+	kind string // "all", "account", "token", "book" or "confirm"
+	a, b string
+}
+
+// parseTopic parses a topic: all, account:<address>, token:<token>, book:<base>/<quote> or confirm:<hash>
+func parseTopic(s string) (topic, error) {
+	if s == "all" {
+		return topic{kind: "all"}, nil
+	}
+	i := strings.Index(s, ":")
+	if i < 0 || i == len(s)-1 {
+		return topic{}, fmt.Errorf("web: invalid topic '%s'", s)
+	}
+	t := topic{kind: s[:i], a: s[i+1:]}
+	switch t.kind {
+	case "account", "token", "confirm":
+		return t, nil
+	case "book":
+		pair := strings.Split(t.a, "/")
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return topic{}, fmt.Errorf("web: book topic must be book:<base>/<quote>, got '%s'", s)
+		}
+		t.a, t.b = pair[0], pair[1]
+		return t, nil
+	}
+	return topic{}, fmt.Errorf("web: unknown topic '%s'", t.kind)
+}
+
+// matches returns whether a block belongs to this topic
+func (t topic) matches(b tradeblocks.Block) bool {
+	switch t.kind {
+	case "all":
+		return true
+	case "account":
+		switch b := b.(type) {
+		case *tradeblocks.AccountBlock:
+			return b.Account == t.a || (b.Action == "send" && b.Link == t.a)
+		case *tradeblocks.SwapBlock:
+			return b.Account == t.a || b.Counterparty == t.a
+		case *tradeblocks.OrderBlock:
+			return b.Account == t.a
+		}
+	case "token":
+		switch b := b.(type) {
+		case *tradeblocks.AccountBlock:
+			return b.Token == t.a
+		case *tradeblocks.SwapBlock:
+			return b.Token == t.a || b.Want == t.a
+		case *tradeblocks.OrderBlock:
+			return b.Token == t.a || b.Quote == t.a
+		}
+	case "book":
+		if b, ok := b.(*tradeblocks.OrderBlock); ok {
+			return (b.Token == t.a && b.Quote == t.b) || (b.Token == t.b && b.Quote == t.a)
+		}
+	case "confirm":
+		if b, ok := b.(*tradeblocks.ConfirmBlock); ok {
+			return b.Head == t.a
+		}
+	}
+	return false
+}
+
+// stream sends new blocks to WebSocket clients by topic
+type stream struct {
+	mu      sync.Mutex
+	clients map[*streamClient]map[string]topic
+	log     *logging.Logger
+}
+
+func newStream() *stream {
+	return &stream{
+		clients: make(map[*streamClient]map[string]topic),
+		log:     logging.Default("web"),
+	}
+}
+
+type streamClient struct {
+	log       *logging.Logger
+	conn      *wsConn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// close stops the client and drops its connection without waiting for a pending write
+func (c *streamClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.conn.Close()
+	})
+}
+
+// write sends queued messages until the client is closed
+func (c *streamClient) write() {
+	for {
+		select {
+		case m := <-c.send:
+			if err := c.conn.WriteMessage(m); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reply queues a reply, waiting if the buffer of the client is full
+func (c *streamClient) reply(r StreamReply) {
+	m, err := json.Marshal(r)
+	if err != nil {
+		c.log.Error("can't encode stream reply", "error", err)
+		return
+	}
+	select {
+	case c.send <- m:
+	case <-c.done:
+	}
+}
+
+// errStreamClientRemoved is returned for a request of a client that was disconnected, such as for lagging
+var errStreamClientRemoved = errors.New("web: stream client was disconnected")
+
+func (s *stream) subscribe(c *streamClient, name string, t topic) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics, ok := s.clients[c]
+	if !ok {
+		return errStreamClientRemoved
+	}
+	if _, ok := topics[name]; !ok && len(topics) >= maxStreamTopics {
+		return fmt.Errorf("web: can't subscribe to more than %d topics", maxStreamTopics)
+	}
+	topics[name] = t
+	return nil
+}
+
+func (s *stream) unsubscribe(c *streamClient, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics, ok := s.clients[c]
+	if !ok {
+		return errStreamClientRemoved
+	}
+	delete(topics, name)
+	return nil
+}
+
+// publish sends a block to every client subscribed to a matching topic. Clients whose buffer is full are
+// disconnected instead of slowing down everyone else.
+func (s *stream) publish(t string, b tradeblocks.Block) {
+	hash := b.Hash()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, topics := range s.clients {
+		var matched []string
+		for name, topic := range topics {
+			if topic.matches(b) {
+				matched = append(matched, name)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		sort.Strings(matched)
+		m, err := json.Marshal(StreamEvent{
+			Topics: matched,
+			Type:   t,
+			Hash:   hash,
+			Block:  b,
+		})
+		if err != nil {
+			s.log.Error("can't encode stream event", "error", err)
+			continue
+		}
+		select {
+		case c.send <- m:
+		default:
+			s.log.Warn("disconnecting lagging stream client", "buffered", streamBufferSize)
+			delete(s.clients, c)
+			c.close()
+		}
+	}
+}
+
+// handleStream upgrades a request to a WebSocket connection and serves the stream protocol on it
+func (s *Server) handleStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrade(w, r)
+		if err != nil {
+			s.serverError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c := &streamClient{
+			log:  s.log,
+			conn: conn,
+			send: make(chan []byte, streamBufferSize),
+			done: make(chan struct{}),
+		}
+		s.stream.mu.Lock()
+		s.stream.clients[c] = make(map[string]topic)
+		s.stream.mu.Unlock()
+		streamClients.add(c, func() int { return len(c.send) })
+		defer func() {
+			s.stream.mu.Lock()
+			delete(s.stream.clients, c)
+			s.stream.mu.Unlock()
+			streamClients.remove(c)
+			c.close()
+		}()
+		go c.write()
+
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req StreamRequest
+			if err := json.Unmarshal(data, &req); err != nil {
+				c.reply(StreamReply{Error: "invalid request: " + err.Error()})
+				continue
+			}
+			s.handleStreamRequest(c, &req)
+		}
+	}
+}
+
+func (s *Server) handleStreamRequest(c *streamClient, req *StreamRequest) {
+	reply := StreamReply{ID: req.ID}
+	switch req.Method {
+	case "subscribe":
+		t, err := parseTopic(req.Topic)
+		if err == nil {
+			err = s.stream.subscribe(c, req.Topic, t)
+		}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+	case "unsubscribe":
+		if err := s.stream.unsubscribe(c, req.Topic); err != nil {
+			reply.Error = err.Error()
+		}
+	case "submit":
+		if req.Block == nil {
+			reply.Error = "missing block"
+			break
+		}
+		if err := s.addBlock(req.Block); err != nil {
+			if e, ok := err.(*app.ValidationError); ok {
+				reply.Invalid = e
+			}
+			reply.Error = err.Error()
+			break
+		}
+		reply.Hash = req.Block.Hash()
+		c.reply(reply)
+		s.blockAdded(req.Type, req.Block)
+		return
+	default:
+		reply.Error = fmt.Sprintf("unknown method '%s'", req.Method)
+	}
+	c.reply(reply)
+}
@@ -0,0 +1,222 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jephir/tradeblocks"
+	"github.com/jephir/tradeblocks/app"
+)
+
+func TestStream(t *testing.T) {
+	p1, a1 := app.CreateAccount(t)
+	p2, a2 := app.CreateAccount(t)
+	srv := NewServer(app.NewBlockStore())
+	srv.BlockHandler = func(b app.TypedBlock) {
+		switch b.T {
+		case "account":
+			srv.BroadcastBlock(b.AccountBlock)
+		}
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	s, err := NewClient(ts.URL).DialStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Subscribe("account:" + a1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe("book:" + a1); err == nil {
+		t.Fatal("expected an error for a book topic without a quote")
+	}
+
+	next := func() *StreamEvent {
+		select {
+		case e := <-s.Events():
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+		return nil
+	}
+
+	// Blocks of other accounts aren't sent
+	other, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a2, 100), p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Submit("account", other); err != nil {
+		t.Fatal(err)
+	}
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a1, 100), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := s.Submit("account", issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != issue.Hash() {
+		t.Fatalf("expected hash %s, got %s", issue.Hash(), hash)
+	}
+	e := next()
+	if e.Hash != issue.Hash() || e.Type != "account" || len(e.Topics) != 1 || e.Topics[0] != "account:"+a1 {
+		t.Fatalf("expected event for %s on account:%s, got %+v", issue.Hash(), a1, e)
+	}
+	if e.Block.Hash() != issue.Hash() {
+		t.Fatalf("expected block %s, got %s", issue.Hash(), e.Block.Hash())
+	}
+
+	// Invalid blocks return their validation error
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a2, 200), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Submit("account", send)
+	if ve, ok := err.(*app.ValidationError); !ok || ve.Code != app.CodeNegativeBalance {
+		t.Fatalf("expected %s, got %v", app.CodeNegativeBalance, err)
+	}
+
+	// Sends to the account are sent to its subscribers
+	send, err = tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(other, a1, 10), p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Submit("account", send); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Hash != send.Hash() {
+		t.Fatalf("expected event for %s, got %+v", send.Hash(), e)
+	}
+
+	// Blocks of an unsubscribed account aren't sent
+	if err := s.Unsubscribe("account:" + a1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe("token:" + a2); err != nil {
+		t.Fatal(err)
+	}
+	send1, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a1, 10), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Submit("account", send1); err != nil {
+		t.Fatal(err)
+	}
+	send2, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(send, a2, 10), p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Submit("account", send2); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Hash != send2.Hash() || e.Topics[0] != "token:"+a2 {
+		t.Fatalf("expected event for %s on token:%s, got %+v", send2.Hash(), a2, e)
+	}
+}
+
+func TestStreamLaggingClient(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	s := newStream()
+	c := &streamClient{
+		conn: &wsConn{conn: c1},
+		send: make(chan []byte), // always full
+		done: make(chan struct{}),
+	}
+	s.clients[c] = map[string]topic{"all": {kind: "all"}}
+	s.publish("account", issue)
+	if _, ok := s.clients[c]; ok {
+		t.Fatal("expected a lagging client to be removed")
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("expected a lagging client to be closed")
+	}
+
+	// Requests that were already being handled fail instead of subscribing the removed client
+	if err := s.subscribe(c, "all", topic{kind: "all"}); err != errStreamClientRemoved {
+		t.Fatalf("expected %v, got %v", errStreamClientRemoved, err)
+	}
+	if err := s.unsubscribe(c, "all"); err != errStreamClientRemoved {
+		t.Fatalf("expected %v, got %v", errStreamClientRemoved, err)
+	}
+	if _, ok := s.clients[c]; ok {
+		t.Fatal("expected a removed client to stay removed")
+	}
+}
+
+func TestStreamUnreadEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		for i := 0; i <= streamBufferSize; i++ {
+			if err := conn.WriteMessage([]byte(`{"Topics":["all"],"Type":"account","Block":{}}`)); err != nil {
+				return
+			}
+		}
+		conn.WriteMessage([]byte(`{"ID":"1"}`))
+		conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	s, err := NewClient(ts.URL).DialStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A request doesn't wait forever behind events that aren't received
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Subscribe("all")
+	}()
+	select {
+	case err := <-done:
+		if err != errStreamLagging {
+			t.Fatalf("expected %v, got %v", errStreamLagging, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request to fail")
+	}
+}
+
+func TestParseTopic(t *testing.T) {
+	for _, test := range []struct {
+		topic string
+		valid bool
+	}{
+		{"all", true},
+		{"account:xtb:abc", true},
+		{"token:xtb:abc", true},
+		{"book:xtb:abc/xtb:def", true},
+		{"confirm:abc", true},
+		{"book:xtb:abc", false},
+		{"account:", false},
+		{"blocks", false},
+		{"height:1", false},
+	} {
+		if _, err := parseTopic(test.topic); (err == nil) != test.valid {
+			t.Errorf("topic %s: expected valid %t, got error %v", test.topic, test.valid, err)
+		}
+	}
+}
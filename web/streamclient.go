@@ -0,0 +1,174 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/jephir/tradeblocks"
+)
+
+// Stream is a connection to the WebSocket stream API of a node. Events must be received from Events; the stream
+// ends with errStreamLagging when its buffer fills up, so replies to requests aren't held up behind events.
+type Stream struct {
+	conn   *wsConn
+	events chan *StreamEvent
+	done   chan struct{}
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan *StreamReply
+	err     error
+}
+
+// errStreamLagging ends a stream whose events weren't received
+var errStreamLagging = errors.New("client: stream events weren't received")
+
+// DialStream connects to the stream API of the node. The context only limits connecting.
+func (c *Client) DialStream(ctx context.Context) (*Stream, error) {
+	u, err := url.Parse(c.base)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/stream"
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	conn, err := dialWebsocket(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{
+		conn:    conn,
+		events:  make(chan *StreamEvent, streamBufferSize),
+		done:    make(chan struct{}),
+		pending: make(map[string]chan *StreamReply),
+	}
+	go s.read()
+	return s, nil
+}
+
+// Events returns the channel of blocks that match the subscribed topics, which is closed when the stream ends
+func (s *Stream) Events() <-chan *StreamEvent {
+	return s.events
+}
+
+// Err returns why the stream ended, or nil if it's still open
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Subscribe adds a topic: all, account:<address>, token:<token>, book:<base>/<quote> or confirm:<hash>
+func (s *Stream) Subscribe(topic string) error {
+	_, err := s.request(StreamRequest{Method: "subscribe", Topic: topic})
+	return err
+}
+
+// Unsubscribe removes a topic
+func (s *Stream) Unsubscribe(topic string) error {
+	_, err := s.request(StreamRequest{Method: "unsubscribe", Topic: topic})
+	return err
+}
+
+// Submit adds a block of type t ("account", "swap" or "order") to the node and returns its hash. If the block is
+// invalid, the error is an *app.ValidationError.
+func (s *Stream) Submit(t string, b tradeblocks.Block) (string, error) {
+	reply, err := s.request(StreamRequest{Method: "submit", Type: t, Block: b})
+	if err != nil {
+		return "", err
+	}
+	return reply.Hash, nil
+}
+
+// Close closes the stream
+func (s *Stream) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Stream) request(req StreamRequest) (*StreamReply, error) {
+	c := make(chan *StreamReply, 1)
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.nextID++
+	req.ID = strconv.Itoa(s.nextID)
+	s.pending[req.ID] = c
+	s.mu.Unlock()
+
+	m, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.conn.WriteMessage(m); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-c:
+		if reply.Invalid != nil {
+			return nil, reply.Invalid
+		}
+		if reply.Error != "" {
+			return nil, errors.New(reply.Error)
+		}
+		return reply, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// read dispatches replies and events until the connection ends
+func (s *Stream) read() {
+	var err error
+	for {
+		var m []byte
+		if m, err = s.conn.ReadMessage(); err != nil {
+			break
+		}
+		var probe struct {
+			ID     string
+			Topics []string
+		}
+		if err = json.Unmarshal(m, &probe); err != nil {
+			break
+		}
+		if len(probe.Topics) > 0 {
+			var e StreamEvent
+			if err = json.Unmarshal(m, &e); err != nil {
+				break
+			}
+			select {
+			case s.events <- &e:
+				continue
+			default:
+			}
+			err = errStreamLagging
+			break
+		}
+		var reply StreamReply
+		if err = json.Unmarshal(m, &reply); err != nil {
+			break
+		}
+		s.mu.Lock()
+		c := s.pending[reply.ID]
+		delete(s.pending, reply.ID)
+		s.mu.Unlock()
+		if c != nil {
+			c <- &reply
+		}
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	close(s.done)
+	close(s.events)
+	s.conn.conn.Close()
+}
@@ -1,12 +1,23 @@
 package web
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/jephir/tradeblocks"
 	"github.com/jephir/tradeblocks/app"
+	"github.com/jephir/tradeblocks/db"
+	"github.com/jephir/tradeblocks/logging"
 )
 
 const base = "http://localhost:8080"
@@ -52,6 +63,199 @@ func TestWeb(t *testing.T) {
 	}
 }
 
+func TestStoreError(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	ts := httptest.NewServer(NewServer(app.NewBlockStoreWithStore(failingStore{db.NewMemoryStore()})))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	c.Retries = 0
+
+	// A block that can't be inserted is a server fault, not an invalid block
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.PostBlock(context.Background(), issue)
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusInternalServerError || !se.Temporary() {
+		t.Fatalf("expected status %d, got %v", http.StatusInternalServerError, err)
+	}
+	_, err = c.PostBatch(context.Background(), []tradeblocks.NetworkBlock{{Type: "account", Block: issue}}, false)
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status %d for a batch, got %v", http.StatusInternalServerError, err)
+	}
+}
+
+// failingStore is a store whose write transactions fail to insert blocks
+type failingStore struct {
+	db.Store
+}
+
+func (s failingStore) NewTransaction() (db.Tx, error) {
+	tx, err := s.Store.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	return failingTx{tx}, nil
+}
+
+type failingTx struct {
+	db.Tx
+}
+
+var errInsert = errors.New("disk full")
+
+func (failingTx) InsertAccountBlock(*tradeblocks.AccountBlock) error { return errInsert }
+func (failingTx) InsertSwapBlock(*tradeblocks.SwapBlock) error       { return errInsert }
+func (failingTx) InsertOrderBlock(*tradeblocks.OrderBlock) error     { return errInsert }
+func (failingTx) InsertConfirmBlock(*tradeblocks.ConfirmBlock) error { return errInsert }
+
+func TestValidationError(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	srv := NewServer(app.NewBlockStore())
+	client := NewClient(base)
+
+	// Send a block whose previous block doesn't exist
+	issue := tradeblocks.NewIssueBlock(a, 100)
+	b, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 50), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := client.NewPostAccountBlockRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	res := w.Result()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON body, got %s", ct)
+	}
+
+	var result tradeblocks.AccountBlock
+	err = client.DecodeAccountBlockResponse(res, &result)
+	e, ok := err.(*app.ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if e.Code != app.CodeInvalidPrevious || e.Field != "Previous" {
+		t.Fatalf("expected %s of Previous, got %s of %s", app.CodeInvalidPrevious, e.Code, e.Field)
+	}
+	if len(e.Hashes) != 1 || e.Hashes[0] != issue.Hash() {
+		t.Fatalf("expected hashes [%s], got %v", issue.Hash(), e.Hashes)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	store := app.NewBlockStore()
+	srv := NewServer(store)
+	client := NewClient(base)
+
+	validate := func(b *tradeblocks.AccountBlock) *ValidateResult {
+		req, err := client.NewValidateRequest("account", b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		result, err := client.DecodeValidateResponse(w.Result())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := validate(issue); !result.Valid || result.Hash != issue.Hash() || result.Error != nil {
+		t.Fatalf("expected a valid result for %s, got %+v", issue.Hash(), result)
+	}
+	if _, err := store.GetAccountBlock(issue.Hash()); err != db.ErrNotFound {
+		t.Fatalf("expected %v, got %v", db.ErrNotFound, err)
+	}
+
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 50), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := validate(send)
+	if result.Valid || result.Error == nil || result.Error.Code != app.CodeInvalidPrevious {
+		t.Fatalf("expected %s, got %+v", app.CodeInvalidPrevious, result)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	store := app.NewBlockStore()
+	srv := NewServer(store)
+	var handled []string
+	srv.BlockHandler = func(b app.TypedBlock) {
+		handled = append(handled, b.AccountBlock.Hash())
+	}
+	client := NewClient(base)
+
+	post := func(dryRun bool, blocks ...*tradeblocks.AccountBlock) ([]string, error) {
+		var batch []tradeblocks.NetworkBlock
+		for _, b := range blocks {
+			batch = append(batch, tradeblocks.NetworkBlock{Type: "account", Block: b})
+		}
+		req, err := client.NewPostBatchRequest(batch, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return client.DecodeBatchResponse(w.Result())
+	}
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 10), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdraft, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(send, a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = post(false, issue, send, overdraft)
+	be, ok := err.(*app.BatchError)
+	if !ok || be.Index != 2 {
+		t.Fatalf("expected block 2 to be rejected, got %v", err)
+	}
+	if e, ok := be.Err.(*app.ValidationError); !ok || e.Code != app.CodeNegativeBalance {
+		t.Fatalf("expected %s, got %v", app.CodeNegativeBalance, be.Err)
+	}
+	if _, err := post(true, issue, send); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 0 {
+		t.Fatalf("expected no handled blocks, got %v", handled)
+	}
+
+	hashes, err := post(false, issue, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || hashes[0] != issue.Hash() || hashes[1] != send.Hash() {
+		t.Fatalf("expected hashes [%s %s], got %v", issue.Hash(), send.Hash(), hashes)
+	}
+	if len(handled) != 2 || handled[0] != issue.Hash() || handled[1] != send.Hash() {
+		t.Fatalf("expected handled blocks %v, got %v", hashes, handled)
+	}
+	if _, err := store.GetAccountBlock(send.Hash()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestBootstrap(t *testing.T) {
 	p, a := app.CreateAccount(t)
 
@@ -76,7 +280,7 @@ func TestBootstrap(t *testing.T) {
 
 	// Create connecting server
 	client := NewClient(base)
-	req, err := client.NewGetAccountBlocksRequest()
+	req, err := client.NewGetAccountBlocksRequest(db.BlockFilter{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,12 +295,352 @@ func TestBootstrap(t *testing.T) {
 	}
 
 	// Check result
-	r1 := result[b1.Hash()].AccountBlock
-	if err := r1.Equals(b1); err != nil {
+	if len(result.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(result.Blocks))
+	}
+	if err := result.Blocks[0].Equals(b1); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Blocks[1].Equals(b2); err != nil {
+		t.Fatal(err)
+	}
+	if result.Next != 0 {
+		t.Fatalf("expected no next page, got %d", result.Next)
+	}
+}
+
+func TestBlocksPages(t *testing.T) {
+	p1, a1 := app.CreateAccount(t)
+	p2, a2 := app.CreateAccount(t)
+
+	store := app.NewBlockStore()
+	issue1, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a1, 100), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue2, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a2, 100), p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue1, a2, 10), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*tradeblocks.AccountBlock{issue1, issue2, send}
+	for _, b := range expect {
+		if err := store.AddAccountBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := NewServer(store)
+	client := NewClient(base)
+	do := func(r *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Result(), nil
+	}
+
+	// Page through every block
+	var got []tradeblocks.NetworkAccountBlock
+	var pages int
+	if err := client.AccountBlockPages(do, db.BlockFilter{Limit: 2}, func(page *AccountBlocksPage) error {
+		got = append(got, page.Blocks...)
+		pages++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Fatalf("expected 2 pages, got %d", pages)
+	}
+	if len(got) != len(expect) {
+		t.Fatalf("expected %d blocks, got %d", len(expect), len(got))
+	}
+	for i, b := range expect {
+		if err := got[i].Equals(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Filter by account and action
+	req, err := client.NewGetAccountBlocksRequest(db.BlockFilter{Account: a1, Action: "send"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.DecodeGetAccountBlocksResponse(mustDo(t, do, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Blocks) != 1 || result.Blocks[0].Hash() != send.Hash() {
+		t.Fatalf("expected only send %s, got %d blocks", send.Hash(), len(result.Blocks))
+	}
+
+	// Resume after a sequence
+	req, err = client.NewGetAccountBlocksRequest(db.BlockFilter{Since: got[1].Sequence})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = client.DecodeGetAccountBlocksResponse(mustDo(t, do, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Blocks) != 1 || result.Blocks[0].Hash() != send.Hash() {
+		t.Fatalf("expected only send %s, got %d blocks", send.Hash(), len(result.Blocks))
+	}
+}
+
+func TestExport(t *testing.T) {
+	p1, a1 := app.CreateAccount(t)
+	p2, a2 := app.CreateAccount(t)
+
+	store := app.NewBlockStore()
+	issue1, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a1, 100), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue2, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a2, 100), p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue1, a2, 10), p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*tradeblocks.AccountBlock{issue1, issue2, send}
+	for _, b := range expect {
+		if err := store.AddAccountBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := NewServer(store)
+	client := NewClient(base)
+	do := func(r *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Result(), nil
+	}
+
+	var got []*ExportRecord
+	cursor, err := client.Export(do, 0, func(e *ExportRecord) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(expect) {
+		t.Fatalf("expected %d records, got %d", len(expect), len(got))
+	}
+	for i, b := range expect {
+		e := got[i]
+		if e.Type != "account" || e.Hash != b.Hash() || e.Block.Hash() != b.Hash() || e.Sequence != i+1 || e.Time.IsZero() {
+			t.Fatalf("record %d is incorrect: %+v", i, e)
+		}
+	}
+	if cursor != 3 {
+		t.Fatalf("expected cursor 3, got %d", cursor)
+	}
+
+	// Resume from a cursor
+	got = nil
+	cursor, err = client.Export(do, 2, func(e *ExportRecord) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Hash != send.Hash() || cursor != 3 {
+		t.Fatalf("expected only send %s, got %d records and cursor %d", send.Hash(), len(got), cursor)
+	}
+
+	// A broken connection is detected
+	truncated := func(r *http.Request) (*http.Response, error) {
+		res, err := do(r)
+		res.Header.Set("TradeBlocks-Last-Sequence", "4")
+		return res, err
+	}
+	if _, err := client.Export(truncated, 0, func(e *ExportRecord) error {
+		return nil
+	}); err == nil {
+		t.Fatal("expected error for truncated export")
+	}
+}
+
+func mustDo(t *testing.T, do func(*http.Request) (*http.Response, error), r *http.Request) *http.Response {
+	res, err := do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestHistory(t *testing.T) {
+	p, a := app.CreateAccount(t)
+
+	store := app.NewBlockStore()
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 40), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAccountBlock(issue); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAccountBlock(send); err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(store)
+	client := NewClient(base)
+
+	req, err := client.NewGetHistoryRequest(a, a, "", 1)
+	if err != nil {
 		t.Fatal(err)
 	}
-	r2 := result[b2.Hash()].AccountBlock
-	if err := r2.Equals(b2); err != nil {
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	result, err := client.DecodeGetHistoryResponse(w.Result())
+	if err != nil {
 		t.Fatal(err)
 	}
+	if len(result.Entries) != 1 || result.Entries[0].Hash != send.Hash() {
+		t.Fatalf("expected send %s as the only entry, got %d entries", send.Hash(), len(result.Entries))
+	}
+	if result.Next != issue.Hash() {
+		t.Fatalf("expected next cursor %s, got %s", issue.Hash(), result.Next)
+	}
+
+	req, err = client.NewGetHistoryRequest(a, a, "", maxHistoryLimit+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if _, err := client.DecodeGetHistoryResponse(w.Result()); err == nil {
+		t.Fatal("expected error for limit over maximum")
+	}
+}
+
+// BenchmarkPostUnderReadLoad measures POST throughput of account blocks into a sqlite store while concurrent
+// clients dump blocks and poll heads
+func BenchmarkPostUnderReadLoad(b *testing.B) {
+	for _, readers := range []int{0, 8, 32} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			benchmarkPost(b, readers)
+		})
+	}
+}
+
+func benchmarkPost(b *testing.B, readers int) {
+	dir, err := ioutil.TempDir("", "tradeblocks")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := app.NewPersistBlockStore(filepath.Join(dir, "tradeblocks.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv := NewServer(store)
+	client := NewClient(base)
+	p, a := app.CreateAccount(b)
+	_, to := app.CreateAccount(b)
+
+	post := func(block *tradeblocks.AccountBlock) {
+		req, err := client.NewPostAccountBlockRequest(block)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("post failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, float64(b.N)), p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	post(issue)
+	sends := make([]*tradeblocks.AccountBlock, b.N)
+	previous := issue
+	for i := range sends {
+		sends[i], err = tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(previous, to, 1), p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		previous = sends[i]
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				blocks, _ := client.NewGetBlocksRequest(0, 100)
+				head, _ := client.NewGetAccountHeadRequest(a, a)
+				srv.ServeHTTP(httptest.NewRecorder(), blocks)
+				srv.ServeHTTP(httptest.NewRecorder(), head)
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for _, send := range sends {
+		post(send)
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func TestLogging(t *testing.T) {
+	p, a := app.CreateAccount(t)
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, "json", logging.LevelDebug, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(app.NewBlockStore())
+	srv.SetLogger(l.For("web"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := NewClient(ts.URL)
+
+	issue, err := tradeblocks.SignedAccountBlock(tradeblocks.NewIssueBlock(a, 100), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := tradeblocks.SignedAccountBlock(tradeblocks.NewSendBlock(issue, a, 200), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PostBlock(context.Background(), send); err == nil {
+		t.Fatal("expected an error for a send without an issue")
+	}
+
+	// Block records have the hash and address of the block
+	var record struct {
+		Msg       string `json:"msg"`
+		Subsystem string `json:"subsystem"`
+		Hash      string `json:"hash"`
+		Address   string `json:"address"`
+		Code      string `json:"code"`
+	}
+	if err := json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Msg != "block rejected" || record.Subsystem != "web" || record.Hash != send.Hash() || record.Address != a || record.Code != app.CodeInvalidPrevious {
+		t.Fatalf("unexpected record %s", buf.Bytes())
+	}
 }